@@ -0,0 +1,23 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"fmt"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+)
+
+// explainAPIError adds a hint on how to resolve the failure to common errors returned by the Grafana APIs.
+func explainAPIError(err error) error {
+	switch {
+	case client.IsUnauthorized(err):
+		return fmt.Errorf("%w: the configured token is invalid, expired or revoked, update the configuration with a valid token", err)
+	case client.IsQuotaExceeded(err):
+		return fmt.Errorf("%w: a quota of the Grafana instance was reached, delete unused service accounts or tokens or raise the quota", err)
+	case client.IsForbidden(err):
+		return fmt.Errorf("%w: the configured token is missing a permission required by the backend", err)
+	case client.IsRateLimited(err):
+		return fmt.Errorf("%w: requests were rate limited by Grafana, lower requests_per_second or max_in_flight in the configuration", err)
+	}
+
+	return err
+}
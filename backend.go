@@ -2,9 +2,11 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Boostport/vault-plugin-secrets-grafana/client"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -14,6 +16,7 @@ import (
 func Factory(version string) logical.Factory {
 	return func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
 		b := backend(version)
+		b.mountMarker = newMountMarker(conf.BackendUUID)
 		if err := b.Setup(ctx, conf); err != nil {
 			return nil, err
 		}
@@ -23,33 +26,82 @@ func Factory(version string) logical.Factory {
 
 type grafanaBackend struct {
 	*framework.Backend
-	lock   sync.RWMutex
-	client *client.Grafana
+	lock    sync.RWMutex
+	clients map[string]*client.Grafana // Cached clients by connection name
+
+	// mountMarker is embedded in the default names of the objects created by the mount
+	mountMarker string
+
+	// roleLock serializes writes of roles with the creation and updates of the shared service accounts they own
+	roleLock sync.Mutex
+
+	// staticRoleLock serializes static role rotations with reads and writes of static roles
+	staticRoleLock sync.RWMutex
+
+	// elevationLock serializes the checks that a Grafana user is not already elevated by another lease
+	elevationLock sync.Mutex
+
+	// configLock serializes updates of the mount configuration, including rotations of the configured token
+	configLock sync.Mutex
+
+	// lastExpiryCheck is when the expiry of the configured token of each connection was last looked up
+	lastExpiryCheck map[string]time.Time
+
+	// cleanupLock prevents tidy and revoke-all operations from running concurrently
+	cleanupLock sync.Mutex
+
+	// lastAutoTidy is when orphaned objects were last tidied by the periodic function
+	lastAutoTidy time.Time
+
+	// lastHistoryPrune is when expired credential history was last deleted by the periodic function
+	lastHistoryPrune time.Time
+
+	// lastLeaselessSweep is when the objects of expired credentials without a lease were last deleted by the
+	// periodic function
+	lastLeaselessSweep time.Time
+
+	// lastDisabledPurge is when service accounts disabled on revocation were last deleted by the periodic function
+	lastDisabledPurge time.Time
 }
 
 func backend(version string) *grafanaBackend {
-	var b grafanaBackend
+	b := grafanaBackend{
+		clients:         map[string]*client.Grafana{},
+		lastExpiryCheck: map[string]time.Time{},
+	}
 
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				"config",
+				"config/*",
 				"role/*",
+				"static-roles/*",
 			},
 		},
 		Paths: framework.PathAppend(
 			pathRole(&b),
+			pathStaticRole(&b),
+			pathConfig(&b),
+			pathTidy(&b),
+			pathIssued(&b),
+			pathHistory(&b),
 			[]*framework.Path{
-				pathConfig(&b),
+				pathRotateRoot(&b),
+				pathRevokeAll(&b),
 				pathCredentials(&b),
+				pathStaticCredentials(&b),
 			},
 		),
 		Secrets: []*framework.Secret{
 			b.grafanaToken(),
 		},
-		BackendType: logical.TypeLogical,
-		Invalidate:  b.invalidate,
+		BackendType:       logical.TypeLogical,
+		Invalidate:        b.invalidate,
+		PeriodicFunc:      b.periodicFunc,
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
 	}
 
 	if version != "" {
@@ -59,32 +111,65 @@ func backend(version string) *grafanaBackend {
 	return &b
 }
 
-func (b *grafanaBackend) reset() {
+func (b *grafanaBackend) reset(connection string) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	b.client = nil
+	delete(b.clients, connection)
 }
 
 func (b *grafanaBackend) invalidate(_ context.Context, key string) {
 	if key == configStoragePath {
-		b.reset()
+		b.reset(defaultConnection)
+	} else if connection, ok := strings.CutPrefix(key, configStoragePath+"/"); ok {
+		b.reset(connection)
 	}
 }
 
-func (b *grafanaBackend) getClient(ctx context.Context, s logical.Storage) (*client.Grafana, error) {
+func (b *grafanaBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	connections, err := listConnections(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, connection := range connections {
+		errs = append(errs,
+			b.rotateRootIfDue(ctx, req.Storage, connection),
+			b.checkTokenExpiry(ctx, req.Storage, connection),
+		)
+	}
+
+	errs = append(errs,
+		b.rotateStaticRoles(ctx, req.Storage),
+		b.autoTidy(ctx, req.Storage),
+		b.pruneHistory(ctx, req.Storage),
+		b.sweepLeaselessCredentials(ctx, req.Storage),
+		b.purgeDisabledServiceAccounts(ctx, req.Storage),
+	)
+
+	return errors.Join(errs...)
+}
+
+func (b *grafanaBackend) getClient(ctx context.Context, s logical.Storage, connection string) (*client.Grafana, error) {
 	b.lock.RLock()
 	unlockFunc := b.lock.RUnlock
 	defer func() { unlockFunc() }()
 
-	if b.client != nil {
-		return b.client, nil
+	if c, ok := b.clients[connection]; ok {
+		return c, nil
 	}
 
 	b.lock.RUnlock()
 	b.lock.Lock()
 	unlockFunc = b.lock.Unlock
 
-	config, err := getConfig(ctx, s)
+	// another request may have created the client while the lock was released
+	if c, ok := b.clients[connection]; ok {
+		return c, nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
@@ -93,19 +178,40 @@ func (b *grafanaBackend) getClient(ctx context.Context, s logical.Storage) (*cli
 		config = new(grafanaConfig)
 	}
 
+	c, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	b.clients[connection] = c
+
+	return c, nil
+}
+
+func newClient(config *grafanaConfig) (*client.Grafana, error) {
 	baseURL := strings.TrimSuffix(strings.ToLower(config.URL), "/")
 
-	b.client, err = client.New(baseURL, config.Token)
+	transport, err := client.NewTransport(config.transportConfig())
+	if err != nil {
+		return nil, fmt.Errorf("error creating transport: %w", err)
+	}
+
+	c, err := client.New(baseURL, config.Token,
+		client.WithTransport(transport),
+		client.WithTimeout(config.requestTimeout()),
+		client.WithRetries(config.maxRetries()),
+		client.WithRateLimit(config.RequestsPerSecond, config.MaxInFlight),
+	)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating grafana client: %w", err)
 	}
 
-	return b.client, nil
+	return c, nil
 }
 
 const backendHelp = `
 The Grafana secrets backend dynamically generates Grafana Cloud Access Policy tokens and Grafana Service Account tokens.
 After mounting this backend, credentials to manage Grafana Cloud or Grafana tokens must be configured with the
-"config/" endpoint.
+"config" endpoint. Additional connections can be configured with the "config/<connection>" endpoint.
 `
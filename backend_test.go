@@ -2,8 +2,16 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,6 +48,706 @@ func getTestBackend(tb testing.TB) (*grafanaBackend, logical.Storage) {
 	return b.(*grafanaBackend), config.StorageView
 }
 
+// fakeGrafana is a minimal in-memory implementation of the Grafana service account API used by unit tests.
+type fakeGrafana struct {
+	*httptest.Server
+
+	lock            sync.Mutex
+	nextID          int64
+	serviceAccounts map[int64]*fakeServiceAccount
+	accessPolicies  map[string]*fakeAccessPolicy
+	users           map[int64]*fakeUser
+	rbacRoles       []client.Role
+
+	// failDeletes makes deleting service accounts fail
+	failDeletes bool
+
+	// failRoleAssignments makes assigning RBAC roles to service accounts and users fail
+	failRoleAssignments bool
+
+	// failTokens makes creating service account and access policy tokens fail
+	failTokens bool
+
+	// tokenSecondsToLive is the lifetime requested for each service account token by its ID
+	tokenSecondsToLive map[int64]int64
+}
+
+type fakeAccessPolicy struct {
+	Policy client.CloudAccessPolicy
+	Tokens map[string]client.CloudAccessPolicyToken
+}
+
+type fakeUser struct {
+	Login    string
+	Password string
+	OrgID    int64
+	Role     string
+	RoleUIDs []string // RBAC roles assigned to the user
+}
+
+type fakeServiceAccount struct {
+	Name       string
+	Role       string
+	IsDisabled bool
+	CreatedAt  time.Time
+	Tokens     map[int64]string
+}
+
+func newFakeGrafana(tb testing.TB) *fakeGrafana {
+	tb.Helper()
+
+	return startFakeGrafana(tb, httptest.NewServer)
+}
+
+// newFakeGrafanaTLS starts the fake Grafana with a self-signed certificate.
+func newFakeGrafanaTLS(tb testing.TB) *fakeGrafana {
+	tb.Helper()
+
+	return startFakeGrafana(tb, httptest.NewTLSServer)
+}
+
+func startFakeGrafana(tb testing.TB, newServer func(http.Handler) *httptest.Server) *fakeGrafana {
+	tb.Helper()
+
+	f := &fakeGrafana{
+		serviceAccounts:    map[int64]*fakeServiceAccount{},
+		accessPolicies:     map[string]*fakeAccessPolicy{},
+		users:              map[int64]*fakeUser{},
+		tokenSecondsToLive: map[int64]int64{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/health", f.health)
+	mux.HandleFunc("GET /api/user", f.currentUser)
+	mux.HandleFunc("GET /api/access-control/user/permissions", f.currentUserPermissions)
+	mux.HandleFunc("GET /api/serviceaccounts/search", f.searchServiceAccounts)
+	mux.HandleFunc("POST /api/serviceaccounts", f.createServiceAccount)
+	mux.HandleFunc("GET /api/serviceaccounts/{id}", f.getServiceAccount)
+	mux.HandleFunc("PATCH /api/serviceaccounts/{id}", f.updateServiceAccount)
+	mux.HandleFunc("DELETE /api/serviceaccounts/{id}", f.deleteServiceAccount)
+	mux.HandleFunc("GET /api/serviceaccounts/{id}/tokens", f.listServiceAccountTokens)
+	mux.HandleFunc("POST /api/serviceaccounts/{id}/tokens", f.createServiceAccountToken)
+	mux.HandleFunc("DELETE /api/serviceaccounts/{id}/tokens/{tokenID}", f.deleteServiceAccountToken)
+	mux.HandleFunc("GET /api/instances/{stack}", f.getStack)
+	mux.HandleFunc("POST /api/admin/users", f.createUser)
+	mux.HandleFunc("DELETE /api/admin/users/{id}", f.deleteUser)
+	mux.HandleFunc("GET /api/users/lookup", f.lookupUser)
+	mux.HandleFunc("PATCH /api/org/users/{id}", f.updateOrgUser)
+	mux.HandleFunc("GET /api/org/users/search", f.searchOrgUsers)
+	mux.HandleFunc("GET /api/access-control/roles", f.listRBACRoles)
+	mux.HandleFunc("GET /api/access-control/users/{id}/roles", f.getUserRoles)
+	mux.HandleFunc("PUT /api/access-control/users/{id}/roles", f.setUserRoles)
+
+	// Requests to the Grafana API of stacks proxied through Grafana Cloud are served by the same fake instance.
+	mux.HandleFunc("GET /api/instances/{stack}/api/serviceaccounts/search", f.searchServiceAccounts)
+	mux.HandleFunc("POST /api/instances/{stack}/api/serviceaccounts", f.createServiceAccount)
+	mux.HandleFunc("GET /api/instances/{stack}/api/serviceaccounts/{id}", f.getServiceAccount)
+	mux.HandleFunc("PATCH /api/instances/{stack}/api/serviceaccounts/{id}", f.updateServiceAccount)
+	mux.HandleFunc("DELETE /api/instances/{stack}/api/serviceaccounts/{id}", f.deleteServiceAccount)
+	mux.HandleFunc("GET /api/instances/{stack}/api/serviceaccounts/{id}/tokens", f.listServiceAccountTokens)
+	mux.HandleFunc("POST /api/instances/{stack}/api/serviceaccounts/{id}/tokens", f.createServiceAccountToken)
+	mux.HandleFunc("DELETE /api/instances/{stack}/api/serviceaccounts/{id}/tokens/{tokenID}", f.deleteServiceAccountToken)
+
+	mux.HandleFunc("GET /api/v1/accesspolicies", f.listAccessPolicies)
+	mux.HandleFunc("POST /api/v1/accesspolicies", f.createAccessPolicy)
+	mux.HandleFunc("GET /api/v1/accesspolicies/{id}", f.getAccessPolicy)
+	mux.HandleFunc("POST /api/v1/accesspolicies/{id}", f.updateAccessPolicy)
+	mux.HandleFunc("DELETE /api/v1/accesspolicies/{id}", f.deleteAccessPolicy)
+	mux.HandleFunc("GET /api/v1/tokens", f.listAccessPolicyTokens)
+	mux.HandleFunc("POST /api/v1/tokens", f.createAccessPolicyToken)
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", f.deleteAccessPolicyToken)
+
+	f.Server = newServer(mux)
+	tb.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeGrafana) addServiceAccount(name, role string) int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.nextID++
+	f.serviceAccounts[f.nextID] = &fakeServiceAccount{Name: name, Role: role, CreatedAt: time.Now(), Tokens: map[int64]string{}}
+
+	return f.nextID
+}
+
+// age backdates the creation of a service account or access policy.
+func (f *fakeGrafana) age(id interface{}, age time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch id := id.(type) {
+	case int64:
+		f.serviceAccounts[id].CreatedAt = time.Now().Add(-age)
+	case string:
+		f.accessPolicies[id].Policy.CreatedAt = time.Now().Add(-age)
+	}
+}
+
+// serviceAccountExists reports whether a service account exists.
+func (f *fakeGrafana) serviceAccountExists(id int64) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, ok := f.serviceAccounts[id]
+	return ok
+}
+
+// accessPolicyExists reports whether an access policy exists.
+func (f *fakeGrafana) accessPolicyExists(id string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, ok := f.accessPolicies[id]
+	return ok
+}
+
+// user returns a copy of a user and whether it exists.
+func (f *fakeGrafana) user(id int64) (fakeUser, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	user, ok := f.users[id]
+	if !ok {
+		return fakeUser{}, false
+	}
+
+	return *user, true
+}
+
+// addUser adds a user with a basic role in the organization and returns its ID.
+func (f *fakeGrafana) addUser(login, role string) int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.nextID++
+	f.users[f.nextID] = &fakeUser{Login: login, OrgID: 1, Role: role}
+
+	return f.nextID
+}
+
+// addRBACRole adds an RBAC role and returns its UID.
+func (f *fakeGrafana) addRBACRole(name string, global bool) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	uid := fmt.Sprintf("role-%d", len(f.rbacRoles)+1)
+	f.rbacRoles = append(f.rbacRoles, client.Role{UID: uid, Name: name, Global: global})
+
+	return uid
+}
+
+// assignRBACRoles assigns RBAC roles to a user.
+func (f *fakeGrafana) assignRBACRoles(userID int64, uids ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.users[userID].RoleUIDs = append(f.users[userID].RoleUIDs, uids...)
+}
+
+// addServiceAccountToken adds a token to a service account and returns its key.
+func (f *fakeGrafana) addServiceAccountToken(serviceAccountID int64) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.nextID++
+	key := fmt.Sprintf("glsa_%d", f.nextID)
+	f.serviceAccounts[serviceAccountID].Tokens[f.nextID] = key
+
+	return key
+}
+
+// addAccessPolicyToken adds a token to an access policy and returns its key.
+func (f *fakeGrafana) addAccessPolicyToken(accessPolicyID, name, region string, expiresAt *time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.mintAccessPolicyToken(f.accessPolicies[accessPolicyID], client.CreateCloudAccessPolicyTokenInput{
+		AccessPolicyID: accessPolicyID,
+		Name:           name,
+		ExpiresAt:      expiresAt,
+	}, region).Token
+}
+
+func (f *fakeGrafana) mintAccessPolicyToken(policy *fakeAccessPolicy, input client.CreateCloudAccessPolicyTokenInput, region string) client.CloudAccessPolicyToken {
+	f.nextID++
+	token := client.CloudAccessPolicyToken{
+		ID:             fmt.Sprintf("token-%d", f.nextID),
+		AccessPolicyID: input.AccessPolicyID,
+		Name:           input.Name,
+		DisplayName:    input.DisplayName,
+		ExpiresAt:      input.ExpiresAt,
+		CreatedAt:      time.Now(),
+	}
+	policy.Tokens[token.ID] = token
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"o": "1",
+		"n": policy.Policy.Name + "-" + input.Name,
+		"k": fmt.Sprintf("secret%d", f.nextID),
+		"m": map[string]string{"r": region},
+	})
+	token.Token = "glc_" + base64.StdEncoding.EncodeToString(payload)
+
+	return token
+}
+
+func (f *fakeGrafana) tokens(serviceAccountID int64) map[int64]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	tokens := map[int64]string{}
+
+	if sa, ok := f.serviceAccounts[serviceAccountID]; ok {
+		for id, key := range sa.Tokens {
+			tokens[id] = key
+		}
+	}
+
+	return tokens
+}
+
+func (f *fakeGrafana) addAccessPolicy(name string, scopes []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.nextID++
+	id := fmt.Sprintf("policy-%d", f.nextID)
+	f.accessPolicies[id] = &fakeAccessPolicy{
+		Policy: client.CloudAccessPolicy{ID: id, Name: name, DisplayName: name, Scopes: scopes, CreatedAt: time.Now()},
+		Tokens: map[string]client.CloudAccessPolicyToken{},
+	}
+
+	return id
+}
+
+func (f *fakeGrafana) accessPolicyTokens(accessPolicyID string) map[string]client.CloudAccessPolicyToken {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	tokens := map[string]client.CloudAccessPolicyToken{}
+
+	if policy, ok := f.accessPolicies[accessPolicyID]; ok {
+		for id, token := range policy.Tokens {
+			tokens[id] = token
+		}
+	}
+
+	return tokens
+}
+
+func (f *fakeGrafana) listAccessPolicies(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var items []client.CloudAccessPolicy
+
+	for _, policy := range f.accessPolicies {
+		items = append(items, policy.Policy)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func (f *fakeGrafana) getAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	policy, ok := f.accessPolicies[r.PathValue("id")]
+	if !ok {
+		http.Error(w, `{"message":"access policy not found"}`, http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(policy.Policy)
+}
+
+func (f *fakeGrafana) createAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var input client.CreateCloudAccessPolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	for _, policy := range f.accessPolicies {
+		if policy.Policy.Name == input.Name {
+			http.Error(w, `{"message":"access policy already exists"}`, http.StatusConflict)
+			return
+		}
+	}
+
+	f.nextID++
+	id := fmt.Sprintf("policy-%d", f.nextID)
+	f.accessPolicies[id] = &fakeAccessPolicy{
+		Policy: client.CloudAccessPolicy{
+			ID:          id,
+			Name:        input.Name,
+			DisplayName: input.DisplayName,
+			Scopes:      input.Scopes,
+			Realms:      input.Realms,
+			CreatedAt:   time.Now(),
+		},
+		Tokens: map[string]client.CloudAccessPolicyToken{},
+	}
+
+	_ = json.NewEncoder(w).Encode(f.accessPolicies[id].Policy)
+}
+
+func (f *fakeGrafana) updateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	policy, ok := f.accessPolicies[r.PathValue("id")]
+	if !ok {
+		http.Error(w, `{"message":"access policy not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var input client.UpdateCloudAccessPolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	policy.Policy.Scopes = input.Scopes
+	policy.Policy.Realms = input.Realms
+
+	_ = json.NewEncoder(w).Encode(policy.Policy)
+}
+
+func (f *fakeGrafana) deleteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.accessPolicies[r.PathValue("id")]; !ok {
+		http.Error(w, `{"message":"access policy not found"}`, http.StatusNotFound)
+		return
+	}
+
+	delete(f.accessPolicies, r.PathValue("id"))
+}
+
+func (f *fakeGrafana) listAccessPolicyTokens(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var items []client.CloudAccessPolicyToken
+
+	if policy, ok := f.accessPolicies[r.URL.Query().Get("accessPolicyId")]; ok {
+		for _, token := range policy.Tokens {
+			items = append(items, token)
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func (f *fakeGrafana) createAccessPolicyToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failTokens {
+		http.Error(w, `{"message":"token quota exceeded"}`, http.StatusForbidden)
+		return
+	}
+
+	var input client.CreateCloudAccessPolicyTokenInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	policy, ok := f.accessPolicies[input.AccessPolicyID]
+	if !ok {
+		http.Error(w, `{"message":"access policy not found"}`, http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(f.mintAccessPolicyToken(policy, input, r.URL.Query().Get("region")))
+}
+
+func (f *fakeGrafana) deleteAccessPolicyToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, policy := range f.accessPolicies {
+		if _, ok := policy.Tokens[r.PathValue("id")]; ok {
+			delete(policy.Tokens, r.PathValue("id"))
+			return
+		}
+	}
+
+	http.Error(w, `{"message":"token not found"}`, http.StatusNotFound)
+}
+
+func (f *fakeGrafana) lookup(w http.ResponseWriter, r *http.Request) (int64, *fakeServiceAccount, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"message":"invalid id"}`, http.StatusBadRequest)
+		return 0, nil, false
+	}
+
+	sa, ok := f.serviceAccounts[id]
+	if !ok {
+		http.Error(w, `{"message":"service account not found"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+
+	return id, sa, true
+}
+
+// authenticate looks up the service account owning the bearer token of the request.
+func (f *fakeGrafana) authenticate(w http.ResponseWriter, r *http.Request) (int64, *fakeServiceAccount, bool) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	for id, sa := range f.serviceAccounts {
+		for _, k := range sa.Tokens {
+			if k == key {
+				return id, sa, true
+			}
+		}
+	}
+
+	http.Error(w, `{"message":"invalid API key"}`, http.StatusUnauthorized)
+	return 0, nil, false
+}
+
+func (f *fakeGrafana) health(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(client.Health{Database: "ok", Version: "11.0.0"})
+}
+
+func (f *fakeGrafana) currentUser(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id, sa, ok := f.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(client.User{ID: id, Name: sa.Name, Login: "sa-" + sa.Name, OrgID: 1})
+}
+
+// currentUserPermissions grants the permissions required by the backend to service accounts with the Admin role.
+func (f *fakeGrafana) currentUserPermissions(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, sa, ok := f.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	permissions := map[string][]string{}
+
+	if sa.Role == "Admin" {
+		for _, action := range requiredGrafanaPermissions {
+			permissions[action] = []string{"*"}
+		}
+	} else {
+		permissions["serviceaccounts:read"] = []string{"*"}
+	}
+
+	_ = json.NewEncoder(w).Encode(permissions)
+}
+
+func (f *fakeGrafana) listServiceAccountTokens(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, sa, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	result := []client.ServiceAccountTokenInfo{}
+
+	for id := range sa.Tokens {
+		result = append(result, client.ServiceAccountTokenInfo{ID: id, Name: fmt.Sprintf("token-%d", id)})
+	}
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeGrafana) searchServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	query := strings.ToLower(r.URL.Query().Get("query"))
+
+	var ids []int64
+
+	for id, sa := range f.serviceAccounts {
+		if strings.Contains(strings.ToLower(sa.Name), query) {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("perpage"))
+	if err != nil || perPage < 1 {
+		perPage = 1000
+	}
+
+	result := client.ServiceAccountSearchResult{TotalCount: int64(len(ids)), Page: int64(page), PerPage: int64(perPage)}
+
+	// Like Grafana, the search results do not include when the service accounts were created.
+	for _, id := range ids[min((page-1)*perPage, len(ids)):min(page*perPage, len(ids))] {
+		sa := f.serviceAccounts[id]
+		result.ServiceAccounts = append(result.ServiceAccounts, client.ServiceAccount{ID: id, Name: sa.Name, Role: sa.Role})
+	}
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeGrafana) createServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var input client.CreateServiceAccountInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	f.lock.Lock()
+	for _, sa := range f.serviceAccounts {
+		if sa.Name == input.Name {
+			f.lock.Unlock()
+			http.Error(w, `{"message":"service account already exists"}`, http.StatusConflict)
+			return
+		}
+	}
+	f.lock.Unlock()
+
+	id := f.addServiceAccount(input.Name, input.Role)
+	_ = json.NewEncoder(w).Encode(client.ServiceAccount{ID: id, Name: input.Name, Role: input.Role})
+}
+
+func (f *fakeGrafana) getServiceAccount(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id, sa, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(client.ServiceAccount{ID: id, Name: sa.Name, Role: sa.Role, CreatedAt: sa.CreatedAt})
+}
+
+func (f *fakeGrafana) updateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id, sa, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var input client.UpdateServiceAccountInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	if input.Name != "" {
+		sa.Name = input.Name
+	}
+
+	if input.Role != "" {
+		sa.Role = input.Role
+	}
+
+	if input.IsDisabled != nil {
+		sa.IsDisabled = *input.IsDisabled
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             id,
+		"name":           sa.Name,
+		"message":        "Service account updated",
+		"serviceaccount": client.ServiceAccount{ID: id, Name: sa.Name, Role: sa.Role, IsDisabled: sa.IsDisabled, CreatedAt: sa.CreatedAt},
+	})
+}
+
+func (f *fakeGrafana) getStack(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(client.Stack{Slug: r.PathValue("stack"), URL: f.URL})
+}
+
+func (f *fakeGrafana) deleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id, _, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if f.failDeletes {
+		http.Error(w, `{"message":"permission denied"}`, http.StatusForbidden)
+		return
+	}
+
+	delete(f.serviceAccounts, id)
+	_, _ = w.Write([]byte(`{"message":"Service account deleted"}`))
+}
+
+func (f *fakeGrafana) createServiceAccountToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failTokens {
+		http.Error(w, `{"message":"token quota exceeded"}`, http.StatusForbidden)
+		return
+	}
+
+	_, sa, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var input client.CreateServiceAccountTokenInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	f.nextID++
+	key := fmt.Sprintf("glsa_%d", f.nextID)
+	sa.Tokens[f.nextID] = key
+	f.tokenSecondsToLive[f.nextID] = input.SecondsToLive
+
+	_ = json.NewEncoder(w).Encode(client.ServiceAccountToken{ID: f.nextID, Name: input.Name, Key: key})
+}
+
+func (f *fakeGrafana) deleteServiceAccountToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, sa, ok := f.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(r.PathValue("tokenID"), 10, 64)
+	if _, exists := sa.Tokens[tokenID]; err != nil || !exists {
+		http.Error(w, `{"message":"token not found"}`, http.StatusNotFound)
+		return
+	}
+
+	delete(sa.Tokens, tokenID)
+	_, _ = w.Write([]byte(`{"message":"API key deleted"}`))
+}
+
 // runAcceptanceTests will separate unit tests from
 // acceptance tests, which will make active requests
 // to your target API.
@@ -86,12 +794,12 @@ func (e *testCloudEnv) AddConfig(t *testing.T) {
 func (e *testCloudEnv) GetInstanceEnv(t *testing.T) *testInstanceEnv {
 
 	b := e.Backend.(*grafanaBackend)
-	c, err := b.getClient(e.Context, e.Storage)
+	c, err := b.getClient(e.Context, e.Storage, defaultConnection)
 	if err != nil {
 		t.Fatal("error getting client")
 	}
 
-	stack, err := c.StackBySlug(e.CloudStackSlug)
+	stack, err := c.StackBySlug(e.Context, e.CloudStackSlug)
 	if err != nil {
 		t.Fatalf("unexpected error getting stack: %s", err)
 	}
@@ -238,14 +946,14 @@ func (e *testCloudEnv) CleanupCreds(t *testing.T) {
 	}
 
 	b := e.Backend.(*grafanaBackend)
-	client, err := b.getClient(e.Context, e.Storage)
+	client, err := b.getClient(e.Context, e.Storage, defaultConnection)
 	if err != nil {
 		t.Fatal("error getting client")
 	}
 
 	if len(e.AccessPolicyIDs) > 0 {
 		for _, id := range e.AccessPolicyIDs {
-			err = client.DeleteCloudAccessPolicy(e.CloudRegion, id)
+			err = client.DeleteCloudAccessPolicy(e.Context, e.CloudRegion, id)
 			if err != nil {
 				t.Fatalf("unexpected error deleting access policy: %s", err)
 			}
@@ -254,7 +962,7 @@ func (e *testCloudEnv) CleanupCreds(t *testing.T) {
 
 	if len(e.ServiceAccountIDs) > 0 {
 		for _, id := range e.ServiceAccountIDs {
-			err = client.DeleteGrafanaServiceAccountFromCloud(e.CloudStackSlug, id)
+			err = client.DeleteGrafanaServiceAccountFromCloud(e.Context, e.CloudStackSlug, id)
 			if err != nil {
 				t.Fatalf("unexpected error deleting service account: %s", err)
 			}
@@ -281,7 +989,7 @@ type testInstanceEnv struct {
 
 func (e *testInstanceEnv) AddCustomGrafanaRole(t *testing.T) {
 	b := e.Backend.(*grafanaBackend)
-	c, err := b.getClient(e.Context, e.Storage)
+	c, err := b.getClient(e.Context, e.Storage, defaultConnection)
 	if err != nil {
 		t.Fatal("error getting client")
 	}
@@ -290,7 +998,7 @@ func (e *testInstanceEnv) AddCustomGrafanaRole(t *testing.T) {
 		Name: customGrafanaRoleName,
 	}
 
-	resp, err := c.CreateCustomRole(input)
+	resp, err := c.CreateCustomRole(e.Context, input)
 	if err != nil {
 		t.Fatalf("unexpected error creating custom role: %s", err)
 	}
@@ -346,13 +1054,13 @@ func (e *testInstanceEnv) CleanupCustomRoles(t *testing.T) {
 	}
 
 	b := e.Backend.(*grafanaBackend)
-	c, err := b.getClient(e.Context, e.Storage)
+	c, err := b.getClient(e.Context, e.Storage, defaultConnection)
 	if err != nil {
 		t.Fatal("error getting client")
 	}
 
 	for _, id := range e.CustomRoleIDs {
-		err = c.DeleteCustomRole(id)
+		err = c.DeleteCustomRole(e.Context, id)
 		if err != nil {
 			t.Fatalf("unexpected error deleting custom role: %s", err)
 		}
@@ -366,15 +1074,244 @@ func (e *testInstanceEnv) CleanupCreds(t *testing.T) {
 	}
 
 	b := e.Backend.(*grafanaBackend)
-	client, err := b.getClient(e.Context, e.Storage)
+	client, err := b.getClient(e.Context, e.Storage, defaultConnection)
 	if err != nil {
 		t.Fatal("error getting client")
 	}
 
 	for _, id := range e.ServiceAccountIDs {
-		err = client.DeleteServiceAccount(id)
+		err = client.DeleteServiceAccount(e.Context, id)
 		if err != nil {
 			t.Fatalf("unexpected error deleting service account: %s", err)
 		}
 	}
 }
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/serviceaccounts/1":
+			w.Header().Set("X-Request-Id", "request-1")
+			http.Error(w, `{"message":"service account not found","messageId":"serviceaccounts.ErrNotFound"}`, http.StatusNotFound)
+		case "/api/serviceaccounts/2":
+			http.Error(w, `{"message":"Quota reached"}`, http.StatusForbidden)
+		case "/api/serviceaccounts/3":
+			http.Error(w, `{"message":"Permission denied","traceID":"trace-3"}`, http.StatusForbidden)
+		default:
+			http.Error(w, "conflict", http.StatusConflict)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := client.New(server.URL, token)
+	require.NoError(t, err)
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := c.GetServiceAccount(context.Background(), 1)
+
+		var apiErr *client.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		require.Equal(t, "service account not found", apiErr.Message)
+		require.Equal(t, "serviceaccounts.ErrNotFound", apiErr.MessageID)
+		require.Equal(t, "request-1", apiErr.RequestID)
+		require.True(t, client.IsNotFound(err))
+		require.True(t, client.IsNotFound(fmt.Errorf("wrapped: %w", err)))
+		require.False(t, client.IsConflict(err))
+		require.EqualError(t, err, "error getting service account: error response from server (404): service account not found (message id: serviceaccounts.ErrNotFound, request id: request-1)")
+	})
+
+	t.Run("quota exceeded", func(t *testing.T) {
+		_, err := c.GetServiceAccount(context.Background(), 2)
+		require.True(t, client.IsQuotaExceeded(err))
+		require.False(t, client.IsForbidden(err))
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		_, err := c.GetServiceAccount(context.Background(), 3)
+		require.True(t, client.IsForbidden(err))
+		require.False(t, client.IsQuotaExceeded(err))
+
+		var apiErr *client.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, "trace-3", apiErr.RequestID)
+	})
+
+	t.Run("plain text body", func(t *testing.T) {
+		_, err := c.GetServiceAccount(context.Background(), 4)
+		require.True(t, client.IsConflict(err))
+		require.EqualError(t, err, "error getting service account: error response from server (409): conflict")
+	})
+}
+
+func (f *fakeGrafana) lookupFakeUser(w http.ResponseWriter, r *http.Request) (int64, *fakeUser, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"message":"invalid id"}`, http.StatusBadRequest)
+		return 0, nil, false
+	}
+
+	user, ok := f.users[id]
+	if !ok {
+		http.Error(w, `{"message":"user not found"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+
+	return id, user, true
+}
+
+func (f *fakeGrafana) createUser(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var input client.CreateUserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	for _, user := range f.users {
+		if user.Login == input.Login {
+			http.Error(w, `{"message":"user already exists"}`, http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	f.nextID++
+	f.users[f.nextID] = &fakeUser{Login: input.Login, Password: input.Password, OrgID: input.OrgID, Role: "Viewer"}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": f.nextID, "message": "User created"})
+}
+
+func (f *fakeGrafana) lookupUser(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	login := r.URL.Query().Get("loginOrEmail")
+
+	for id, user := range f.users {
+		if user.Login == login {
+			_ = json.NewEncoder(w).Encode(client.User{ID: id, Login: user.Login, OrgID: user.OrgID})
+			return
+		}
+	}
+
+	http.Error(w, `{"message":"user not found"}`, http.StatusNotFound)
+}
+
+func (f *fakeGrafana) updateOrgUser(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, user, ok := f.lookupFakeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	user.Role = input.Role
+	_, _ = w.Write([]byte(`{"message":"Organization user updated"}`))
+}
+
+func (f *fakeGrafana) deleteUser(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id, _, ok := f.lookupFakeUser(w, r)
+	if !ok {
+		return
+	}
+
+	delete(f.users, id)
+	_, _ = w.Write([]byte(`{"message":"User deleted"}`))
+}
+
+func (f *fakeGrafana) searchOrgUsers(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	query := r.URL.Query().Get("query")
+	orgUsers := []client.OrgUser{}
+
+	for id, user := range f.users {
+		if strings.Contains(user.Login, query) {
+			orgUsers = append(orgUsers, client.OrgUser{UserID: id, Login: user.Login, Role: user.Role})
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"orgUsers": orgUsers})
+}
+
+func (f *fakeGrafana) listRBACRoles(w http.ResponseWriter, _ *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_ = json.NewEncoder(w).Encode(f.rbacRoles)
+}
+
+func (f *fakeGrafana) getUserRoles(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, user, ok := f.lookupFakeUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles := []client.Role{}
+
+	for _, role := range f.rbacRoles {
+		if slices.Contains(user.RoleUIDs, role.UID) {
+			roles = append(roles, role)
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(roles)
+}
+
+// setUserRoles replaces the RBAC roles of a user like Grafana does, leaving its global roles alone. The roles of
+// service accounts are accepted but not recorded.
+func (f *fakeGrafana) setUserRoles(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failRoleAssignments {
+		http.Error(w, `{"message":"permission denied"}`, http.StatusForbidden)
+		return
+	}
+
+	if id, err := strconv.ParseInt(r.PathValue("id"), 10, 64); err == nil && f.serviceAccounts[id] != nil {
+		_, _ = w.Write([]byte(`{"message":"User roles have been set"}`))
+		return
+	}
+
+	_, user, ok := f.lookupFakeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input client.ServiceAccountRoleAssignmentsInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RoleUIDs == nil {
+		http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	roleUIDs := slices.Clone(input.RoleUIDs)
+
+	for _, role := range f.rbacRoles {
+		if role.Global && slices.Contains(user.RoleUIDs, role.UID) {
+			roleUIDs = append(roleUIDs, role.UID)
+		}
+	}
+
+	user.RoleUIDs = roleUIDs
+	_, _ = w.Write([]byte(`{"message":"User roles have been set"}`))
+}
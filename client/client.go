@@ -3,51 +3,185 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
-	defaultTimeout = 30 * time.Second
+	DefaultTimeout    = 30 * time.Second
+	defaultMinBackoff = 250 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
 )
 
 type Grafana struct {
 	client      *http.Client
 	bearerToken string
 	baseURL     url.URL
+	timeout     time.Duration
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	limiter  *rate.Limiter // nil when requests are not rate limited
+	inFlight chan struct{} // nil when the number of requests in flight is not limited
+}
+
+type Option func(*Grafana)
+
+// WithTransport sets the HTTP transport used to connect to Grafana.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(g *Grafana) {
+		g.client.Transport = transport
+	}
+}
+
+// WithTimeout sets how long a request may take, including retries. A timeout of 0 uses the default timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(g *Grafana) {
+		if timeout > 0 {
+			g.timeout = timeout
+		}
+	}
+}
+
+// WithRetries sets how often a failed request is retried. See retryable for the failures that are retried.
+func WithRetries(maxRetries int) Option {
+	return func(g *Grafana) {
+		g.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the bounds of the exponential backoff between retries.
+func WithBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(g *Grafana) {
+		g.minBackoff = minBackoff
+		g.maxBackoff = maxBackoff
+	}
 }
 
-func New(baseURL, bearerToken string) (*Grafana, error) {
+// WithRateLimit limits the number of requests per second and the number of requests in flight. A limit of 0
+// disables the respective limit.
+func WithRateLimit(requestsPerSecond float64, maxInFlight int) Option {
+	return func(g *Grafana) {
+		g.limiter = nil
+		g.inFlight = nil
+
+		if requestsPerSecond > 0 {
+			g.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), max(1, int(requestsPerSecond)))
+		}
+
+		if maxInFlight > 0 {
+			g.inFlight = make(chan struct{}, maxInFlight)
+		}
+	}
+}
+
+func New(baseURL, bearerToken string, opts ...Option) (*Grafana, error) {
 
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing base URL: %w", err)
 	}
 
-	return &Grafana{
+	g := &Grafana{
 		client:      &http.Client{},
 		bearerToken: bearerToken,
 		baseURL:     *u,
-	}, nil
+		timeout:     DefaultTimeout,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g, nil
 }
 
-func (g *Grafana) do(method, requestPath string, query url.Values, body []byte, responseStruct interface{}) error {
+func (g *Grafana) do(ctx context.Context, method, requestPath string, query url.Values, body []byte, responseStruct interface{}) error {
 	requestURL := g.baseURL
 	requestURL.Path = path.Join(requestURL.Path, requestPath)
 	requestURL.RawQuery = query.Encode()
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	// The timeout applies to all attempts of the request, including the time spent waiting between them.
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), bytes.NewBuffer(body))
+	for attempt := 0; ; attempt++ {
+		respBody, err := g.doOnce(ctx, method, requestURL.String(), body)
+
+		if err == nil {
+			return decodeResponse(respBody, responseStruct)
+		}
+
+		if attempt >= g.maxRetries || ctx.Err() != nil || !retryable(method, err) {
+			return err
+		}
+
+		// Retrying will not help if the certificate of the server cannot be verified.
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return err
+		}
+
+		var retryAfter time.Duration
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.retryAfter
+		}
+
+		wait := g.backoff(attempt, retryAfter)
+
+		// Give up straight away if the request would time out while waiting.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// doOnce makes a single attempt of a request and returns the body of the response. Responses with a status code
+// outside the 2xx range are returned as an *APIError, any other error means that no response was received.
+func (g *Grafana) doOnce(ctx context.Context, method, requestURL string, body []byte) ([]byte, error) {
+	if g.limiter != nil {
+		if err := g.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("error waiting for rate limiter: %w", err)
+		}
+	}
+
+	if g.inFlight != nil {
+		select {
+		case g.inFlight <- struct{}{}:
+			defer func() { <-g.inFlight }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error waiting for requests in flight: %w", ctx.Err())
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
 
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", g.bearerToken))
@@ -56,27 +190,30 @@ func (g *Grafana) do(method, requestPath string, query url.Values, body []byte,
 	resp, err := g.client.Do(req)
 
 	if err != nil {
-		return fmt.Errorf("error making request: %w", err)
+		return nil, fmt.Errorf("error making request: %w", err)
 	}
 
 	bodyContents, err := io.ReadAll(resp.Body)
 
 	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	resp.Body.Close()
 
 	if resp.StatusCode > 299 {
-		return fmt.Errorf("error response from server (%d): %s", resp.StatusCode, bodyContents)
+		return nil, newAPIError(resp, bodyContents)
 	}
 
+	return bodyContents, nil
+}
+
+func decodeResponse(body []byte, responseStruct interface{}) error {
 	if responseStruct == nil {
 		return nil
 	}
 
-	err = json.Unmarshal(bodyContents, responseStruct)
-	if err != nil {
+	if err := json.Unmarshal(body, responseStruct); err != nil {
 		return fmt.Errorf("error decoding json response: %w", err)
 	}
 
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -30,6 +31,15 @@ type CreateCloudAccessPolicyInput struct {
 	Conditions  *CloudAccessPolicyConditions `json:"conditions,omitempty"`
 }
 
+// UpdateCloudAccessPolicyInput replaces the display name, scopes, realms and conditions of an access policy. The name
+// of an access policy cannot be changed.
+type UpdateCloudAccessPolicyInput struct {
+	DisplayName string                       `json:"displayName,omitempty"`
+	Scopes      []string                     `json:"scopes"`
+	Realms      []CloudAccessPolicyRealm     `json:"realms"`
+	Conditions  *CloudAccessPolicyConditions `json:"conditions,omitempty"`
+}
+
 type CreateCloudAccessPolicyTokenInput struct {
 	AccessPolicyID string     `json:"accessPolicyId"`
 	Name           string     `json:"name"`
@@ -63,7 +73,7 @@ type CloudAccessPolicyToken struct {
 	Token string `json:"token,omitempty"` // Only returned when creating a token.
 }
 
-func (g *Grafana) CreateCloudAccessPolicy(region string, input CreateCloudAccessPolicyInput) (CloudAccessPolicy, error) {
+func (g *Grafana) CreateCloudAccessPolicy(ctx context.Context, region string, input CreateCloudAccessPolicyInput) (CloudAccessPolicy, error) {
 
 	result := CloudAccessPolicy{}
 
@@ -72,7 +82,7 @@ func (g *Grafana) CreateCloudAccessPolicy(region string, input CreateCloudAccess
 		return result, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, "/api/v1/accesspolicies", url.Values{
+	err = g.do(ctx, http.MethodPost, "/api/v1/accesspolicies", url.Values{
 		"region": []string{region},
 	}, data, &result)
 
@@ -83,8 +93,28 @@ func (g *Grafana) CreateCloudAccessPolicy(region string, input CreateCloudAccess
 	return result, nil
 }
 
-func (g *Grafana) DeleteCloudAccessPolicy(region, cloudAccessPolicyID string) error {
-	err := g.do(http.MethodDelete, fmt.Sprintf("/api/v1/accesspolicies/%s", cloudAccessPolicyID), url.Values{
+func (g *Grafana) UpdateCloudAccessPolicy(ctx context.Context, region, cloudAccessPolicyID string, input UpdateCloudAccessPolicyInput) (CloudAccessPolicy, error) {
+
+	result := CloudAccessPolicy{}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return result, fmt.Errorf("error marshalling input: %w", err)
+	}
+
+	err = g.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/accesspolicies/%s", cloudAccessPolicyID), url.Values{
+		"region": []string{region},
+	}, data, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error updating cloud access policy: %w", err)
+	}
+
+	return result, nil
+}
+
+func (g *Grafana) DeleteCloudAccessPolicy(ctx context.Context, region, cloudAccessPolicyID string) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/accesspolicies/%s", cloudAccessPolicyID), url.Values{
 		"region": []string{region},
 	}, nil, nil)
 
@@ -95,7 +125,7 @@ func (g *Grafana) DeleteCloudAccessPolicy(region, cloudAccessPolicyID string) er
 	return nil
 }
 
-func (g *Grafana) CreateCloudAccessPolicyToken(region string, input CreateCloudAccessPolicyTokenInput) (CloudAccessPolicyToken, error) {
+func (g *Grafana) CreateCloudAccessPolicyToken(ctx context.Context, region string, input CreateCloudAccessPolicyTokenInput) (CloudAccessPolicyToken, error) {
 
 	result := CloudAccessPolicyToken{}
 
@@ -104,7 +134,7 @@ func (g *Grafana) CreateCloudAccessPolicyToken(region string, input CreateCloudA
 		return result, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, "/api/v1/tokens", url.Values{
+	err = g.do(ctx, http.MethodPost, "/api/v1/tokens", url.Values{
 		"region": []string{region},
 	}, data, &result)
 
@@ -114,3 +144,96 @@ func (g *Grafana) CreateCloudAccessPolicyToken(region string, input CreateCloudA
 
 	return result, nil
 }
+
+type cloudAccessPolicyList struct {
+	Items    []CloudAccessPolicy `json:"items"`
+	Metadata struct {
+		Pagination struct {
+			NextPage string `json:"nextPage"`
+		} `json:"pagination"`
+	} `json:"metadata"`
+}
+
+type cloudAccessPolicyTokenList struct {
+	Items []CloudAccessPolicyToken `json:"items"`
+}
+
+func (g *Grafana) GetCloudAccessPolicy(ctx context.Context, region, cloudAccessPolicyID string) (CloudAccessPolicy, error) {
+
+	result := CloudAccessPolicy{}
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/accesspolicies/%s", cloudAccessPolicyID), url.Values{
+		"region": []string{region},
+	}, nil, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error getting cloud access policy: %w", err)
+	}
+
+	return result, nil
+}
+
+// ListCloudAccessPolicies returns all access policies in the region, following the pagination of the API.
+func (g *Grafana) ListCloudAccessPolicies(ctx context.Context, region string) ([]CloudAccessPolicy, error) {
+	var policies []CloudAccessPolicy
+
+	query := url.Values{
+		"region": []string{region},
+	}
+
+	for {
+		result := cloudAccessPolicyList{}
+
+		err := g.do(ctx, http.MethodGet, "/api/v1/accesspolicies", query, nil, &result)
+
+		if err != nil {
+			return nil, fmt.Errorf("error listing cloud access policies: %w", err)
+		}
+
+		policies = append(policies, result.Items...)
+
+		if result.Metadata.Pagination.NextPage == "" {
+			return policies, nil
+		}
+
+		nextPage, err := url.Parse(result.Metadata.Pagination.NextPage)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing next page of cloud access policies: %w", err)
+		}
+
+		cursor := nextPage.Query().Get("pageCursor")
+		if cursor == "" || cursor == query.Get("pageCursor") {
+			return policies, nil
+		}
+
+		query.Set("pageCursor", cursor)
+	}
+}
+
+func (g *Grafana) ListCloudAccessPolicyTokens(ctx context.Context, region, cloudAccessPolicyID string) ([]CloudAccessPolicyToken, error) {
+
+	result := cloudAccessPolicyTokenList{}
+
+	err := g.do(ctx, http.MethodGet, "/api/v1/tokens", url.Values{
+		"region":         []string{region},
+		"accessPolicyId": []string{cloudAccessPolicyID},
+	}, nil, &result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing cloud access policy tokens: %w", err)
+	}
+
+	return result.Items, nil
+}
+
+func (g *Grafana) DeleteCloudAccessPolicyToken(ctx context.Context, region, tokenID string) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/tokens/%s", tokenID), url.Values{
+		"region": []string{region},
+	}, nil, nil)
+
+	if err != nil {
+		return fmt.Errorf("error deleting cloud access policy token: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned when Grafana or the Grafana Cloud API responds with a status code outside the 2xx range.
+// Use errors.As to inspect it, or one of the Is* helpers to check for common failures.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the human-readable message from the response body, if any.
+	Message string
+	// MessageID is Grafana's machine-readable error identifier, for example "serviceaccounts.ErrNotFound".
+	MessageID string
+	// RequestID identifies the request in the logs of the server, if the server returned one.
+	RequestID string
+	// Body is the raw response body.
+	Body []byte
+
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = strings.TrimSpace(string(e.Body))
+	}
+
+	var details []string
+
+	if e.MessageID != "" {
+		details = append(details, "message id: "+e.MessageID)
+	}
+
+	if e.RequestID != "" {
+		details = append(details, "request id: "+e.RequestID)
+	}
+
+	if len(details) > 0 {
+		return fmt.Sprintf("error response from server (%d): %s (%s)", e.StatusCode, message, strings.Join(details, ", "))
+	}
+
+	return fmt.Sprintf("error response from server (%d): %s", e.StatusCode, message)
+}
+
+// errorBody covers the error formats of Grafana and the Grafana Cloud API.
+type errorBody struct {
+	Message   string `json:"message"`
+	MessageID string `json:"messageId"`
+	Code      string `json:"code"`
+	TraceID   string `json:"traceID"`
+	RequestID string `json:"requestId"`
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var parsed errorBody
+	if json.Unmarshal(body, &parsed) == nil {
+		apiErr.Message = parsed.Message
+
+		apiErr.MessageID = parsed.MessageID
+		if apiErr.MessageID == "" {
+			apiErr.MessageID = parsed.Code
+		}
+
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = parsed.RequestID
+		}
+
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = parsed.TraceID
+		}
+	}
+
+	return apiErr
+}
+
+// statusCode returns the status code of the APIError wrapped by err, or 0 if there is none.
+func statusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+
+	return 0
+}
+
+// IsNotFound reports whether err was caused by a 404 Not Found response.
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether err was caused by a 409 Conflict response, which Grafana returns when an object with
+// the same name already exists, or by the 412 Precondition Failed response it returns for users with the same login.
+func IsConflict(err error) bool {
+	return statusCode(err) == http.StatusConflict || statusCode(err) == http.StatusPreconditionFailed
+}
+
+// IsUnauthorized reports whether err was caused by a 401 Unauthorized response, which means the token is invalid,
+// expired or revoked.
+func IsUnauthorized(err error) bool {
+	return statusCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden reports whether err was caused by a 403 Forbidden response, which means the token lacks a permission.
+func IsForbidden(err error) bool {
+	return statusCode(err) == http.StatusForbidden && !IsQuotaExceeded(err)
+}
+
+// IsRateLimited reports whether err was caused by a 429 Too Many Requests response.
+func IsRateLimited(err error) bool {
+	return statusCode(err) == http.StatusTooManyRequests
+}
+
+// IsQuotaExceeded reports whether err was caused by Grafana rejecting a request because a quota was reached.
+// Grafana reports this with a 403 Forbidden response, so it is told apart from missing permissions by the message.
+func IsQuotaExceeded(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(apiErr.MessageID), "quota") || strings.Contains(strings.ToLower(apiErr.Message), "quota")
+}
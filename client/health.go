@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type Health struct {
+	Commit   string `json:"commit"`
+	Database string `json:"database"`
+	Version  string `json:"version"`
+}
+
+// Health returns the health of the Grafana instance.
+func (g *Grafana) Health(ctx context.Context) (Health, error) {
+	result := Health{}
+
+	err := g.do(ctx, http.MethodGet, "/api/health", nil, nil, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error getting health: %w", err)
+	}
+
+	return result, nil
+}
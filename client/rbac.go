@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -33,10 +34,10 @@ type Permission struct {
 	Scope  string `json:"scope"`
 }
 
-func (g *Grafana) GetAllRoles() ([]Role, error) {
+func (g *Grafana) GetAllRoles(ctx context.Context) ([]Role, error) {
 	var result []Role
 
-	err := g.do(http.MethodGet, "/api/access-control/roles", nil, nil, &result)
+	err := g.do(ctx, http.MethodGet, "/api/access-control/roles", nil, nil, &result)
 	if err != nil {
 		return nil, fmt.Errorf("error getting roles: %w", err)
 	}
@@ -44,14 +45,27 @@ func (g *Grafana) GetAllRoles() ([]Role, error) {
 	return result, nil
 }
 
-func (g *Grafana) SetServiceAccountRoleAssignments(input ServiceAccountRoleAssignmentsInput) error {
+// CurrentUserPermissions returns the actions the user or service account that owns the token used by the client is
+// allowed to perform, mapped to the scopes they apply to.
+func (g *Grafana) CurrentUserPermissions(ctx context.Context) (map[string][]string, error) {
+	result := map[string][]string{}
+
+	err := g.do(ctx, http.MethodGet, "/api/access-control/user/permissions", nil, nil, &result)
+	if err != nil {
+		return nil, fmt.Errorf("error getting permissions of current user: %w", err)
+	}
+
+	return result, nil
+}
+
+func (g *Grafana) SetServiceAccountRoleAssignments(ctx context.Context, input ServiceAccountRoleAssignmentsInput) error {
 
 	data, err := json.Marshal(input)
 	if err != nil {
 		return fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPut, fmt.Sprintf("/api/access-control/users/%d/roles", input.ServiceAccountID), nil, data, nil)
+	err = g.do(ctx, http.MethodPut, fmt.Sprintf("/api/access-control/users/%d/roles", input.ServiceAccountID), nil, data, nil)
 
 	if err != nil {
 		return fmt.Errorf("error setting service account role assignments: %w", err)
@@ -60,7 +74,19 @@ func (g *Grafana) SetServiceAccountRoleAssignments(input ServiceAccountRoleAssig
 	return nil
 }
 
-func (g *Grafana) CreateCustomRole(input RoleInput) (Role, error) {
+// GetUserRoleAssignments returns the RBAC roles assigned directly to a user or service account.
+func (g *Grafana) GetUserRoleAssignments(ctx context.Context, userID int64) ([]Role, error) {
+	var result []Role
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/access-control/users/%d/roles", userID), nil, nil, &result)
+	if err != nil {
+		return nil, fmt.Errorf("error getting role assignments of user: %w", err)
+	}
+
+	return result, nil
+}
+
+func (g *Grafana) CreateCustomRole(ctx context.Context, input RoleInput) (Role, error) {
 
 	result := Role{}
 
@@ -69,7 +95,7 @@ func (g *Grafana) CreateCustomRole(input RoleInput) (Role, error) {
 		return result, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, "/api/access-control/roles", nil, data, &result)
+	err = g.do(ctx, http.MethodPost, "/api/access-control/roles", nil, data, &result)
 
 	if err != nil {
 		return result, fmt.Errorf("error creating custom role: %w", err)
@@ -78,8 +104,8 @@ func (g *Grafana) CreateCustomRole(input RoleInput) (Role, error) {
 	return result, nil
 }
 
-func (g *Grafana) DeleteCustomRole(roleUID string) error {
-	err := g.do(http.MethodDelete, fmt.Sprintf("/api/access-control/roles/%s", roleUID), url.Values{
+func (g *Grafana) DeleteCustomRole(ctx context.Context, roleUID string) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/access-control/roles/%s", roleUID), url.Values{
 		"force": []string{"true"},
 	}, nil, nil)
 
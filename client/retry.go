@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryable reports whether a failed request can be retried. Idempotent requests are retried when no response was
+// received, when they were rate limited and on server errors. Other requests are only retried when the server rate
+// limited them or reported that it was unavailable, as it rejects the request before processing it in those cases. A
+// gateway error may be returned after the server processed the request, so such requests are not retried on it.
+func retryable(method string, err error) bool {
+	statusCode := statusCode(err)
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+	}
+
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// backoff returns how long to wait before the next attempt. The wait grows exponentially with every attempt and is
+// jittered to spread out retries of concurrent requests. A Retry-After sent by the server takes precedence if it is
+// longer.
+func (g *Grafana) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	wait := g.maxBackoff
+
+	if attempt < 30 {
+		wait = min(g.minBackoff<<attempt, g.maxBackoff)
+	}
+
+	if wait > 0 {
+		wait = wait/2 + rand.N(wait/2+1)
+	}
+
+	return max(wait, retryAfter)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +16,12 @@ type CreateServiceAccountInput struct {
 	IsDisabled *bool  `json:"isDisabled,omitempty"`
 }
 
+type UpdateServiceAccountInput struct {
+	Name       string `json:"name,omitempty"`
+	Role       string `json:"role,omitempty"`
+	IsDisabled *bool  `json:"isDisabled,omitempty"`
+}
+
 type CreateServiceAccountTokenInput struct {
 	Name             string `json:"name"`
 	ServiceAccountID int64  `json:"-"`
@@ -38,7 +47,7 @@ type ServiceAccountToken struct {
 	Key  string `json:"key"`
 }
 
-func (g *Grafana) CreateServiceAccount(input CreateServiceAccountInput) (ServiceAccount, error) {
+func (g *Grafana) CreateServiceAccount(ctx context.Context, input CreateServiceAccountInput) (ServiceAccount, error) {
 	result := ServiceAccount{}
 
 	data, err := json.Marshal(input)
@@ -46,7 +55,7 @@ func (g *Grafana) CreateServiceAccount(input CreateServiceAccountInput) (Service
 		return result, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, "/api/serviceaccounts", nil, data, &result)
+	err = g.do(ctx, http.MethodPost, "/api/serviceaccounts", nil, data, &result)
 
 	if err != nil {
 		return result, fmt.Errorf("error creating service account: %w", err)
@@ -55,8 +64,31 @@ func (g *Grafana) CreateServiceAccount(input CreateServiceAccountInput) (Service
 	return result, nil
 }
 
-func (g *Grafana) DeleteServiceAccount(serviceAccountID int64) error {
-	err := g.do(http.MethodDelete, fmt.Sprintf("/api/serviceaccounts/%d", serviceAccountID), nil, nil, nil)
+// updateServiceAccountResult is the response of the API updating a service account.
+type updateServiceAccountResult struct {
+	ServiceAccount ServiceAccount `json:"serviceaccount"`
+}
+
+// UpdateServiceAccount changes the fields of a service account that are set in the input.
+func (g *Grafana) UpdateServiceAccount(ctx context.Context, serviceAccountID int64, input UpdateServiceAccountInput) (ServiceAccount, error) {
+	result := updateServiceAccountResult{}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return result.ServiceAccount, fmt.Errorf("error marshalling input: %w", err)
+	}
+
+	err = g.do(ctx, http.MethodPatch, fmt.Sprintf("/api/serviceaccounts/%d", serviceAccountID), nil, data, &result)
+
+	if err != nil {
+		return result.ServiceAccount, fmt.Errorf("error updating service account: %w", err)
+	}
+
+	return result.ServiceAccount, nil
+}
+
+func (g *Grafana) DeleteServiceAccount(ctx context.Context, serviceAccountID int64) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/serviceaccounts/%d", serviceAccountID), nil, nil, nil)
 
 	if err != nil {
 		return fmt.Errorf("error deleting service account: %w", err)
@@ -65,7 +97,7 @@ func (g *Grafana) DeleteServiceAccount(serviceAccountID int64) error {
 	return nil
 }
 
-func (g *Grafana) CreateServiceAccountToken(input CreateServiceAccountTokenInput) (ServiceAccountToken, error) {
+func (g *Grafana) CreateServiceAccountToken(ctx context.Context, input CreateServiceAccountTokenInput) (ServiceAccountToken, error) {
 
 	result := ServiceAccountToken{}
 
@@ -74,7 +106,7 @@ func (g *Grafana) CreateServiceAccountToken(input CreateServiceAccountTokenInput
 		return result, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, fmt.Sprintf("/api/serviceaccounts/%d/tokens", input.ServiceAccountID), nil, data, &result)
+	err = g.do(ctx, http.MethodPost, fmt.Sprintf("/api/serviceaccounts/%d/tokens", input.ServiceAccountID), nil, data, &result)
 
 	if err != nil {
 		return result, fmt.Errorf("error creating service account token: %w", err)
@@ -82,3 +114,104 @@ func (g *Grafana) CreateServiceAccountToken(input CreateServiceAccountTokenInput
 
 	return result, nil
 }
+
+type ServiceAccountSearchResult struct {
+	TotalCount      int64            `json:"totalCount"`
+	ServiceAccounts []ServiceAccount `json:"serviceAccounts"`
+	Page            int64            `json:"page"`
+	PerPage         int64            `json:"perPage"`
+}
+
+func (g *Grafana) GetServiceAccount(ctx context.Context, serviceAccountID int64) (ServiceAccount, error) {
+	result := ServiceAccount{}
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/serviceaccounts/%d", serviceAccountID), nil, nil, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error getting service account: %w", err)
+	}
+
+	return result, nil
+}
+
+func (g *Grafana) SearchServiceAccounts(ctx context.Context, query string) ([]ServiceAccount, error) {
+	result := ServiceAccountSearchResult{}
+
+	err := g.do(ctx, http.MethodGet, "/api/serviceaccounts/search", url.Values{
+		"query":   []string{query},
+		"perpage": []string{"1000"},
+	}, nil, &result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error searching service accounts: %w", err)
+	}
+
+	return result.ServiceAccounts, nil
+}
+
+// ListServiceAccounts returns all service accounts matching the query, following the pagination of the search API.
+func (g *Grafana) ListServiceAccounts(ctx context.Context, query string) ([]ServiceAccount, error) {
+	serviceAccounts, err := g.listServiceAccounts(ctx, "/api/serviceaccounts/search", query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing service accounts: %w", err)
+	}
+
+	return serviceAccounts, nil
+}
+
+const serviceAccountsPerPage = 1000
+
+func (g *Grafana) listServiceAccounts(ctx context.Context, requestPath string, query string) ([]ServiceAccount, error) {
+	var serviceAccounts []ServiceAccount
+
+	for page := 1; ; page++ {
+		result := ServiceAccountSearchResult{}
+
+		err := g.do(ctx, http.MethodGet, requestPath, url.Values{
+			"query":   []string{query},
+			"page":    []string{strconv.Itoa(page)},
+			"perpage": []string{strconv.Itoa(serviceAccountsPerPage)},
+		}, nil, &result)
+
+		if err != nil {
+			return nil, err
+		}
+
+		serviceAccounts = append(serviceAccounts, result.ServiceAccounts...)
+
+		if len(result.ServiceAccounts) < serviceAccountsPerPage || int64(len(serviceAccounts)) >= result.TotalCount {
+			return serviceAccounts, nil
+		}
+	}
+}
+
+func (g *Grafana) DeleteServiceAccountToken(ctx context.Context, serviceAccountID, tokenID int64) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/serviceaccounts/%d/tokens/%d", serviceAccountID, tokenID), nil, nil, nil)
+
+	if err != nil {
+		return fmt.Errorf("error deleting service account token: %w", err)
+	}
+
+	return nil
+}
+
+type ServiceAccountTokenInfo struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Created    time.Time  `json:"created"`
+	Expiration *time.Time `json:"expiration"`
+	HasExpired bool       `json:"hasExpired"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func (g *Grafana) ListServiceAccountTokens(ctx context.Context, serviceAccountID int64) ([]ServiceAccountTokenInfo, error) {
+	var result []ServiceAccountTokenInfo
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/serviceaccounts/%d/tokens", serviceAccountID), nil, nil, &result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing service account tokens: %w", err)
+	}
+
+	return result, nil
+}
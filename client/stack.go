@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -88,9 +90,9 @@ type Stack struct {
 	} `json:"links"`
 }
 
-func (g *Grafana) StackBySlug(id string) (Stack, error) {
+func (g *Grafana) StackBySlug(ctx context.Context, id string) (Stack, error) {
 	stack := Stack{}
-	err := g.do("GET", fmt.Sprintf("/api/instances/%s", id), nil, nil, &stack)
+	err := g.do(ctx, "GET", fmt.Sprintf("/api/instances/%s", id), nil, nil, &stack)
 
 	if err != nil {
 		return stack, fmt.Errorf("error getting stack: %w", err)
@@ -99,7 +101,7 @@ func (g *Grafana) StackBySlug(id string) (Stack, error) {
 	return stack, nil
 }
 
-func (g *Grafana) CreateGrafanaServiceAccountFromCloud(stack string, input CreateServiceAccountInput) (*ServiceAccount, error) {
+func (g *Grafana) CreateGrafanaServiceAccountFromCloud(ctx context.Context, stack string, input CreateServiceAccountInput) (*ServiceAccount, error) {
 
 	result := &ServiceAccount{}
 
@@ -108,7 +110,7 @@ func (g *Grafana) CreateGrafanaServiceAccountFromCloud(stack string, input Creat
 		return nil, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, fmt.Sprintf("/api/instances/%s/api/serviceaccounts", stack), nil, data, result)
+	err = g.do(ctx, http.MethodPost, fmt.Sprintf("/api/instances/%s/api/serviceaccounts", stack), nil, data, result)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating service account from cloud token: %w", err)
@@ -117,7 +119,7 @@ func (g *Grafana) CreateGrafanaServiceAccountFromCloud(stack string, input Creat
 	return result, nil
 }
 
-func (g *Grafana) CreateGrafanaServiceAccountTokenFromCloud(stack string, input CreateServiceAccountTokenInput) (*ServiceAccountToken, error) {
+func (g *Grafana) CreateGrafanaServiceAccountTokenFromCloud(ctx context.Context, stack string, input CreateServiceAccountTokenInput) (*ServiceAccountToken, error) {
 
 	result := &ServiceAccountToken{}
 
@@ -126,7 +128,7 @@ func (g *Grafana) CreateGrafanaServiceAccountTokenFromCloud(stack string, input
 		return nil, fmt.Errorf("error marshalling input: %w", err)
 	}
 
-	err = g.do(http.MethodPost, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d/tokens", stack, input.ServiceAccountID), nil, data, result)
+	err = g.do(ctx, http.MethodPost, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d/tokens", stack, input.ServiceAccountID), nil, data, result)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating service account token from cloud token: %w", err)
@@ -135,9 +137,29 @@ func (g *Grafana) CreateGrafanaServiceAccountTokenFromCloud(stack string, input
 	return result, nil
 }
 
-func (g *Grafana) DeleteGrafanaServiceAccountFromCloud(stack string, serviceAccountID int64) error {
+// UpdateGrafanaServiceAccountFromCloud changes the fields of a service account of the stack that are set in the
+// input.
+func (g *Grafana) UpdateGrafanaServiceAccountFromCloud(ctx context.Context, stack string, serviceAccountID int64, input UpdateServiceAccountInput) (*ServiceAccount, error) {
 
-	err := g.do(http.MethodDelete, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d", stack, serviceAccountID), nil, nil, nil)
+	result := &updateServiceAccountResult{}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling input: %w", err)
+	}
+
+	err = g.do(ctx, http.MethodPatch, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d", stack, serviceAccountID), nil, data, result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error updating service account from cloud token: %w", err)
+	}
+
+	return &result.ServiceAccount, nil
+}
+
+func (g *Grafana) DeleteGrafanaServiceAccountFromCloud(ctx context.Context, stack string, serviceAccountID int64) error {
+
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d", stack, serviceAccountID), nil, nil, nil)
 
 	if err != nil {
 		return fmt.Errorf("error deleting service account from cloud token: %w", err)
@@ -146,44 +168,120 @@ func (g *Grafana) DeleteGrafanaServiceAccountFromCloud(stack string, serviceAcco
 	return nil
 }
 
-func (g *Grafana) CreateTemporaryStackGrafanaClient(stackSlug string, tempSaPrefix string, tempKeyDuration time.Duration) (tempClient *Grafana, cleanup func() error, err error) {
-	stack, err := g.StackBySlug(stackSlug)
+// CreateTemporaryStackGrafanaClient creates a service account with the Admin role in the stack and returns a client
+// authenticated with a short-lived token of it. The caller must call cleanup to delete the service account.
+func (g *Grafana) CreateTemporaryStackGrafanaClient(ctx context.Context, stackSlug string, tempSaName string, tempKeyDuration time.Duration) (tempClient *Grafana, cleanup func(ctx context.Context) error, err error) {
+	stack, err := g.StackBySlug(ctx, stackSlug)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	name := fmt.Sprintf("%s%d", tempSaPrefix, time.Now().UnixNano())
-
 	req := CreateServiceAccountInput{
-		Name: name,
+		Name: tempSaName,
 		Role: "Admin",
 	}
 
-	sa, err := g.CreateGrafanaServiceAccountFromCloud(stackSlug, req)
+	sa, err := g.CreateGrafanaServiceAccountFromCloud(ctx, stackSlug, req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating temporary service account: %w", err)
 	}
 
+	// Delete the temporary service account again if no client is returned, as the caller cannot clean it up.
+	defer func() {
+		if err != nil {
+			_ = g.DeleteGrafanaServiceAccountFromCloud(context.WithoutCancel(ctx), stackSlug, sa.ID)
+		}
+	}()
+
 	tokenRequest := CreateServiceAccountTokenInput{
-		Name:             name,
+		Name:             tempSaName,
 		ServiceAccountID: sa.ID,
 		SecondsToLive:    int64(tempKeyDuration.Seconds()),
 	}
 
-	token, err := g.CreateGrafanaServiceAccountTokenFromCloud(stackSlug, tokenRequest)
+	token, err := g.CreateGrafanaServiceAccountTokenFromCloud(ctx, stackSlug, tokenRequest)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating temporary service account token: %w", err)
 	}
 
-	client, err := New(stack.URL, token.Key)
+	// The temporary client connects through the same transport and retries like this client, so that proxy, TLS
+	// and retry settings also apply to it.
+	client, err := New(stack.URL, token.Key,
+		WithTransport(g.client.Transport),
+		WithTimeout(g.timeout),
+		WithRetries(g.maxRetries),
+		WithBackoff(g.minBackoff, g.maxBackoff),
+	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating temporary client: %w", err)
 	}
 
-	cleanup = func() error {
-		err = client.DeleteServiceAccount(sa.ID)
-		return err
+	cleanup = func(ctx context.Context) error {
+		return client.DeleteServiceAccount(ctx, sa.ID)
 	}
 
 	return client, cleanup, nil
 }
+
+func (g *Grafana) GetGrafanaServiceAccountFromCloud(ctx context.Context, stack string, serviceAccountID int64) (*ServiceAccount, error) {
+
+	result := &ServiceAccount{}
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d", stack, serviceAccountID), nil, nil, result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting service account from cloud token: %w", err)
+	}
+
+	return result, nil
+}
+
+func (g *Grafana) SearchGrafanaServiceAccountsFromCloud(ctx context.Context, stack string, query string) ([]ServiceAccount, error) {
+
+	result := &ServiceAccountSearchResult{}
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/search", stack), url.Values{
+		"query":   []string{query},
+		"perpage": []string{"1000"},
+	}, nil, result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error searching service accounts from cloud token: %w", err)
+	}
+
+	return result.ServiceAccounts, nil
+}
+
+// ListGrafanaServiceAccountsFromCloud returns all service accounts of the stack matching the query, following the
+// pagination of the search API.
+func (g *Grafana) ListGrafanaServiceAccountsFromCloud(ctx context.Context, stack string, query string) ([]ServiceAccount, error) {
+	serviceAccounts, err := g.listServiceAccounts(ctx, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/search", stack), query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing service accounts from cloud token: %w", err)
+	}
+
+	return serviceAccounts, nil
+}
+
+func (g *Grafana) DeleteGrafanaServiceAccountTokenFromCloud(ctx context.Context, stack string, serviceAccountID, tokenID int64) error {
+
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d/tokens/%d", stack, serviceAccountID, tokenID), nil, nil, nil)
+
+	if err != nil {
+		return fmt.Errorf("error deleting service account token from cloud token: %w", err)
+	}
+
+	return nil
+}
+
+func (g *Grafana) ListGrafanaServiceAccountTokensFromCloud(ctx context.Context, stack string, serviceAccountID int64) ([]ServiceAccountTokenInfo, error) {
+	var result []ServiceAccountTokenInfo
+
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("/api/instances/%s/api/serviceaccounts/%d/tokens", stack, serviceAccountID), nil, nil, &result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing service account tokens from cloud token: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type User struct {
+	ID         int64  `json:"id"`
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	Login      string `json:"login"`
+	OrgID      int64  `json:"orgId"`
+	IsDisabled bool   `json:"isDisabled"`
+}
+
+// OrgUser is a user as a member of the organization of the client.
+type OrgUser struct {
+	UserID int64  `json:"userId"`
+	Login  string `json:"login"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// searchOrgUsersResult is the response of the API searching the users of an organization.
+type searchOrgUsersResult struct {
+	OrgUsers []OrgUser `json:"orgUsers"`
+}
+
+type CreateUserInput struct {
+	Name     string `json:"name"`
+	Login    string `json:"login"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+	OrgID    int64  `json:"OrgId,omitempty"`
+}
+
+// createUserResult is the response of the admin API creating a user.
+type createUserResult struct {
+	ID int64 `json:"id"`
+}
+
+// CurrentUser returns the user or service account that owns the token used by the client.
+func (g *Grafana) CurrentUser(ctx context.Context) (User, error) {
+	result := User{}
+
+	err := g.do(ctx, http.MethodGet, "/api/user", nil, nil, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error getting current user: %w", err)
+	}
+
+	return result, nil
+}
+
+// CreateUser creates a user through the admin API, which requires the users:create permission. The user is added to
+// the organization set in the input.
+func (g *Grafana) CreateUser(ctx context.Context, input CreateUserInput) (User, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return User{}, fmt.Errorf("error marshalling input: %w", err)
+	}
+
+	result := createUserResult{}
+
+	err = g.do(ctx, http.MethodPost, "/api/admin/users", nil, data, &result)
+
+	if err != nil {
+		return User{}, fmt.Errorf("error creating user: %w", err)
+	}
+
+	return User{
+		ID:    result.ID,
+		Email: input.Email,
+		Name:  input.Name,
+		Login: input.Login,
+		OrgID: input.OrgID,
+	}, nil
+}
+
+// LookupUser returns the user with the given login or email.
+func (g *Grafana) LookupUser(ctx context.Context, loginOrEmail string) (User, error) {
+	result := User{}
+
+	err := g.do(ctx, http.MethodGet, "/api/users/lookup", url.Values{
+		"loginOrEmail": []string{loginOrEmail},
+	}, nil, &result)
+
+	if err != nil {
+		return result, fmt.Errorf("error looking up user: %w", err)
+	}
+
+	return result, nil
+}
+
+// SearchOrgUsers returns the users of the organization of the client whose login, email or name matches the query.
+func (g *Grafana) SearchOrgUsers(ctx context.Context, query string) ([]OrgUser, error) {
+	result := searchOrgUsersResult{}
+
+	err := g.do(ctx, http.MethodGet, "/api/org/users/search", url.Values{
+		"query":   []string{query},
+		"perpage": []string{"1000"},
+	}, nil, &result)
+
+	if err != nil {
+		return nil, fmt.Errorf("error searching organization users: %w", err)
+	}
+
+	return result.OrgUsers, nil
+}
+
+// UpdateOrgUserRole changes the basic role of a user in the organization of the client.
+func (g *Grafana) UpdateOrgUserRole(ctx context.Context, userID int64, role string) error {
+	data, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return fmt.Errorf("error marshalling input: %w", err)
+	}
+
+	err = g.do(ctx, http.MethodPatch, fmt.Sprintf("/api/org/users/%d", userID), nil, data, nil)
+
+	if err != nil {
+		return fmt.Errorf("error updating role of user: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user from all organizations through the admin API, which requires the users:delete permission.
+func (g *Grafana) DeleteUser(ctx context.Context, userID int64) error {
+	err := g.do(ctx, http.MethodDelete, fmt.Sprintf("/api/admin/users/%d", userID), nil, nil, nil)
+
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template/parse"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/helper/template"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	maxCredentialNameLength = 190
+
+	// minNameTemplateRandomLength is the shortest {{random N}} accepted in a name template. Names that collide with an
+	// existing object are rejected by Grafana, so a template must make collisions unlikely.
+	minNameTemplateRandomLength = 8
+)
+
+var (
+	// serviceAccountNameRegex matches the names of service accounts and their tokens that Grafana accepts.
+	serviceAccountNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+	// accessPolicyNameRegex matches the names of access policies and their tokens that Grafana Cloud accepts.
+	accessPolicyNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+// credentialNameData is passed to the name template and user template of a role.
+type credentialNameData struct {
+	RoleName       string
+	DisplayName    string
+	EntityID       string
+	EntityName     string
+	EntityMetadata map[string]string
+	EntityAliases  map[string]string // Alias names of the entity by the accessor of their auth mount
+}
+
+// credentialName returns the name of the service account or access policy created for new credentials, which is
+// also used for their token. Roles without a name template use vault-<marker>-<uuid>, and names generated by a name
+// template end with the marker of the mount.
+func (b *grafanaBackend) credentialName(req *logical.Request, roleName string, configType string, role *grafanaRoleEntry) (string, error) {
+	if role.NameTemplate == "" {
+		return defaultCredentialName(b.mountMarker), nil
+	}
+
+	data, err := b.templateData(req, roleName)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := generateCredentialName(role.NameTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	name = markCredentialName(name, role.credentialKind(configType), b.mountMarker)
+
+	if err := validateCredentialName(name, role.credentialKind(configType)); err != nil {
+		return "", fmt.Errorf("name_template of role %s generated an invalid name: %w", roleName, err)
+	}
+
+	return name, nil
+}
+
+// templateData returns the data passed to the templates of a role for a request, including the entity of the request
+// if it has one.
+func (b *grafanaBackend) templateData(req *logical.Request, roleName string) (credentialNameData, error) {
+	data := credentialNameData{
+		RoleName:    roleName,
+		DisplayName: req.DisplayName,
+		EntityID:    req.EntityID,
+	}
+
+	if req.EntityID == "" {
+		return data, nil
+	}
+
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return data, fmt.Errorf("error looking up entity: %w", err)
+	}
+
+	if entity != nil {
+		data.EntityName = entity.Name
+		data.EntityMetadata = entity.Metadata
+		data.EntityAliases = map[string]string{}
+
+		for _, alias := range entity.Aliases {
+			data.EntityAliases[alias.MountAccessor] = alias.Name
+		}
+	}
+
+	return data, nil
+}
+
+// validateNameTemplate checks that a name template generates valid names for sample data, including the marker of the
+// mount, and that the names are unique, as Grafana does not allow several service accounts or access policies with
+// the same name.
+func validateNameTemplate(nameTemplate string, roleName string, kind string, mountMarker string) error {
+	uniqueNamesErr := fmt.Errorf("name_template must generate unique names by including {{random %d}} or longer, or {{uuid}}", minNameTemplateRandomLength)
+
+	data := credentialNameData{
+		RoleName:    roleName,
+		DisplayName: "token",
+		EntityID:    uuid.NewString(),
+		EntityName:  "entity",
+	}
+
+	first, err := generateCredentialName(nameTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	if err := validateCredentialName(markCredentialName(first, kind, mountMarker), kind); err != nil {
+		return err
+	}
+
+	second, err := generateCredentialName(nameTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	if first == second || !hasUniqueNameFunction(nameTemplate) {
+		return uniqueNamesErr
+	}
+
+	return nil
+}
+
+// hasUniqueNameFunction reports whether a name template calls uuid or random with a length of at least
+// minNameTemplateRandomLength, which make generated names unique enough. Two different sample names alone do not, as
+// {{random 1}} or {{unix_time}} also generate different names, but often generate the same name twice.
+func hasUniqueNameFunction(nameTemplate string) bool {
+	tree := parse.New("name_template")
+	tree.Mode = parse.SkipFuncCheck
+
+	if _, err := tree.Parse(nameTemplate, "", "", map[string]*parse.Tree{}); err != nil {
+		return false
+	}
+
+	return hasUniqueNameCommand(tree.Root)
+}
+
+// hasUniqueNameCommand reports whether a node of a name template writes the output of uuid or a long enough random to
+// the name.
+func hasUniqueNameCommand(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if hasUniqueNameCommand(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		// Variable declarations do not write their value to the name.
+		if len(n.Pipe.Decl) > 0 {
+			return false
+		}
+
+		return hasUniqueNameCommand(n.Pipe)
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			if hasUniqueNameCommand(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		if len(n.Args) == 0 {
+			return false
+		}
+
+		for _, arg := range n.Args[1:] {
+			if hasUniqueNameCommand(arg) {
+				return true
+			}
+		}
+
+		identifier, ok := n.Args[0].(*parse.IdentifierNode)
+		if !ok {
+			return hasUniqueNameCommand(n.Args[0])
+		}
+
+		switch identifier.Ident {
+		case "uuid":
+			return true
+		case "random":
+			if len(n.Args) < 2 {
+				return false
+			}
+
+			length, ok := n.Args[1].(*parse.NumberNode)
+			if !ok {
+				return false
+			}
+
+			size, err := strconv.Atoi(length.Text)
+			return err == nil && size >= minNameTemplateRandomLength
+		}
+	}
+
+	return false
+}
+
+// markCredentialName appends the marker of the mount to a name generated by a name template for a service account or
+// access policy, so that tidy and revoke-all recognize the object. Logins of Grafana users are used as generated.
+func markCredentialName(name string, kind string, mountMarker string) string {
+	if kind == walKindUser {
+		return name
+	}
+
+	return fmt.Sprintf("%s-%s", name, mountMarker)
+}
+
+func generateCredentialName(nameTemplate string, data credentialNameData) (string, error) {
+	tmpl, err := template.NewTemplate(template.Template(nameTemplate))
+	if err != nil {
+		return "", fmt.Errorf("invalid name_template: %w", err)
+	}
+
+	name, err := tmpl.Generate(data)
+	if err != nil {
+		return "", fmt.Errorf("error generating name from name_template: %w", err)
+	}
+
+	return name, nil
+}
+
+// validateCredentialName checks a name against the length and character rules of Grafana for service accounts, users
+// or Grafana Cloud access policies.
+func validateCredentialName(name string, kind string) error {
+	if len(name) > maxCredentialNameLength {
+		return fmt.Errorf("name %q is longer than %d characters", name, maxCredentialNameLength)
+	}
+
+	if kind == walKindAccessPolicy {
+		if !accessPolicyNameRegex.MatchString(name) {
+			return fmt.Errorf("name %q of access policy may only contain lowercase letters, digits and dashes, and must start and end with a letter or digit", name)
+		}
+
+		return nil
+	}
+
+	// Logins of Grafana users follow the same rules as the names of service accounts.
+	object := "service account"
+	if kind == walKindUser {
+		object = "user"
+	}
+
+	if !serviceAccountNameRegex.MatchString(name) {
+		return fmt.Errorf("name %q of %s may only contain letters, digits, dots, dashes and underscores, and must start with a letter or digit", name, object)
+	}
+
+	return nil
+}
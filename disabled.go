@@ -0,0 +1,243 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	disabledStoragePrefix            = "disabled/"
+	disabledServiceAccountNamePrefix = "vault-disabled-"
+	disabledPurgeInterval            = time.Hour
+)
+
+// disabledServiceAccount records a service account that was disabled instead of deleted when its lease was revoked,
+// so that it can be deleted once the retention period of its role has passed.
+type disabledServiceAccount struct {
+	Name             string    `json:"name"`          // Name the service account was renamed to
+	OriginalName     string    `json:"original_name"` // Name of the credentials of the lease
+	Connection       string    `json:"connection"`
+	Role             string    `json:"role"`
+	Stack            string    `json:"stack,omitempty"` // For Grafana Cloud service accounts
+	ServiceAccountID int64     `json:"service_account_id"`
+	DisabledAt       time.Time `json:"disabled_at"`
+	DeleteAt         time.Time `json:"delete_at"` // Zero if the service account is kept until it is deleted manually
+}
+
+func (d *disabledServiceAccount) toManagedObject() *managedObject {
+	return &managedObject{
+		Kind:             walKindServiceAccount,
+		Connection:       d.Connection,
+		Name:             d.Name,
+		Stack:            d.Stack,
+		ServiceAccountID: d.ServiceAccountID,
+	}
+}
+
+// disabledServiceAccountName returns the name a service account is renamed to when it is disabled. The name shows in
+// Grafana that Vault revoked the service account, and does not match credentialNameRegex, so that tidy leaves it
+// alone until its retention period has passed.
+func disabledServiceAccountName(name string) string {
+	name = disabledServiceAccountNamePrefix + name
+
+	if len(name) > maxCredentialNameLength {
+		name = name[:maxCredentialNameLength]
+	}
+
+	return name
+}
+
+// leaseRole returns the role that issued the credentials of a lease, or nil if it was deleted since.
+func (b *grafanaBackend) leaseRole(ctx context.Context, req *logical.Request) (*grafanaRoleEntry, error) {
+	roleName, _ := req.Secret.InternalData["vault_role"].(string)
+	if roleName == "" {
+		return nil, nil
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	return role, nil
+}
+
+// disableLeaseServiceAccount deletes the tokens of the service account of a revoked lease, then disables and renames
+// the service account instead of deleting it. The service account is recorded so that purgeDisabledServiceAccounts
+// deletes it once the retention period of the role has passed.
+func (b *grafanaBackend) disableLeaseServiceAccount(ctx context.Context, req *logical.Request, c *client.Grafana, role *grafanaRoleEntry, connection string, stack string, serviceAccountID int64) (string, error) {
+	roleName, _ := req.Secret.InternalData["vault_role"].(string)
+
+	// Leases issued before credential names were recorded are named after the ID of their service account.
+	credentialName, _ := req.Secret.InternalData["credential_name"].(string)
+	if credentialName == "" {
+		credentialName = strconv.FormatInt(serviceAccountID, 10)
+	}
+
+	now := time.Now()
+
+	disabled := &disabledServiceAccount{
+		Name:             disabledServiceAccountName(credentialName),
+		OriginalName:     credentialName,
+		Connection:       connection,
+		Role:             roleName,
+		Stack:            stack,
+		ServiceAccountID: serviceAccountID,
+		DisabledAt:       now,
+	}
+
+	if role.DisabledRetention > 0 {
+		disabled.DeleteAt = now.Add(role.DisabledRetention)
+	}
+
+	err := disableServiceAccount(ctx, c, disabled)
+
+	if client.IsNotFound(err) {
+		b.Logger().Warn("service account of the lease was already deleted outside of vault", "service_account_id", serviceAccountID, "stack", stack, "connection", connection)
+		return revokeOutcomeAlreadyDeleted, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := putDisabledServiceAccount(ctx, req.Storage, disabled); err != nil {
+		return "", err
+	}
+
+	return revokeOutcomeDisabled, nil
+}
+
+// disableServiceAccount deletes all tokens of a service account, then disables and renames it.
+func disableServiceAccount(ctx context.Context, c *client.Grafana, disabled *disabledServiceAccount) error {
+	var (
+		tokens []client.ServiceAccountTokenInfo
+		err    error
+	)
+
+	if disabled.Stack != "" {
+		tokens, err = c.ListGrafanaServiceAccountTokensFromCloud(ctx, disabled.Stack, disabled.ServiceAccountID)
+	} else {
+		tokens, err = c.ListServiceAccountTokens(ctx, disabled.ServiceAccountID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err := deleteManagedObject(ctx, c, &managedObject{
+			Kind:             kindServiceAccountToken,
+			Stack:            disabled.Stack,
+			ServiceAccountID: disabled.ServiceAccountID,
+			TokenID:          token.ID,
+		})
+
+		if err != nil {
+			return fmt.Errorf("error deleting token of service account: %w", err)
+		}
+	}
+
+	isDisabled := true
+	input := client.UpdateServiceAccountInput{
+		Name:       disabled.Name,
+		IsDisabled: &isDisabled,
+	}
+
+	if disabled.Stack != "" {
+		_, err = c.UpdateGrafanaServiceAccountFromCloud(ctx, disabled.Stack, disabled.ServiceAccountID, input)
+	} else {
+		_, err = c.UpdateServiceAccount(ctx, disabled.ServiceAccountID, input)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error disabling service account: %w", err)
+	}
+
+	return nil
+}
+
+// purgeDisabledServiceAccounts is run by the periodic function and deletes the service accounts that were disabled
+// when their lease was revoked once their retention period has passed. Service accounts that cannot be deleted are
+// retried by the next purge.
+func (b *grafanaBackend) purgeDisabledServiceAccounts(ctx context.Context, s logical.Storage) error {
+	if time.Since(b.lastDisabledPurge) < disabledPurgeInterval {
+		return nil
+	}
+
+	disabled, err := listDisabledServiceAccounts(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, d := range disabled {
+		if d.DeleteAt.IsZero() || now.Before(d.DeleteAt) {
+			continue
+		}
+
+		c, err := b.getClient(ctx, s, d.Connection)
+		if err == nil {
+			err = deleteManagedObject(ctx, c, d.toManagedObject())
+		}
+
+		if err != nil {
+			b.Logger().Warn("error deleting disabled service account", "name", d.Name, "service_account_id", d.ServiceAccountID, "connection", d.Connection, "error", err)
+			continue
+		}
+
+		if err := s.Delete(ctx, disabledStoragePrefix+d.OriginalName); err != nil {
+			return fmt.Errorf("error deleting record of disabled service account: %w", err)
+		}
+	}
+
+	b.lastDisabledPurge = now
+
+	return nil
+}
+
+func putDisabledServiceAccount(ctx context.Context, s logical.Storage, d *disabledServiceAccount) error {
+	entry, err := logical.StorageEntryJSON(disabledStoragePrefix+d.OriginalName, d)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return fmt.Errorf("error storing record of disabled service account: %w", err)
+	}
+
+	return nil
+}
+
+func listDisabledServiceAccounts(ctx context.Context, s logical.Storage) ([]*disabledServiceAccount, error) {
+	names, err := s.List(ctx, disabledStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing disabled service accounts: %w", err)
+	}
+
+	disabled := make([]*disabledServiceAccount, 0, len(names))
+
+	for _, name := range names {
+		entry, err := s.Get(ctx, disabledStoragePrefix+name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading record of disabled service account: %w", err)
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		var d disabledServiceAccount
+		if err := entry.DecodeJSON(&d); err != nil {
+			return nil, fmt.Errorf("error decoding record of disabled service account: %w", err)
+		}
+
+		disabled = append(disabled, &d)
+	}
+
+	return disabled, nil
+}
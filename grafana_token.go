@@ -2,9 +2,13 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -14,12 +18,19 @@ const (
 )
 
 type grafanaToken struct {
-	IsCloud          bool   `json:"is_cloud"`
-	Token            string `json:"token"`
-	Stack            string `json:"stack"`              // For Grafana Cloud service accounts
-	Region           string `json:"region"`             // For Grafana Cloud access policies
-	AccessPolicyID   string `json:"access_policy_id"`   // For Grafana Cloud access policies
-	ServiceAccountID int64  `json:"service_account_id"` // For Grafana Cloud and Grafana service accounts
+	Name                string `json:"name"` // Name of the service account or access policy, or login of the user
+	IsCloud             bool   `json:"is_cloud"`
+	Token               string `json:"token"`
+	Stack               string `json:"stack"`                  // For Grafana Cloud service accounts
+	Region              string `json:"region"`                 // For Grafana Cloud access policies
+	AccessPolicyID      string `json:"access_policy_id"`       // For Grafana Cloud access policies
+	ServiceAccountID    int64  `json:"service_account_id"`     // For Grafana Cloud and Grafana service accounts
+	TokenID             int64  `json:"token_id"`               // For tokens of shared service accounts
+	AccessPolicyTokenID string `json:"access_policy_token_id"` // For tokens of shared access policies
+	UserID              int64  `json:"user_id"`                // For Grafana users
+	Password            string `json:"password"`               // For Grafana users
+
+	Elevation *userElevation `json:"elevation,omitempty"` // For the raised roles of existing Grafana users
 }
 
 func (b *grafanaBackend) grafanaToken() *framework.Secret {
@@ -37,9 +48,34 @@ func (b *grafanaBackend) grafanaToken() *framework.Secret {
 }
 
 func (b *grafanaBackend) tokenRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	client, err := b.getClient(ctx, req.Storage)
+	outcome, err := b.deleteLeaseObject(ctx, req)
+
+	if historyErr := b.recordRevocation(ctx, req, outcome, err); historyErr != nil {
+		// The history must not prevent credentials from being revoked.
+		b.Logger().Error("error recording revocation in credential history", "error", historyErr)
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("error getting client: %w", err)
+		return nil, err
+	}
+
+	return nil, b.forgetIssuedCredential(ctx, req)
+}
+
+// deleteLeaseObject deletes the service account, access policy or token backing the credentials of a lease, or
+// disables the service account if the role of the lease uses the disable revocation mode, and returns the outcome of
+// the revocation for the credential history.
+func (b *grafanaBackend) deleteLeaseObject(ctx context.Context, req *logical.Request) (string, error) {
+	// Leases created before named connections were supported use the default connection.
+	connection := defaultConnection
+
+	if val, ok := req.Secret.InternalData["connection"]; ok {
+		connection = val.(string)
+	}
+
+	c, err := b.getClient(ctx, req.Storage, connection)
+	if err != nil {
+		return "", fmt.Errorf("error getting client: %w", err)
 	}
 
 	isCloud := false
@@ -48,41 +84,167 @@ func (b *grafanaBackend) tokenRevoke(ctx context.Context, req *logical.Request,
 		isCloud = val.(bool)
 	}
 
-	if isCloud {
-		stack := ""
+	stack := ""
 
-		if val, ok := req.Secret.InternalData["stack"]; ok {
-			stack = val.(string)
-		}
+	if val, ok := req.Secret.InternalData["stack"]; ok {
+		stack = val.(string)
+	}
 
-		if stack != "" {
-			serviceAccountID := int64(req.Secret.InternalData["service_account_id"].(float64))
+	if isCloud && stack == "" {
+		accessPolicyID := req.Secret.InternalData["access_policy_id"].(string)
+		region := req.Secret.InternalData["region"].(string)
 
-			err := client.DeleteGrafanaServiceAccountFromCloud(stack, serviceAccountID)
+		// The shared access policy of a role outlives its leases, so only the token of the lease is deleted.
+		if tokenID, ok := req.Secret.InternalData["access_policy_token_id"].(string); ok {
+			err := c.DeleteCloudAccessPolicyToken(ctx, region, tokenID)
 
-			if err != nil {
-				return nil, fmt.Errorf("error deleting grafana cloud service account: %w", err)
+			if client.IsNotFound(err) {
+				b.Logger().Warn("token of the lease was already deleted outside of vault", "access_policy_id", accessPolicyID, "token_id", tokenID, "region", region, "connection", connection)
+				return revokeOutcomeAlreadyDeleted, nil
+			} else if err != nil {
+				return "", fmt.Errorf("error deleting grafana cloud access policy token: %w", err)
 			}
+
+			return revokeOutcomeRevoked, nil
+		}
+
+		err := c.DeleteCloudAccessPolicy(ctx, region, accessPolicyID)
+
+		if client.IsNotFound(err) {
+			b.Logger().Warn("access policy of the lease was already deleted outside of vault", "access_policy_id", accessPolicyID, "region", region, "connection", connection)
+			return revokeOutcomeAlreadyDeleted, nil
+		} else if err != nil {
+			return "", fmt.Errorf("error deleting grafana cloud access policy: %w", err)
+		}
+
+		return revokeOutcomeRevoked, nil
+	}
+
+	// Existing Grafana users outlive the lease, so only the roles they had before the lease are restored.
+	if val, ok := req.Secret.InternalData["elevation"]; ok {
+		elevation, err := decodeUserElevation(val)
+		if err != nil {
+			return "", err
+		}
+
+		err = restoreUserElevation(ctx, c, elevation)
+
+		if client.IsNotFound(err) {
+			b.Logger().Warn("user of the lease was already deleted outside of vault", "user_id", elevation.UserID, "login", elevation.Login, "connection", connection)
+			return revokeOutcomeAlreadyDeleted, nil
+		} else if err != nil {
+			return "", fmt.Errorf("error restoring roles of grafana user: %w", err)
+		}
+
+		return revokeOutcomeRevoked, nil
+	}
+
+	if _, ok := req.Secret.InternalData["user_id"]; ok {
+		userID, err := internalDataInt64(req.Secret.InternalData, "user_id")
+		if err != nil {
+			return "", err
+		}
+
+		err = c.DeleteUser(ctx, userID)
+
+		if client.IsNotFound(err) {
+			b.Logger().Warn("user of the lease was already deleted outside of vault", "user_id", userID, "connection", connection)
+			return revokeOutcomeAlreadyDeleted, nil
+		} else if err != nil {
+			return "", fmt.Errorf("error deleting grafana user: %w", err)
+		}
+
+		return revokeOutcomeRevoked, nil
+	}
+
+	serviceAccountID, err := internalDataInt64(req.Secret.InternalData, "service_account_id")
+	if err != nil {
+		return "", err
+	}
+
+	// The shared service account of a role outlives its leases, so only the token of the lease is deleted.
+	if _, ok := req.Secret.InternalData["token_id"]; ok {
+		tokenID, err := internalDataInt64(req.Secret.InternalData, "token_id")
+		if err != nil {
+			return "", err
+		}
+
+		if isCloud {
+			err = c.DeleteGrafanaServiceAccountTokenFromCloud(ctx, stack, serviceAccountID, tokenID)
 		} else {
-			accessPolicyID := req.Secret.InternalData["access_policy_id"].(string)
-			region := req.Secret.InternalData["region"].(string)
-			err := client.DeleteCloudAccessPolicy(region, accessPolicyID)
+			err = c.DeleteServiceAccountToken(ctx, serviceAccountID, tokenID)
+		}
 
-			if err != nil {
-				return nil, fmt.Errorf("error deleting grafana cloud access policy: %w", err)
-			}
+		if client.IsNotFound(err) {
+			b.Logger().Warn("token of the lease was already deleted outside of vault", "service_account_id", serviceAccountID, "token_id", tokenID, "stack", stack, "connection", connection)
+			return revokeOutcomeAlreadyDeleted, nil
+		} else if err != nil {
+			return "", fmt.Errorf("error deleting grafana service account token: %w", err)
 		}
 
+		return revokeOutcomeRevoked, nil
+	}
+
+	role, err := b.leaseRole(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	if role != nil && role.RevocationMode == revocationModeDisable {
+		return b.disableLeaseServiceAccount(ctx, req, c, role, connection, stack, serviceAccountID)
+	}
+
+	if isCloud {
+		err = c.DeleteGrafanaServiceAccountFromCloud(ctx, stack, serviceAccountID)
 	} else {
-		serviceAccountID := req.Secret.InternalData["service_account_id"].(int64)
-		err := client.DeleteServiceAccount(serviceAccountID)
+		err = c.DeleteServiceAccount(ctx, serviceAccountID)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("error deleting grafana service account: %w", err)
+	if client.IsNotFound(err) {
+		b.Logger().Warn("service account of the lease was already deleted outside of vault", "service_account_id", serviceAccountID, "stack", stack, "connection", connection)
+		return revokeOutcomeAlreadyDeleted, nil
+	} else if err != nil {
+		if isCloud {
+			return "", fmt.Errorf("error deleting grafana cloud service account: %w", err)
 		}
+
+		return "", fmt.Errorf("error deleting grafana service account: %w", err)
+	}
+
+	return revokeOutcomeRevoked, nil
+}
+
+// forgetIssuedCredential deletes the record of the credentials of a revoked lease. Leases issued before the records
+// were introduced have none.
+func (b *grafanaBackend) forgetIssuedCredential(ctx context.Context, req *logical.Request) error {
+	name, ok := req.Secret.InternalData["credential_name"].(string)
+	if !ok || name == "" {
+		return nil
 	}
 
-	return nil, nil
+	return deleteIssuedCredential(ctx, req.Storage, name)
+}
+
+// internalDataInt64 reads an integer from the internal data of a secret. Internal data is stored as JSON, so the
+// value is an int64 when the secret was just created, but a json.Number or float64 once it was read back from
+// storage.
+func internalDataInt64(data map[string]interface{}, key string) (int64, error) {
+	switch val := data[key].(type) {
+	case int64:
+		return val, nil
+	case int:
+		return int64(val), nil
+	case float64:
+		return int64(val), nil
+	case json.Number:
+		return val.Int64()
+	case string:
+		return strconv.ParseInt(val, 10, 64)
+	case nil:
+		return 0, fmt.Errorf("secret is missing %s internal data", key)
+	default:
+		return 0, fmt.Errorf("secret has invalid %s internal data of type %T", key, val)
+	}
 }
 
 func (b *grafanaBackend) tokenRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
@@ -111,5 +273,28 @@ func (b *grafanaBackend) tokenRenew(ctx context.Context, req *logical.Request, _
 		resp.Secret.MaxTTL = roleEntry.MaxTTL
 	}
 
+	// The lease must not outlive the token in Grafana, for example after the max TTL of the role was raised.
+	// Leases issued before tokens expired in Grafana have no expiry.
+	if val, ok := req.Secret.InternalData["expires_at"].(string); ok {
+		expiresAt, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, fmt.Errorf("secret has invalid expires_at internal data: %w", err)
+		}
+
+		remaining := time.Until(expiresAt)
+		if remaining <= 0 {
+			return logical.ErrorResponse("the token expired in Grafana at %s and cannot be renewed", val), nil
+		}
+
+		ttl := resp.Secret.TTL
+		if ttl <= 0 {
+			ttl = b.System().DefaultLeaseTTL()
+		}
+
+		if ttl > remaining {
+			resp.Secret.TTL = remaining
+		}
+	}
+
 	return resp, nil
 }
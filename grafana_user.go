@@ -0,0 +1,143 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+)
+
+const defaultPasswordLength = 32
+
+// passwordCharsets are the character classes of passwords generated without a password policy. Every password
+// contains a character of each class, so that it satisfies the strong password policy of Grafana.
+var passwordCharsets = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"0123456789",
+	"!#%*+-.:=?@^_~",
+}
+
+// createUser creates a temporary Grafana user with a generated password, for logging into the UI of a Grafana
+// instance when its single sign-on is unavailable. The user is added to the organization of the token of the
+// backend with the basic role and RBAC roles of the role.
+func (b *grafanaBackend) createUser(ctx context.Context, c *client.Grafana, wal *credentialWAL, credentialName string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
+	password, err := b.generatePassword(ctx, roleEntry.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := c.CurrentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up organization of the backend token: %w", err)
+	}
+
+	walID, err := wal.record(ctx, walKindUser, walEntry{Name: credentialName})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.CreateUser(ctx, client.CreateUserInput{
+		Name:     credentialName,
+		Login:    credentialName,
+		Password: password,
+		OrgID:    currentUser.OrgID,
+	})
+
+	// The rollback of the WAL entry would delete the existing user with the same login, which is not owned by the
+	// backend.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, fmt.Errorf("a user with login %s already exists: %w", credentialName, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating user: %w", err)
+	}
+
+	// Once its ID is known, the user is rolled back by its ID, so that a user created later with the same login is
+	// never deleted in its place.
+	if _, err := wal.record(ctx, walKindUser, walEntry{Name: credentialName, UserID: user.ID}); err != nil {
+		return nil, err
+	}
+
+	if err := wal.forget(ctx, walID); err != nil {
+		return nil, err
+	}
+
+	if err := setUserRoles(ctx, c, wal, user.ID, roleEntry); err != nil {
+		if deleteErr := c.DeleteUser(ctx, user.ID); deleteErr != nil {
+			return nil, fmt.Errorf("error deleting user after error setting roles: %w", deleteErr)
+		}
+
+		return nil, err
+	}
+
+	return &grafanaToken{
+		IsCloud:  false,
+		UserID:   user.ID,
+		Password: password,
+	}, nil
+}
+
+// setUserRoles sets the basic role of a user in the organization of the backend token and assigns the RBAC roles of
+// the role to it.
+func setUserRoles(ctx context.Context, c *client.Grafana, wal *credentialWAL, userID int64, roleEntry *grafanaRoleEntry) error {
+	if err := c.UpdateOrgUserRole(ctx, userID, roleEntry.basicRole()); err != nil {
+		return fmt.Errorf("error setting role of user: %w", err)
+	}
+
+	if len(roleEntry.RBACRoles) > 0 {
+		if err := setRBACRoles(ctx, c, wal, "", userID, roleEntry.RBACRoles); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generatePassword generates the password of a user from a Vault password policy, or from passwordCharsets if no
+// policy is set.
+func (b *grafanaBackend) generatePassword(ctx context.Context, policy string) (string, error) {
+	if policy != "" {
+		password, err := b.System().GeneratePasswordFromPolicy(ctx, policy)
+		if err != nil {
+			return "", fmt.Errorf("error generating password from password policy %s: %w", policy, err)
+		}
+
+		return password, nil
+	}
+
+	charset := strings.Join(passwordCharsets, "")
+	limit := big.NewInt(int64(len(charset)))
+
+	for {
+		password := make([]byte, defaultPasswordLength)
+
+		for i := range password {
+			n, err := rand.Int(rand.Reader, limit)
+			if err != nil {
+				return "", fmt.Errorf("error generating password: %w", err)
+			}
+
+			password[i] = charset[n.Int64()]
+		}
+
+		if hasAllPasswordCharsets(string(password)) {
+			return string(password), nil
+		}
+	}
+}
+
+func hasAllPasswordCharsets(password string) bool {
+	for _, charset := range passwordCharsets {
+		if !strings.ContainsAny(password, charset) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,182 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const issuedStoragePrefix = "issued/"
+
+// issuedCredential records a service account, access policy, token or user created for a lease, or the roles of an
+// existing user raised for it. It is written when the credentials are issued and deleted when the lease is revoked, so
+// that objects belonging to a lease can be told apart from orphaned ones, and so that objects seen in Grafana can be
+// traced back to the request that created them.
+type issuedCredential struct {
+	Name                string         `json:"name"` // Name of the service account, access policy or token
+	Connection          string         `json:"connection"`
+	Role                string         `json:"role"`
+	IsCloud             bool           `json:"is_cloud"`
+	Stack               string         `json:"stack,omitempty"`                  // For Grafana Cloud service accounts
+	Region              string         `json:"region,omitempty"`                 // For Grafana Cloud access policies
+	AccessPolicyID      string         `json:"access_policy_id,omitempty"`       // For Grafana Cloud access policies
+	ServiceAccountID    int64          `json:"service_account_id,omitempty"`     // For Grafana Cloud and Grafana service accounts
+	TokenID             int64          `json:"token_id,omitempty"`               // For tokens of shared service accounts
+	AccessPolicyTokenID string         `json:"access_policy_token_id,omitempty"` // For tokens of shared access policies
+	UserID              int64          `json:"user_id,omitempty"`                // For Grafana users
+	Elevation           *userElevation `json:"elevation,omitempty"`              // For the raised roles of existing Grafana users
+	EntityID            string         `json:"entity_id,omitempty"`              // Vault entity that requested the credentials
+	DisplayName         string         `json:"display_name,omitempty"`           // Display name of the Vault token that requested the credentials
+	RequestID           string         `json:"request_id,omitempty"`             // ID of the Vault request, which the audit log maps to the lease ID
+	NoLease             bool           `json:"no_lease,omitempty"`               // Whether the credentials were issued without a lease
+	IssuedAt            time.Time      `json:"issued_at"`
+	ExpiresAt           time.Time      `json:"expires_at"` // When the lease expires at the latest, or the token for credentials without a lease
+}
+
+func (i *issuedCredential) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"name":         i.Name,
+		"type":         i.kind(),
+		"connection":   i.Connection,
+		"role":         i.Role,
+		"is_cloud":     i.IsCloud,
+		"entity_id":    i.EntityID,
+		"display_name": i.DisplayName,
+		"request_id":   i.RequestID,
+		"no_lease":     i.NoLease,
+		"issued_at":    i.IssuedAt.Format(time.RFC3339),
+		"expires_at":   i.ExpiresAt.Format(time.RFC3339),
+	}
+
+	if i.AccessPolicyID != "" {
+		data["region"] = i.Region
+		data["access_policy_id"] = i.AccessPolicyID
+	} else if i.UserID != 0 {
+		data["user_id"] = i.UserID
+	} else {
+		data["stack"] = i.Stack
+		data["service_account_id"] = i.ServiceAccountID
+	}
+
+	if i.TokenID != 0 {
+		data["token_id"] = i.TokenID
+	}
+
+	if i.Elevation != nil {
+		data["login"] = i.Elevation.Login
+		data["previous_role"] = i.Elevation.PreviousRole
+	}
+
+	if i.AccessPolicyTokenID != "" {
+		data["access_policy_token_id"] = i.AccessPolicyTokenID
+	}
+
+	return data
+}
+
+// kind returns the kind of Grafana object the credentials are backed by.
+func (i *issuedCredential) kind() string {
+	if i.Elevation != nil {
+		return walKindUserElevation
+	}
+
+	if i.UserID != 0 {
+		return walKindUser
+	}
+
+	if i.AccessPolicyTokenID != "" {
+		return kindAccessPolicyToken
+	}
+
+	if i.AccessPolicyID != "" {
+		return walKindAccessPolicy
+	}
+
+	if i.TokenID != 0 {
+		return kindServiceAccountToken
+	}
+
+	return walKindServiceAccount
+}
+
+// toManagedObject returns the service account, access policy or token the credentials are backed by.
+func (i *issuedCredential) toManagedObject() *managedObject {
+	return &managedObject{
+		Kind:                i.kind(),
+		Connection:          i.Connection,
+		Name:                i.Name,
+		Stack:               i.Stack,
+		Region:              i.Region,
+		ServiceAccountID:    i.ServiceAccountID,
+		AccessPolicyID:      i.AccessPolicyID,
+		TokenID:             i.TokenID,
+		AccessPolicyTokenID: i.AccessPolicyTokenID,
+		UserID:              i.UserID,
+		Elevation:           i.Elevation,
+		CreatedAt:           i.IssuedAt,
+	}
+}
+
+func putIssuedCredential(ctx context.Context, s logical.Storage, issued *issuedCredential) error {
+	entry, err := logical.StorageEntryJSON(issuedStoragePrefix+issued.Name, issued)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return fmt.Errorf("error storing issued credentials: %w", err)
+	}
+
+	return nil
+}
+
+func getIssuedCredential(ctx context.Context, s logical.Storage, name string) (*issuedCredential, error) {
+	entry, err := s.Get(ctx, issuedStoragePrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading issued credentials: %w", err)
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var i issuedCredential
+	if err := entry.DecodeJSON(&i); err != nil {
+		return nil, fmt.Errorf("error decoding issued credentials: %w", err)
+	}
+
+	return &i, nil
+}
+
+func deleteIssuedCredential(ctx context.Context, s logical.Storage, name string) error {
+	if err := s.Delete(ctx, issuedStoragePrefix+name); err != nil {
+		return fmt.Errorf("error deleting issued credentials: %w", err)
+	}
+
+	return nil
+}
+
+// listIssuedCredentials returns the credentials of all leases that were not revoked yet.
+func listIssuedCredentials(ctx context.Context, s logical.Storage) ([]*issuedCredential, error) {
+	names, err := s.List(ctx, issuedStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing issued credentials: %w", err)
+	}
+
+	issued := make([]*issuedCredential, 0, len(names))
+
+	for _, name := range names {
+		i, err := getIssuedCredential(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if i != nil {
+			issued = append(issued, i)
+		}
+	}
+
+	return issued, nil
+}
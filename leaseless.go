@@ -0,0 +1,54 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const leaselessSweepInterval = 5 * time.Minute
+
+// sweepLeaselessCredentials is run by the periodic function and deletes the service accounts and access policies of
+// credentials issued without a lease once their tokens expired in Grafana. Objects that cannot be deleted are
+// retried by the next sweep.
+func (b *grafanaBackend) sweepLeaselessCredentials(ctx context.Context, s logical.Storage) error {
+	if time.Since(b.lastLeaselessSweep) < leaselessSweepInterval {
+		return nil
+	}
+
+	issued, err := listIssuedCredentials(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, i := range issued {
+		if !i.NoLease || now.Before(i.ExpiresAt) {
+			continue
+		}
+
+		c, err := b.getClient(ctx, s, i.Connection)
+		if err == nil {
+			err = deleteManagedObject(ctx, c, i.toManagedObject())
+		}
+
+		if historyErr := recordExpiry(ctx, s, i, err); historyErr != nil {
+			b.Logger().Error("error recording expiry in credential history", "error", historyErr)
+		}
+
+		if err != nil {
+			b.Logger().Warn("error deleting expired credentials without a lease", "type", i.kind(), "name", i.Name, "connection", i.Connection, "error", err)
+			continue
+		}
+
+		if err := deleteIssuedCredential(ctx, s, i.Name); err != nil {
+			return err
+		}
+	}
+
+	b.lastLeaselessSweep = now
+
+	return nil
+}
@@ -0,0 +1,473 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	credentialNamePrefix         = "vault-"
+	tempServiceAccountNamePrefix = "vault-temp-service-account-"
+
+	// sharedObjectNamePrefix is the prefix of the names of the shared service accounts and access policies of roles,
+	// followed by the role name. The names do not match credentialNameRegex, so that tidy leaves them alone.
+	sharedObjectNamePrefix = "vault-role-"
+
+	// kindServiceAccountToken and kindAccessPolicyToken are the kinds of the tokens of shared service accounts and
+	// access policies, which are the only objects created for their leases.
+	kindServiceAccountToken = "service_account_token"
+	kindAccessPolicyToken   = "access_policy_token"
+)
+
+var (
+	// credentialNameRegex matches the names of service accounts and access policies created for leases, which
+	// start with the marker of the mount that created them.
+	credentialNameRegex = regexp.MustCompile(`^vault-([0-9a-f]{8})-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+	// tempServiceAccountNameRegex matches the names of the temporary service accounts used to assign RBAC roles in
+	// Grafana Cloud stacks. The name ends with the marker of the mount and the time the service account was created
+	// in nanoseconds.
+	tempServiceAccountNameRegex = regexp.MustCompile(`^vault-temp-service-account-([0-9a-f]{8})-(\d+)$`)
+)
+
+// newMountMarker returns the marker embedded in the names of the objects created by a mount, so that tidy and
+// revoke-all never take the objects of other mounts, or the ones created before names were marked, for their own. It
+// is derived from the UUID of the mount, which does not change while the mount exists.
+func newMountMarker(backendUUID string) string {
+	if backendUUID == "" {
+		backendUUID = uuid.NewString()
+	}
+
+	sum := sha256.Sum256([]byte(backendUUID))
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// defaultCredentialName returns a new vault-<marker>-<uuid> name for credentials of roles without a name template.
+func defaultCredentialName(mountMarker string) string {
+	return fmt.Sprintf("%s%s-%s", credentialNamePrefix, mountMarker, uuid.New())
+}
+
+// tempServiceAccountName returns the name of a temporary service account created at the given time.
+func tempServiceAccountName(mountMarker string, createdAt time.Time) string {
+	return fmt.Sprintf("%s%s-%d", tempServiceAccountNamePrefix, mountMarker, createdAt.UnixNano())
+}
+
+// managedObject is a service account, access policy, token or user that was created by the backend, or an existing
+// user whose roles were raised by it.
+type managedObject struct {
+	Kind                string // walKindServiceAccount, walKindAccessPolicy, walKindUser, walKindUserElevation, kindServiceAccountToken or kindAccessPolicyToken
+	Connection          string
+	Name                string
+	Stack               string // For service accounts in Grafana Cloud stacks
+	Region              string // For Grafana Cloud access policies
+	ServiceAccountID    int64
+	AccessPolicyID      string
+	TokenID             int64          // For tokens of shared service accounts
+	AccessPolicyTokenID string         // For tokens of shared access policies
+	UserID              int64          // For Grafana users
+	Elevation           *userElevation // For the raised roles of existing Grafana users
+	CreatedAt           time.Time      // Zero if unknown
+}
+
+func (o *managedObject) id() string {
+	if o.Kind == walKindAccessPolicy {
+		return o.AccessPolicyID
+	}
+
+	if o.Kind == kindServiceAccountToken {
+		return strconv.FormatInt(o.TokenID, 10)
+	}
+
+	if o.Kind == kindAccessPolicyToken {
+		return o.AccessPolicyTokenID
+	}
+
+	if o.Kind == walKindUser || o.Kind == walKindUserElevation {
+		return strconv.FormatInt(o.UserID, 10)
+	}
+
+	return strconv.FormatInt(o.ServiceAccountID, 10)
+}
+
+func (o *managedObject) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"type":       o.Kind,
+		"connection": o.Connection,
+		"name":       o.Name,
+		"id":         o.id(),
+	}
+
+	if o.Stack != "" {
+		data["stack"] = o.Stack
+	}
+
+	if o.Region != "" {
+		data["region"] = o.Region
+	}
+
+	if o.Kind == kindServiceAccountToken {
+		data["service_account_id"] = o.ServiceAccountID
+	}
+
+	if o.Kind == kindAccessPolicyToken {
+		data["access_policy_id"] = o.AccessPolicyID
+	}
+
+	if !o.CreatedAt.IsZero() {
+		data["created_at"] = o.CreatedAt.Format(time.RFC3339)
+	}
+
+	return data
+}
+
+// isManagedObjectName reports whether the name is one the backend gives to the objects it creates, with the marker of
+// this mount. Names generated by name templates end with the marker. Disabled and shared objects are left alone.
+func (b *grafanaBackend) isManagedObjectName(name string) bool {
+	if strings.HasPrefix(name, disabledServiceAccountNamePrefix) || strings.HasPrefix(name, sharedObjectNamePrefix) {
+		return false
+	}
+
+	if strings.HasSuffix(name, "-"+b.mountMarker) {
+		return true
+	}
+
+	groups := credentialNameRegex.FindStringSubmatch(name)
+	if groups == nil {
+		groups = tempServiceAccountNameRegex.FindStringSubmatch(name)
+	}
+
+	return groups != nil && groups[1] == b.mountMarker
+}
+
+// protectedObjectKey identifies a service account or access policy that must never be deleted.
+func protectedObjectKey(kind string, stack string, id string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, stack, id)
+}
+
+// protectedObjects returns the keys of the service accounts and access policies that own the tokens of the
+// connections and static roles of the mount, including the given configuration. They are not created by the backend,
+// so they are never deleted, even if their names look like the ones it gives to its objects.
+func (b *grafanaBackend) protectedObjects(ctx context.Context, s logical.Storage, config *grafanaConfig) (map[string]bool, error) {
+	protected := map[string]bool{}
+
+	addConfig := func(config *grafanaConfig) {
+		if config.ServiceAccountID != 0 {
+			protected[protectedObjectKey(walKindServiceAccount, "", strconv.FormatInt(config.ServiceAccountID, 10))] = true
+		}
+
+		if config.AccessPolicyID != "" {
+			protected[protectedObjectKey(walKindAccessPolicy, "", config.AccessPolicyID)] = true
+		}
+	}
+
+	addConfig(config)
+
+	connections, err := listConnections(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, connection := range connections {
+		config, err := getConfig(ctx, s, connection)
+		if err != nil {
+			return nil, err
+		}
+
+		if config != nil {
+			addConfig(config)
+		}
+	}
+
+	names, err := s.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing static roles: %w", err)
+	}
+
+	for _, name := range names {
+		role, err := b.getStaticRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			continue
+		}
+
+		if role.ServiceAccountID != 0 {
+			protected[protectedObjectKey(walKindServiceAccount, role.Stack, strconv.FormatInt(role.ServiceAccountID, 10))] = true
+		}
+
+		if role.AccessPolicyID != "" {
+			protected[protectedObjectKey(walKindAccessPolicy, "", role.AccessPolicyID)] = true
+		}
+	}
+
+	return protected, nil
+}
+
+// managedObjectTargets returns the Grafana Cloud stacks and regions the backend may have created objects in for a
+// Grafana Cloud connection: the ones referenced by roles and by the credentials of leases.
+func (b *grafanaBackend) managedObjectTargets(ctx context.Context, s logical.Storage, connection string, issued []*issuedCredential) (stacks []string, regions []string, err error) {
+	roleNames, err := s.List(ctx, "roles/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing roles: %w", err)
+	}
+
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if role == nil || role.Connection != connection {
+			continue
+		}
+
+		if role.Stack != "" {
+			stacks = append(stacks, role.Stack)
+		}
+
+		if role.Region != "" {
+			regions = append(regions, role.Region)
+		}
+	}
+
+	for _, i := range issued {
+		if i.Connection != connection {
+			continue
+		}
+
+		if i.Stack != "" {
+			stacks = append(stacks, i.Stack)
+		}
+
+		if i.Region != "" {
+			regions = append(regions, i.Region)
+		}
+	}
+
+	slices.Sort(stacks)
+	slices.Sort(regions)
+
+	return slices.Compact(stacks), slices.Compact(regions), nil
+}
+
+// listManagedObjects finds the service accounts and access policies created by the backend through a connection.
+// Objects are recognized by their names, either by the names the backend gives to the objects it creates or, if
+// namePrefix is set, by the prefix. The owners of the tokens of the connections and static roles are never returned.
+// The creation time is only known for temporary service accounts and access policies. Listing errors of individual
+// stacks and regions are returned as warnings.
+func (b *grafanaBackend) listManagedObjects(ctx context.Context, s logical.Storage, c *client.Grafana, connection string, config *grafanaConfig, issued []*issuedCredential, namePrefix string) ([]*managedObject, []string, error) {
+	// Grafana matches the query anywhere in the names, so the marker finds default and templated names alike.
+	query, match := b.mountMarker, b.isManagedObjectName
+
+	if namePrefix != "" {
+		query = namePrefix
+		match = func(name string) bool { return strings.HasPrefix(name, namePrefix) }
+	}
+
+	protected, err := b.protectedObjects(ctx, s, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.Type != GrafanaCloudType {
+		serviceAccounts, err := c.ListServiceAccounts(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return managedServiceAccounts(connection, "", serviceAccounts, match, protected), nil, nil
+	}
+
+	stacks, regions, err := b.managedObjectTargets(ctx, s, connection, issued)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		objects  []*managedObject
+		warnings []string
+	)
+
+	for _, stack := range stacks {
+		serviceAccounts, err := c.ListGrafanaServiceAccountsFromCloud(ctx, stack, query)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("error listing service accounts of stack %s: %s", stack, err))
+			continue
+		}
+
+		objects = append(objects, managedServiceAccounts(connection, stack, serviceAccounts, match, protected)...)
+	}
+
+	for _, region := range regions {
+		policies, err := c.ListCloudAccessPolicies(ctx, region)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("error listing access policies in region %s: %s", region, err))
+			continue
+		}
+
+		for _, policy := range policies {
+			if !match(policy.Name) || protected[protectedObjectKey(walKindAccessPolicy, "", policy.ID)] {
+				continue
+			}
+
+			objects = append(objects, &managedObject{
+				Kind:           walKindAccessPolicy,
+				Connection:     connection,
+				Name:           policy.Name,
+				Region:         region,
+				AccessPolicyID: policy.ID,
+				CreatedAt:      policy.CreatedAt,
+			})
+		}
+	}
+
+	return objects, warnings, nil
+}
+
+func managedServiceAccounts(connection string, stack string, serviceAccounts []client.ServiceAccount, match func(name string) bool, protected map[string]bool) []*managedObject {
+	var objects []*managedObject
+
+	for _, serviceAccount := range serviceAccounts {
+		if !match(serviceAccount.Name) || protected[protectedObjectKey(walKindServiceAccount, stack, strconv.FormatInt(serviceAccount.ID, 10))] {
+			continue
+		}
+
+		object := &managedObject{
+			Kind:             walKindServiceAccount,
+			Connection:       connection,
+			Name:             serviceAccount.Name,
+			Stack:            stack,
+			ServiceAccountID: serviceAccount.ID,
+			CreatedAt:        serviceAccount.CreatedAt,
+		}
+
+		if groups := tempServiceAccountNameRegex.FindStringSubmatch(serviceAccount.Name); groups != nil {
+			if nanos, err := strconv.ParseInt(groups[2], 10, 64); err == nil {
+				object.CreatedAt = time.Unix(0, nanos)
+			}
+		}
+
+		objects = append(objects, object)
+	}
+
+	return objects
+}
+
+// lookupCreatedAt fills in the creation time of a service account, which the search API does not return.
+func lookupCreatedAt(ctx context.Context, c *client.Grafana, object *managedObject) error {
+	if !object.CreatedAt.IsZero() || object.Kind != walKindServiceAccount {
+		return nil
+	}
+
+	var (
+		serviceAccount *client.ServiceAccount
+		err            error
+	)
+
+	if object.Stack != "" {
+		serviceAccount, err = c.GetGrafanaServiceAccountFromCloud(ctx, object.Stack, object.ServiceAccountID)
+	} else {
+		var result client.ServiceAccount
+		result, err = c.GetServiceAccount(ctx, object.ServiceAccountID)
+		serviceAccount = &result
+	}
+
+	if err != nil {
+		return err
+	}
+
+	object.CreatedAt = serviceAccount.CreatedAt
+
+	return nil
+}
+
+// deleteManagedObject deletes a service account, access policy, token or user. The roles of an existing user are
+// restored instead, as the user is not owned by the backend. Objects that no longer exist are ignored.
+func deleteManagedObject(ctx context.Context, c *client.Grafana, object *managedObject) error {
+	var err error
+
+	switch {
+	case object.Kind == walKindAccessPolicy:
+		err = c.DeleteCloudAccessPolicy(ctx, object.Region, object.AccessPolicyID)
+	case object.Kind == kindAccessPolicyToken:
+		err = c.DeleteCloudAccessPolicyToken(ctx, object.Region, object.AccessPolicyTokenID)
+	case object.Kind == walKindUser:
+		err = c.DeleteUser(ctx, object.UserID)
+	case object.Kind == walKindUserElevation:
+		err = restoreUserElevation(ctx, c, object.Elevation)
+	case object.Kind == kindServiceAccountToken && object.Stack != "":
+		err = c.DeleteGrafanaServiceAccountTokenFromCloud(ctx, object.Stack, object.ServiceAccountID, object.TokenID)
+	case object.Kind == kindServiceAccountToken:
+		err = c.DeleteServiceAccountToken(ctx, object.ServiceAccountID, object.TokenID)
+	case object.Stack != "":
+		err = c.DeleteGrafanaServiceAccountFromCloud(ctx, object.Stack, object.ServiceAccountID)
+	default:
+		err = c.DeleteServiceAccount(ctx, object.ServiceAccountID)
+	}
+
+	if err != nil && !client.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// sharedObjects returns the shared service accounts and access policies of the roles of a connection.
+func (b *grafanaBackend) sharedObjects(ctx context.Context, s logical.Storage, connection string) ([]*managedObject, error) {
+	roleNames, err := s.List(ctx, "roles/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+
+	var objects []*managedObject
+
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			continue
+		}
+
+		if role.ServiceAccount != nil && role.ServiceAccount.Connection == connection {
+			objects = append(objects, role.ServiceAccount.toManagedObject())
+		}
+
+		if role.AccessPolicy != nil && role.AccessPolicy.Connection == connection {
+			objects = append(objects, role.AccessPolicy.toManagedObject())
+		}
+	}
+
+	return objects, nil
+}
+
+// deleteSharedObject deletes the shared service account or access policy of a role, which also deletes the tokens of
+// the leases issued for it, and returns warnings if it could not be deleted.
+func (b *grafanaBackend) deleteSharedObject(ctx context.Context, s logical.Storage, object *managedObject) []string {
+	c, err := b.getClient(ctx, s, object.Connection)
+	if err == nil {
+		err = deleteManagedObject(ctx, c, object)
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("error deleting shared %s %s, delete it manually: %s", object.Kind, object.Name, err)}
+	}
+
+	return nil
+}
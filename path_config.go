@@ -2,25 +2,62 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
+	"time"
 
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/rotation"
 )
 
 const (
 	configStoragePath      = "config"
+	defaultConnection      = "default"
 	defaultGrafanaCloudURL = "https://grafana.com"
 	GrafanaCloudType       = "cloud"
 	GrafanaType            = "grafana"
+
+	// defaultMaxRetries is how often failed requests to Grafana are retried unless configured otherwise
+	defaultMaxRetries = 3
+
+	// tokenExpiryWarningPeriod is how long before the configured token expires warnings start to be emitted
+	tokenExpiryWarningPeriod = 7 * 24 * time.Hour
 )
 
 type grafanaConfig struct {
 	Type  string `json:"type"`
 	Token string `json:"token"`
 	URL   string `json:"url,omitempty"`
+
+	// The following fields configure how the backend connects to Grafana.
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"`
+	TLSServerName      string `json:"tls_server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	ProxyURL           string `json:"proxy_url,omitempty"`
+
+	// The following fields configure timeouts, retries and client-side rate limiting of requests to Grafana.
+	RequestTimeout    time.Duration `json:"request_timeout,omitempty"`
+	MaxRetries        *int          `json:"max_retries,omitempty"` // Unset for configurations written before retries were supported
+	RequestsPerSecond float64       `json:"requests_per_second,omitempty"`
+	MaxInFlight       int           `json:"max_in_flight,omitempty"`
+
+	RotationPeriod   time.Duration `json:"rotation_period,omitempty"`
+	RotationSchedule string        `json:"rotation_schedule,omitempty"`
+	LastRotated      time.Time     `json:"last_rotated,omitempty"`
+	TokenExpiresAt   *time.Time    `json:"token_expires_at,omitempty"`
+
+	// The following fields identify the owner of the token. They are resolved when the token is rotated.
+	TokenID          string `json:"token_id,omitempty"`
+	ServiceAccountID int64  `json:"service_account_id,omitempty"` // For Grafana service account tokens
+	Region           string `json:"region,omitempty"`             // For Grafana Cloud access policy tokens
+	AccessPolicyID   string `json:"access_policy_id,omitempty"`   // For Grafana Cloud access policy tokens
 }
 
 func (c *grafanaConfig) validate() error {
@@ -46,61 +83,332 @@ func (c *grafanaConfig) validate() error {
 		}
 	}
 
+	if _, err := client.NewTransport(c.transportConfig()); err != nil {
+		return err
+	}
+
+	if c.RequestTimeout < 0 {
+		return errors.New("request_timeout must not be negative")
+	}
+
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+
+	if c.RequestsPerSecond < 0 {
+		return errors.New("requests_per_second must not be negative")
+	}
+
+	if c.MaxInFlight < 0 {
+		return errors.New("max_in_flight must not be negative")
+	}
+
+	if c.RotationPeriod != 0 && c.RotationSchedule != "" {
+		return errors.New("only one of rotation_period or rotation_schedule may be set")
+	}
+
+	if c.RotationPeriod != 0 && c.RotationPeriod < minRotationPeriod {
+		return fmt.Errorf("rotation_period must be at least %s", minRotationPeriod)
+	}
+
+	if c.RotationSchedule != "" {
+		if _, err := rotation.DefaultScheduler.Parse(c.RotationSchedule); err != nil {
+			return fmt.Errorf("invalid rotation_schedule: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func pathConfig(b *grafanaBackend) *framework.Path {
-	return &framework.Path{
-		Pattern: "config",
-		Fields: map[string]*framework.FieldSchema{
-			"type": {
-				Type:        framework.TypeString,
-				Description: "The type of Grafana instance to generate tokens for. Either 'cloud' or 'grafana'",
-				Required:    true,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "Type",
-					Sensitive: false,
+func (c *grafanaConfig) transportConfig() client.TransportConfig {
+	return client.TransportConfig{
+		CACert:             c.CACert,
+		ClientCert:         c.ClientCert,
+		ClientKey:          c.ClientKey,
+		TLSServerName:      c.TLSServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ProxyURL:           c.ProxyURL,
+	}
+}
+
+func (c *grafanaConfig) requestTimeout() time.Duration {
+	if c.RequestTimeout == 0 {
+		return client.DefaultTimeout
+	}
+
+	return c.RequestTimeout
+}
+
+func (c *grafanaConfig) maxRetries() int {
+	if c.MaxRetries == nil {
+		return defaultMaxRetries
+	}
+
+	return *c.MaxRetries
+}
+
+// redactedProxyURL returns the proxy URL with any password replaced, so that it can be returned on reads.
+func (c *grafanaConfig) redactedProxyURL() string {
+	if c.ProxyURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Redacted()
+}
+
+// nextRotation returns when the token is due to be rotated, or the zero time if automated rotation is disabled.
+func (c *grafanaConfig) nextRotation() time.Time {
+	if c.RotationPeriod > 0 {
+		return c.LastRotated.Add(c.RotationPeriod)
+	}
+
+	if c.RotationSchedule != "" {
+		schedule, err := rotation.DefaultScheduler.Parse(c.RotationSchedule)
+		if err != nil {
+			return time.Time{}
+		}
+
+		return schedule.Next(c.LastRotated)
+	}
+
+	return time.Time{}
+}
+
+// expiryWarning returns a warning if the token has expired or is about to expire.
+func (c *grafanaConfig) expiryWarning() string {
+	if c.TokenExpiresAt == nil {
+		return ""
+	}
+
+	if remaining := time.Until(*c.TokenExpiresAt); remaining <= 0 {
+		return fmt.Sprintf("the configured token expired at %s", c.TokenExpiresAt.Format(time.RFC3339))
+	} else if remaining < tokenExpiryWarningPeriod {
+		return fmt.Sprintf("the configured token expires at %s", c.TokenExpiresAt.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+func (c *grafanaConfig) clearTokenOwner() {
+	c.TokenID = ""
+	c.ServiceAccountID = 0
+	c.Region = ""
+	c.AccessPolicyID = ""
+}
+
+func pathConfig(b *grafanaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config" + optionalConnectionRegex(),
+			Fields: map[string]*framework.FieldSchema{
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the connection. Defaults to the default connection stored at config",
+					Required:    false,
 				},
-			},
-			"token": {
-				Type:        framework.TypeString,
-				Description: "The token to use for authentication",
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "Token",
-					Sensitive: true,
+				"type": {
+					Type:        framework.TypeString,
+					Description: "The type of Grafana instance to generate tokens for. Either 'cloud' or 'grafana'",
+					Required:    true,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Type",
+						Sensitive: false,
+					},
+				},
+				"token": {
+					Type:        framework.TypeString,
+					Description: "The token to use for authentication",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Token",
+						Sensitive: true,
+					},
+				},
+				"token_id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the configured service account token, which is deleted when the token is rotated. Only needed when the service account has several tokens",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Token ID",
+						Sensitive: false,
+					},
+				},
+				"url": {
+					Type:        framework.TypeString,
+					Description: "The URL of the Grafana Cloud or Grafana instance to connect to",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "URL",
+						Sensitive: false,
+					},
+				},
+				"ca_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM encoded CA certificates used to verify the certificate of the Grafana instance",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "CA certificate",
+						Sensitive: false,
+					},
+				},
+				"client_cert": {
+					Type:        framework.TypeString,
+					Description: "PEM encoded client certificate presented to Grafana for mutual TLS. Requires client_key",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Client certificate",
+						Sensitive: false,
+					},
+				},
+				"client_key": {
+					Type:        framework.TypeString,
+					Description: "PEM encoded private key of the client certificate",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Client key",
+						Sensitive: true,
+					},
+				},
+				"tls_server_name": {
+					Type:        framework.TypeString,
+					Description: "The server name used to verify the certificate of the Grafana instance",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "TLS server name",
+						Sensitive: false,
+					},
+				},
+				"insecure_skip_verify": {
+					Type:        framework.TypeBool,
+					Description: "Skip verification of the certificate of the Grafana instance. Not recommended for production",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Insecure skip verify",
+						Sensitive: false,
+					},
+				},
+				"proxy_url": {
+					Type:        framework.TypeString,
+					Description: "The URL of an HTTP proxy to connect to Grafana through. Defaults to the proxy set in the environment",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Proxy URL",
+						Sensitive: false,
+					},
+				},
+				"request_timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long a request to Grafana may take, including retries. Defaults to 30 seconds",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Request timeout",
+						Sensitive: false,
+					},
+				},
+				"max_retries": {
+					Type:        framework.TypeInt,
+					Description: "How often failed requests to Grafana are retried. Set to 0 to disable retries",
+					Default:     defaultMaxRetries,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Max retries",
+						Sensitive: false,
+					},
+				},
+				"requests_per_second": {
+					Type:        framework.TypeFloat,
+					Description: "The maximum number of requests per second sent to Grafana. Set to 0 to disable the limit",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Requests per second",
+						Sensitive: false,
+					},
+				},
+				"max_in_flight": {
+					Type:        framework.TypeInt,
+					Description: "The maximum number of concurrent requests sent to Grafana. Set to 0 to disable the limit",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Max in flight",
+						Sensitive: false,
+					},
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often the token is rotated automatically. Mutually exclusive with rotation_schedule. Set to 0 to disable.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Rotation period",
+						Sensitive: false,
+					},
+				},
+				"rotation_schedule": {
+					Type:        framework.TypeString,
+					Description: "A cron-style schedule for rotating the token automatically. Mutually exclusive with rotation_period. Set to an empty string to disable.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Rotation schedule",
+						Sensitive: false,
+					},
+				},
+				"verify_connection": {
+					Type:        framework.TypeBool,
+					Description: "Verify that Grafana can be reached and that the token has the required permissions before storing the configuration",
+					Default:     true,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Name:      "Verify connection",
+						Sensitive: false,
+					},
 				},
 			},
-			"url": {
-				Type:        framework.TypeString,
-				Description: "The URL of the Grafana Cloud or Grafana instance to connect to",
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "URL",
-					Sensitive: false,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathConfigRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathConfigWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathConfigWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathConfigDelete,
 				},
 			},
+			ExistenceCheck:  b.pathConfigExistenceCheck,
+			HelpSynopsis:    pathConfigHelpSynopsis,
+			HelpDescription: pathConfigHelpDescription,
 		},
-		Operations: map[logical.Operation]framework.OperationHandler{
-			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.pathConfigRead,
-			},
-			logical.CreateOperation: &framework.PathOperation{
-				Callback: b.pathConfigWrite,
-			},
-			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathConfigWrite,
-			},
-			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.pathConfigDelete,
+		{
+			Pattern: "config/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathConfigList,
+				},
 			},
+			HelpSynopsis:    pathConfigListHelpSynopsis,
+			HelpDescription: pathConfigListHelpDescription,
 		},
-		ExistenceCheck:  b.pathConfigExistenceCheck,
-		HelpSynopsis:    pathConfigHelpSynopsis,
-		HelpDescription: pathConfigHelpDescription,
 	}
 }
 
-func (b *grafanaBackend) pathConfigExistenceCheck(ctx context.Context, req *logical.Request, _ *framework.FieldData) (bool, error) {
-	out, err := req.Storage.Get(ctx, req.Path)
+// optionalConnectionRegex matches an optional connection name at the end of a path. Paths without a connection
+// name use the default connection.
+func optionalConnectionRegex() string {
+	return fmt.Sprintf("(/%s)?$", framework.GenericNameRegex("connection"))
+}
+
+// connectionName returns the connection of the request, falling back to the default connection.
+func connectionName(d *framework.FieldData) string {
+	if connection, ok := d.GetOk("connection"); ok && connection.(string) != "" {
+		return connection.(string)
+	}
+
+	return defaultConnection
+}
+
+// configStorageKey returns the storage key of a connection. The default connection is stored at "config" so that
+// configurations written before named connections were supported keep working.
+func configStorageKey(connection string) string {
+	if connection == "" || connection == defaultConnection {
+		return configStoragePath
+	}
+
+	return configStoragePath + "/" + connection
+}
+
+func (b *grafanaBackend) pathConfigExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, configStorageKey(connectionName(d)))
 	if err != nil {
 		return false, fmt.Errorf("existence check failed: %w", err)
 	}
@@ -108,8 +416,17 @@ func (b *grafanaBackend) pathConfigExistenceCheck(ctx context.Context, req *logi
 	return out != nil, nil
 }
 
-func (b *grafanaBackend) pathConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+func (b *grafanaBackend) pathConfigList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	connections, err := listConnections(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(connections), nil
+}
+
+func (b *grafanaBackend) pathConfigRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getConfig(ctx, req.Storage, connectionName(d))
 	if err != nil {
 		return nil, err
 	}
@@ -118,17 +435,64 @@ func (b *grafanaBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 		return nil, nil
 	}
 
-	return &logical.Response{
+	// The token itself is never returned. Instead, metadata that helps identify the token is returned.
+	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"type":  config.Type,
-			"token": config.Token,
-			"url":   config.URL,
+			"type":              config.Type,
+			"token_fingerprint": tokenFingerprint(config.Token),
+			"url":               config.URL,
+			"rotation_period":   config.RotationPeriod.Seconds(),
+			"rotation_schedule": config.RotationSchedule,
+
+			// The client key is never returned.
+			"ca_cert":              config.CACert,
+			"client_cert":          config.ClientCert,
+			"tls_server_name":      config.TLSServerName,
+			"insecure_skip_verify": config.InsecureSkipVerify,
+			"proxy_url":            config.redactedProxyURL(),
+
+			"request_timeout":     config.requestTimeout().Seconds(),
+			"max_retries":         config.maxRetries(),
+			"requests_per_second": config.RequestsPerSecond,
+			"max_in_flight":       config.MaxInFlight,
 		},
-	}, nil
+	}
+
+	if !config.LastRotated.IsZero() {
+		resp.Data["last_rotated"] = config.LastRotated.Format(time.RFC3339)
+	}
+
+	if nextRotation := config.nextRotation(); !nextRotation.IsZero() {
+		resp.Data["next_rotation"] = nextRotation.Format(time.RFC3339)
+	}
+
+	if config.TokenExpiresAt != nil {
+		resp.Data["token_expires_at"] = config.TokenExpiresAt.Format(time.RFC3339)
+	}
+
+	if config.ServiceAccountID != 0 {
+		resp.Data["service_account_id"] = config.ServiceAccountID
+	}
+
+	if config.AccessPolicyID != "" {
+		resp.Data["access_policy_id"] = config.AccessPolicyID
+		resp.Data["region"] = config.Region
+	}
+
+	if warning := config.expiryWarning(); warning != "" {
+		resp.AddWarning(warning)
+	}
+
+	return resp, nil
 }
 
 func (b *grafanaBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	connection := connectionName(data)
+
+	config, err := getConfig(ctx, req.Storage, connection)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +506,9 @@ func (b *grafanaBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		config = new(grafanaConfig)
 	}
 
+	previousType := config.Type
+	previousToken := config.Token
+
 	if configTypeRaw, ok := data.GetOk("type"); ok {
 		config.Type = configTypeRaw.(string)
 
@@ -154,37 +521,126 @@ func (b *grafanaBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		config.URL = configURL.(string)
 	}
 
+	if caCert, ok := data.GetOk("ca_cert"); ok {
+		config.CACert = caCert.(string)
+	}
+
+	if clientCert, ok := data.GetOk("client_cert"); ok {
+		config.ClientCert = clientCert.(string)
+	}
+
+	if clientKey, ok := data.GetOk("client_key"); ok {
+		config.ClientKey = clientKey.(string)
+	}
+
+	if tlsServerName, ok := data.GetOk("tls_server_name"); ok {
+		config.TLSServerName = tlsServerName.(string)
+	}
+
+	if insecureSkipVerify, ok := data.GetOk("insecure_skip_verify"); ok {
+		config.InsecureSkipVerify = insecureSkipVerify.(bool)
+	}
+
+	if proxyURL, ok := data.GetOk("proxy_url"); ok {
+		config.ProxyURL = proxyURL.(string)
+	}
+
+	if requestTimeout, ok := data.GetOk("request_timeout"); ok {
+		config.RequestTimeout = time.Duration(requestTimeout.(int)) * time.Second
+	}
+
+	if maxRetries, ok := data.GetOk("max_retries"); ok {
+		config.MaxRetries = new(int)
+		*config.MaxRetries = maxRetries.(int)
+	}
+
+	if requestsPerSecond, ok := data.GetOk("requests_per_second"); ok {
+		config.RequestsPerSecond = requestsPerSecond.(float64)
+	}
+
+	if maxInFlight, ok := data.GetOk("max_in_flight"); ok {
+		config.MaxInFlight = maxInFlight.(int)
+	}
+
+	if rotationPeriod, ok := data.GetOk("rotation_period"); ok {
+		config.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+	}
+
+	if rotationSchedule, ok := data.GetOk("rotation_schedule"); ok {
+		config.RotationSchedule = rotationSchedule.(string)
+	}
+
+	if config.Type != previousType || config.Token != previousToken {
+		config.clearTokenOwner()
+		config.LastRotated = time.Now()
+		config.TokenExpiresAt = nil
+	} else if config.LastRotated.IsZero() {
+		// Configurations written before automated rotation was supported do not track when the token was set.
+		config.LastRotated = time.Now()
+	}
+
+	if tokenID, ok := data.GetOk("token_id"); ok {
+		config.TokenID = tokenID.(string)
+	}
+
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
 
-	entry, err := logical.StorageEntryJSON(configStoragePath, config)
-	if err != nil {
-		return nil, err
+	if data.Get("verify_connection").(bool) {
+		c, err := newClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := verifyConnection(ctx, c, config); err != nil {
+			return logical.ErrorResponse("error verifying connection: %s", err), nil
+		}
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if err := putConfig(ctx, req.Storage, connection, config); err != nil {
 		return nil, err
 	}
 
 	// reset the client so the next invocation will pick up the new configuration
-	b.reset()
+	b.reset(connection)
 
 	return nil, nil
 }
 
-func (b *grafanaBackend) pathConfigDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, configStoragePath)
+func (b *grafanaBackend) pathConfigDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	connection := connectionName(d)
+
+	err := req.Storage.Delete(ctx, configStorageKey(connection))
 
 	if err == nil {
-		b.reset()
+		b.reset(connection)
 	}
 
 	return nil, err
 }
 
-func getConfig(ctx context.Context, s logical.Storage) (*grafanaConfig, error) {
-	entry, err := s.Get(ctx, configStoragePath)
+// tokenFingerprint returns the first 16 hex characters of the SHA-256 hash of the token, which allows the configured
+// token to be identified without revealing it.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func putConfig(ctx context.Context, s logical.Storage, connection string, config *grafanaConfig) error {
+	entry, err := logical.StorageEntryJSON(configStorageKey(connection), config)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+func getConfig(ctx context.Context, s logical.Storage, connection string) (*grafanaConfig, error) {
+	entry, err := s.Get(ctx, configStorageKey(connection))
 	if err != nil {
 		return nil, fmt.Errorf("error reading mount configuration: %w", err)
 	}
@@ -201,9 +657,36 @@ func getConfig(ctx context.Context, s logical.Storage) (*grafanaConfig, error) {
 	return config, nil
 }
 
+// listConnections returns the names of all configured connections, including the default connection if it is
+// configured.
+func listConnections(ctx context.Context, s logical.Storage) ([]string, error) {
+	connections, err := s.List(ctx, configStoragePath+"/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing connections: %w", err)
+	}
+
+	entry, err := s.Get(ctx, configStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mount configuration: %w", err)
+	}
+
+	if entry != nil {
+		connections = append([]string{defaultConnection}, connections...)
+	}
+
+	return connections, nil
+}
+
 const (
 	pathConfigHelpSynopsis    = `Configure the Grafana backend.`
 	pathConfigHelpDescription = `
-The Grafana secret backend requires a token to manage tokens that it issues.
+The Grafana secret backend requires a token to manage tokens that it issues. The token can be rotated
+automatically by setting either a rotation period or a cron-style rotation schedule.
+
+The default connection is configured at "config". Additional connections to other Grafana Cloud organizations or
+Grafana instances can be configured at "config/<connection>" and selected by roles using the "connection" field.
 `
+
+	pathConfigListHelpSynopsis    = `List the configured connections.`
+	pathConfigListHelpDescription = `Connections will be listed by name. The connection configured at "config" is listed as "default".`
 )
@@ -2,11 +2,20 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/google/uuid"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -14,6 +23,9 @@ const (
 	configURL = "http://localhost:19090"
 )
 
+// anyValue can be used in the expected data of testConfigRead for values that are only checked for presence.
+var anyValue = struct{}{}
+
 func TestConfig(t *testing.T) {
 	b, reqStorage := getTestBackend(t)
 
@@ -21,87 +33,130 @@ func TestConfig(t *testing.T) {
 
 		t.Run("Create Configuration (Cloud) - empty token", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": "",
+				"type":              GrafanaCloudType,
+				"token":             "",
+				"verify_connection": false,
 			})
 			assert.Error(t, err)
 		})
 
 		t.Run("Create Configuration (Grafana) - empty token", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"token": "",
-				"url":   configURL,
+				"type":              GrafanaType,
+				"token":             "",
+				"url":               configURL,
+				"verify_connection": false,
 			})
 			assert.Error(t, err)
 		})
 
 		t.Run("Create Configuration (Grafana) - empty url", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"token": token,
-				"url":   "",
+				"type":              GrafanaType,
+				"token":             token,
+				"url":               "",
+				"verify_connection": false,
 			})
 			assert.Error(t, err)
 		})
 
 		t.Run("Create Configuration (Grafana) - invalid url", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"token": token,
-				"url":   "/addd",
+				"type":              GrafanaType,
+				"token":             token,
+				"url":               "/addd",
+				"verify_connection": false,
 			})
 			assert.Error(t, err)
 		})
 
 		t.Run("Create Configuration (Cloud) - pass", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": token,
+				"type":              GrafanaCloudType,
+				"token":             token,
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Configuration (Cloud) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": token,
-				"url":   defaultGrafanaCloudURL,
+				"type":                 GrafanaCloudType,
+				"token_fingerprint":    tokenFingerprint(token),
+				"url":                  defaultGrafanaCloudURL,
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Update Configuration (Cloud - set token) - pass", func(t *testing.T) {
 			err := testConfigUpdate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": "abcd",
+				"type":              GrafanaCloudType,
+				"token":             "abcd",
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Updated Configuration (Cloud - set token) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": "abcd",
-				"url":   defaultGrafanaCloudURL,
+				"type":                 GrafanaCloudType,
+				"token_fingerprint":    tokenFingerprint("abcd"),
+				"url":                  defaultGrafanaCloudURL,
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Update Configuration (Cloud - set type) - pass", func(t *testing.T) {
 			err := testConfigUpdate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"url":   configURL,
-				"token": "abcd",
+				"type":              GrafanaType,
+				"url":               configURL,
+				"token":             "abcd",
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Updated Configuration (Cloud - set type) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"url":   configURL,
-				"token": "abcd",
+				"type":                 GrafanaType,
+				"url":                  configURL,
+				"token_fingerprint":    tokenFingerprint("abcd"),
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
@@ -113,54 +168,93 @@ func TestConfig(t *testing.T) {
 
 		t.Run("Create Configuration (Grafana) - pass", func(t *testing.T) {
 			err := testConfigCreate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"token": token,
-				"url":   configURL,
+				"type":              GrafanaType,
+				"token":             token,
+				"url":               configURL,
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Configuration (Grafana) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaType,
-				"token": token,
-				"url":   configURL,
+				"type":                 GrafanaType,
+				"token_fingerprint":    tokenFingerprint(token),
+				"url":                  configURL,
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Update Configuration (Grafana - set token and url) - pass", func(t *testing.T) {
 			err := testConfigUpdate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"url":   "https://test.com:19090",
-				"token": "abcd",
+				"type":              GrafanaCloudType,
+				"url":               "https://test.com:19090",
+				"token":             "abcd",
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Updated Configuration (Grafana - set token and url) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"url":   "https://test.com:19090",
-				"token": "abcd",
+				"type":                 GrafanaCloudType,
+				"url":                  "https://test.com:19090",
+				"token_fingerprint":    tokenFingerprint("abcd"),
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Update Configuration (Grafana - set type) - pass", func(t *testing.T) {
 			err := testConfigUpdate(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": token,
-				"url":   "",
+				"type":              GrafanaCloudType,
+				"token":             token,
+				"url":               "",
+				"verify_connection": false,
 			})
 			assert.NoError(t, err)
 		})
 
 		t.Run("Read Updated Configuration (Grafana - set type) - pass", func(t *testing.T) {
 			err := testConfigRead(b, reqStorage, map[string]interface{}{
-				"type":  GrafanaCloudType,
-				"token": token,
-				"url":   defaultGrafanaCloudURL,
+				"type":                 GrafanaCloudType,
+				"token_fingerprint":    tokenFingerprint(token),
+				"url":                  defaultGrafanaCloudURL,
+				"rotation_period":      float64(0),
+				"rotation_schedule":    "",
+				"ca_cert":              "",
+				"client_cert":          "",
+				"tls_server_name":      "",
+				"insecure_skip_verify": false,
+				"proxy_url":            "",
+				"request_timeout":      client.DefaultTimeout.Seconds(),
+				"max_retries":          defaultMaxRetries,
+				"requests_per_second":  float64(0),
+				"max_in_flight":        0,
+				"last_rotated":         anyValue,
 			})
 			assert.NoError(t, err)
 		})
@@ -172,6 +266,98 @@ func TestConfig(t *testing.T) {
 	})
 }
 
+func TestConfigVerifyConnection(t *testing.T) {
+	t.Run("Grafana - pass", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": grafana.addServiceAccountToken(serviceAccountID),
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Grafana - missing permissions", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Viewer")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": grafana.addServiceAccountToken(serviceAccountID),
+			"url":   grafana.URL,
+		})
+		require.ErrorContains(t, err, "serviceaccounts:create, serviceaccounts:write, serviceaccounts:delete, roles:read")
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("Grafana - invalid token", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": token,
+			"url":   grafana.URL,
+		})
+		require.ErrorContains(t, err, "401")
+		require.ErrorContains(t, err, "the configured token is invalid, expired or revoked")
+	})
+
+	t.Run("Cloud - pass", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		accessPolicyID := grafana.addAccessPolicy("vault", requiredCloudScopes)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaCloudType,
+			"token": grafana.addAccessPolicyToken(accessPolicyID, "root", cloudAccessPolicyRegion, nil),
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.Equal(t, accessPolicyID, config.AccessPolicyID)
+		require.Equal(t, cloudAccessPolicyRegion, config.Region)
+	})
+
+	t.Run("Cloud - missing scopes", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		accessPolicyID := grafana.addAccessPolicy("vault", []string{"accesspolicies:read", "accesspolicies:write", "stacks:read"})
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaCloudType,
+			"token": grafana.addAccessPolicyToken(accessPolicyID, "root", cloudAccessPolicyRegion, nil),
+			"url":   grafana.URL,
+		})
+		require.ErrorContains(t, err, "accesspolicies:delete, stack-service-accounts:write")
+	})
+
+	t.Run("Cloud - unknown token", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaCloudType,
+			"token": token,
+			"url":   grafana.URL,
+		})
+		require.Error(t, err)
+	})
+}
+
 func testConfigCreate(b logical.Backend, s logical.Storage, d map[string]interface{}) error {
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
 		Operation: logical.CreateOperation,
@@ -249,10 +435,364 @@ func testConfigRead(b logical.Backend, s logical.Storage, expected map[string]in
 
 		if !ok {
 			return fmt.Errorf(`expected data["%s"] = %v but was not included in read output"`, k, expectedV)
-		} else if expectedV != actualV {
+		} else if expectedV != anyValue && expectedV != actualV {
 			return fmt.Errorf(`expected data["%s"] = %v, instead got %v"`, k, expectedV, actualV)
 		}
 	}
 
 	return nil
 }
+
+func TestConfigConnections(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/onprem",
+		Data: map[string]interface{}{
+			"type":              GrafanaType,
+			"token":             "abcd",
+			"url":               configURL,
+			"verify_connection": false,
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	t.Run("List connections", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "config/",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{defaultConnection, "onprem"}, resp.Data["keys"])
+	})
+
+	t.Run("Read connections", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/onprem",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, GrafanaType, resp.Data["type"])
+		require.Equal(t, tokenFingerprint("abcd"), resp.Data["token_fingerprint"])
+
+		// The default connection can be read with or without its name.
+		for _, path := range []string{"config", "config/default"} {
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      path,
+				Storage:   s,
+			})
+			require.NoError(t, err)
+			require.Equal(t, GrafanaCloudType, resp.Data["type"])
+		}
+	})
+
+	t.Run("Separate clients", func(t *testing.T) {
+		defaultClient, err := b.getClient(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		onpremClient, err := b.getClient(context.Background(), s, "onprem")
+		require.NoError(t, err)
+
+		require.NotSame(t, defaultClient, onpremClient)
+	})
+
+	t.Run("Delete connection", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "config/onprem",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), s, "onprem")
+		require.NoError(t, err)
+		require.Nil(t, config)
+
+		config, err = getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.NotNil(t, config)
+	})
+}
+
+func TestConfigTransport(t *testing.T) {
+	t.Run("Custom CA", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafanaTLS(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+		rootToken := grafana.addServiceAccountToken(serviceAccountID)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.ErrorContains(t, err, "certificate")
+
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: grafana.Certificate().Raw})
+
+		err = testConfigCreate(b, s, map[string]interface{}{
+			"type":    GrafanaType,
+			"token":   rootToken,
+			"url":     grafana.URL,
+			"ca_cert": string(caCert),
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Insecure skip verify", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafanaTLS(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":                 GrafanaType,
+			"token":                grafana.addServiceAccountToken(serviceAccountID),
+			"url":                  grafana.URL,
+			"insecure_skip_verify": true,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		var proxied atomic.Int64
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied.Add(1)
+			grafana.Config.Handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(proxy.Close)
+
+		proxyURL, err := url.Parse(proxy.URL)
+		require.NoError(t, err)
+		proxyURL.User = url.UserPassword("vault", "secret")
+
+		// The URL cannot be resolved, so the request only succeeds when it is sent through the proxy.
+		err = testConfigCreate(b, s, map[string]interface{}{
+			"type":      GrafanaType,
+			"token":     grafana.addServiceAccountToken(serviceAccountID),
+			"url":       "http://grafana.invalid",
+			"proxy_url": proxyURL.String(),
+		})
+		require.NoError(t, err)
+		require.Positive(t, proxied.Load())
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configStoragePath,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.NotContains(t, resp.Data["proxy_url"], "secret")
+	})
+
+	t.Run("Invalid settings", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		for name, d := range map[string]map[string]interface{}{
+			"invalid CA certificate":     {"ca_cert": "not a certificate"},
+			"client certificate only":    {"client_cert": "not a certificate"},
+			"invalid client certificate": {"client_cert": "not a certificate", "client_key": "not a key"},
+			"invalid proxy URL":          {"proxy_url": "proxy:3128"},
+		} {
+			d["type"] = GrafanaType
+			d["token"] = token
+			d["url"] = configURL
+			d["verify_connection"] = false
+
+			err := testConfigCreate(b, s, d)
+			require.Error(t, err, name)
+		}
+	})
+}
+
+func TestConfigRetries(t *testing.T) {
+	// newFlakyGrafana returns a server that fails the first requests with a 502 before passing requests on to the
+	// fake Grafana.
+	newFlakyGrafana := func(t *testing.T, grafana *fakeGrafana, failures int64) (*httptest.Server, *atomic.Int64) {
+		var requests atomic.Int64
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= failures {
+				http.Error(w, `{"message":"bad gateway"}`, http.StatusBadGateway)
+				return
+			}
+
+			grafana.Config.Handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+
+		return server, &requests
+	}
+
+	t.Run("Retry transient errors", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+		flaky, requests := newFlakyGrafana(t, grafana, 2)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": grafana.addServiceAccountToken(serviceAccountID),
+			"url":   flaky.URL,
+		})
+		require.NoError(t, err)
+
+		// Two failed attempts for the health check, then one request each for the health check and the permissions.
+		require.Equal(t, int64(4), requests.Load())
+	})
+
+	t.Run("Retries disabled", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+		flaky, requests := newFlakyGrafana(t, grafana, 1)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":        GrafanaType,
+			"token":       grafana.addServiceAccountToken(serviceAccountID),
+			"url":         flaky.URL,
+			"max_retries": 0,
+		})
+		require.ErrorContains(t, err, "502")
+		require.Equal(t, int64(1), requests.Load())
+	})
+
+	t.Run("Requests creating objects retried only when not processed", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		var (
+			posts      atomic.Int64
+			postStatus atomic.Int64
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && posts.Add(1) == 1 {
+				http.Error(w, `{"message":"failed"}`, int(postStatus.Load()))
+				return
+			}
+
+			grafana.Config.Handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": grafana.addServiceAccountToken(serviceAccountID),
+			"url":   server.URL,
+		})
+		require.NoError(t, err)
+
+		c, err := b.getClient(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+			posts.Store(0)
+			postStatus.Store(int64(status))
+
+			_, err = c.CreateServiceAccount(context.Background(), client.CreateServiceAccountInput{Name: uuid.NewString(), Role: "Viewer"})
+			require.NoError(t, err, status)
+			require.Equal(t, int64(2), posts.Load(), status)
+		}
+
+		for _, status := range []int{http.StatusBadGateway, http.StatusGatewayTimeout} {
+			posts.Store(0)
+			postStatus.Store(int64(status))
+
+			_, err = c.CreateServiceAccount(context.Background(), client.CreateServiceAccountInput{Name: uuid.NewString(), Role: "Viewer"})
+			require.Error(t, err, status)
+			require.Equal(t, int64(1), posts.Load(), status)
+		}
+	})
+
+	t.Run("Invalid settings", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		for name, d := range map[string]map[string]interface{}{
+			"negative max retries":         {"max_retries": -1},
+			"negative requests per second": {"requests_per_second": -1},
+			"negative max in flight":       {"max_in_flight": -1},
+		} {
+			d["type"] = GrafanaType
+			d["token"] = token
+			d["url"] = configURL
+			d["verify_connection"] = false
+
+			err := testConfigCreate(b, s, d)
+			require.Error(t, err, name)
+		}
+	})
+}
+
+func TestConfigRequestTimeout(t *testing.T) {
+	// The server only responds once the client gives up on the request.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	t.Run("Timeout", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		start := time.Now()
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":            GrafanaType,
+			"token":           token,
+			"url":             slow.URL,
+			"request_timeout": 1,
+		})
+		require.ErrorContains(t, err, context.DeadlineExceeded.Error())
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Request cancelled", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      configStoragePath,
+			Data: map[string]interface{}{
+				"type":  GrafanaType,
+				"token": token,
+				"url":   slow.URL,
+			},
+			Storage: s,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+}
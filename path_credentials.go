@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/Boostport/vault-plugin-secrets-grafana/client"
-	"github.com/google/uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -52,34 +52,179 @@ func (b *grafanaBackend) createUserCreds(ctx context.Context, req *logical.Reque
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
-	config, err := getConfig(ctx, req.Storage)
+	config, err := getConfig(ctx, req.Storage, role.Connection)
 	if err != nil {
 		return nil, fmt.Errorf("error reading config: %w", err)
 	}
 
+	if config == nil {
+		return nil, fmt.Errorf("connection %s is not configured", role.Connection)
+	}
+
 	if err := role.validate(config.Type); err != nil {
 		return logical.ErrorResponse("role configuration not compatible with mount configuration: %w", err.Error()), nil
 	}
 
-	token, err := b.createToken(ctx, req.Storage, config.Type, role)
+	credentialName, err := b.credentialName(req, roleName, config.Type, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var login string
+
+	if role.credentialKind(config.Type) == walKindUserElevation {
+		login, err = b.elevationLogin(req, roleName, role)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	maxTTL := role.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = b.System().MaxLeaseTTL()
+	}
+
+	now := time.Now()
+
+	// The token expires in Grafana shortly after the lease, in case the lease is lost or cannot be revoked.
+	expiresAt := ceilToSecond(now.Add(maxTTL + role.ExpirySkew))
+
+	if role.NoLease {
+		ttl := role.TTL
+		if ttl <= 0 {
+			ttl = b.System().DefaultLeaseTTL()
+		}
+
+		// Without a lease, the expiry in Grafana is the only limit on the lifetime of the token.
+		expiresAt = ceilToSecond(now.Add(ttl))
+	}
+
+	wal := b.newCredentialWAL(req.Storage, role.Connection)
+
+	var token *grafanaToken
+
+	if role.SharedServiceAccount {
+		token, err = b.createSharedServiceAccountToken(ctx, req.Storage, config.Type, roleName, credentialName, expiresAt)
+	} else if role.SharedAccessPolicy {
+		token, err = b.createSharedAccessPolicyToken(ctx, req.Storage, roleName, credentialName, expiresAt)
+	} else if role.credentialKind(config.Type) == walKindUserElevation {
+		token, err = b.createUserElevation(ctx, req.Storage, wal, login, credentialName, role)
+	} else {
+		token, err = b.createToken(ctx, req.Storage, wal, config.Type, credentialName, expiresAt, role)
+	}
+
 	if err != nil {
+		return nil, explainAPIError(err)
+	}
+
+	issued := &issuedCredential{
+		Name:                token.Name,
+		Connection:          role.Connection,
+		Role:                roleName,
+		IsCloud:             token.IsCloud,
+		Stack:               token.Stack,
+		Region:              token.Region,
+		AccessPolicyID:      token.AccessPolicyID,
+		ServiceAccountID:    token.ServiceAccountID,
+		TokenID:             token.TokenID,
+		AccessPolicyTokenID: token.AccessPolicyTokenID,
+		UserID:              token.UserID,
+		Elevation:           token.Elevation,
+		EntityID:            req.EntityID,
+		DisplayName:         req.DisplayName,
+		RequestID:           req.ID,
+		NoLease:             role.NoLease,
+		IssuedAt:            now,
+		ExpiresAt:           now.Add(maxTTL),
+	}
+
+	if role.NoLease {
+		issued.ExpiresAt = expiresAt
+	}
+
+	if token.Elevation != nil {
+		issued.UserID = token.Elevation.UserID
+	}
+
+	if err := putIssuedCredential(ctx, req.Storage, issued); err != nil {
 		return nil, err
 	}
 
+	if err := recordIssued(ctx, req.Storage, issued); err != nil {
+		return nil, err
+	}
+
+	// The objects created for the credentials now belong to the lease, so they must not be rolled back.
+	if err := wal.commit(ctx); err != nil {
+		return nil, err
+	}
+
+	// The objects of credentials without a lease are deleted by the periodic function once the token expired.
+	if role.NoLease {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"token":      token.Token,
+				"expires_at": expiresAt.Format(time.RFC3339),
+			},
+		}, nil
+	}
+
+	data := map[string]interface{}{
+		"token":      token.Token,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	}
+
+	// Grafana users do not expire, so their lease is the only limit on their lifetime.
+	if token.UserID != 0 {
+		data = map[string]interface{}{
+			"login":    token.Name,
+			"password": token.Password,
+		}
+	}
+
+	// The roles of existing Grafana users are raised until the lease is revoked.
+	if token.Elevation != nil {
+		data = map[string]interface{}{
+			"login":      token.Elevation.Login,
+			"role":       role.Role,
+			"rbac_roles": role.RBACRoles,
+		}
+	}
+
 	// The response is divided into two objects (1) internal data and (2) data.
 	// If you want to reference any information in your code, you need to
 	// store it in internal data!
-	resp := b.Secret(grafanaTokenType).Response(map[string]interface{}{
-		"token": token.Token,
-	}, map[string]interface{}{
+	resp := b.Secret(grafanaTokenType).Response(data, map[string]interface{}{
 		"is_cloud":           token.IsCloud,
 		"stack":              token.Stack,
 		"region":             token.Region,
 		"access_policy_id":   token.AccessPolicyID,
 		"service_account_id": token.ServiceAccountID,
 		"vault_role":         roleName,
+		"connection":         role.Connection,
+		"credential_name":    token.Name,
+		"expires_at":         expiresAt.Format(time.RFC3339),
 	})
 
+	// Revoking the credentials of a shared service account or access policy deletes only their token.
+	if token.TokenID != 0 {
+		resp.Secret.InternalData["token_id"] = token.TokenID
+	}
+
+	if token.AccessPolicyTokenID != "" {
+		resp.Secret.InternalData["access_policy_token_id"] = token.AccessPolicyTokenID
+	}
+
+	if token.UserID != 0 {
+		resp.Secret.InternalData["user_id"] = token.UserID
+		delete(resp.Secret.InternalData, "expires_at")
+	}
+
+	if token.Elevation != nil {
+		resp.Secret.InternalData["elevation"] = token.Elevation
+		delete(resp.Secret.InternalData, "expires_at")
+	}
+
 	if role.TTL > 0 {
 		resp.Secret.TTL = role.TTL
 	}
@@ -91,66 +236,71 @@ func (b *grafanaBackend) createUserCreds(ctx context.Context, req *logical.Reque
 	return resp, nil
 }
 
-func (b *grafanaBackend) createToken(ctx context.Context, s logical.Storage, configType string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
-	c, err := b.getClient(ctx, s)
+func (b *grafanaBackend) createToken(ctx context.Context, s logical.Storage, wal *credentialWAL, configType string, credentialName string, expiresAt time.Time, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
+	c, err := b.getClient(ctx, s, roleEntry.Connection)
 	if err != nil {
 		return nil, err
 	}
 
-	credentialName := fmt.Sprintf("vault-%s", uuid.New())
+	var token *grafanaToken
 
-	if configType == GrafanaCloudType {
-		if roleEntry.Type == roleCloudAccessPolicy {
-			return createCloudAccessPolicyToken(c, credentialName, roleEntry)
-		} else if roleEntry.Type == roleGrafanaServiceAccount {
-			return createCloudServiceAccountToken(c, credentialName, roleEntry)
-		}
+	if configType == GrafanaCloudType && roleEntry.Type == roleCloudAccessPolicy {
+		token, err = createCloudAccessPolicyToken(ctx, c, wal, credentialName, expiresAt, roleEntry)
+	} else if configType == GrafanaCloudType && roleEntry.Type == roleGrafanaServiceAccount {
+		token, err = createCloudServiceAccountToken(ctx, c, wal, credentialName, expiresAt, roleEntry)
+	} else if configType == GrafanaType && roleEntry.Type == roleGrafanaUser {
+		token, err = b.createUser(ctx, c, wal, credentialName, roleEntry)
 	} else if configType == GrafanaType {
-		return createServiceAccountToken(c, credentialName, roleEntry)
+		token, err = createServiceAccountToken(ctx, c, wal, credentialName, expiresAt, roleEntry)
+	} else {
+		return nil, errors.New("cannot create token due to inconsistent mount configuration and role configuration")
 	}
 
-	return nil, errors.New("cannot create token due to inconsistent mount configuration and role configuration")
-}
-
-func createCloudAccessPolicyToken(c *client.Grafana, credentialName string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
-
-	cloudAccessPolicyInput := client.CreateCloudAccessPolicyInput{
-		Name:        credentialName,
-		DisplayName: credentialName,
-		Scopes:      roleEntry.Scopes,
+	if err != nil {
+		return nil, err
 	}
 
-	if roleEntry.Realms != "" {
-		realms, err := realmsStringToStruct(roleEntry.Realms)
+	token.Name = credentialName
 
-		if err != nil {
-			return nil, fmt.Errorf("error converting realms string to struct: %w", err)
-		}
+	return token, nil
+}
+
+func createCloudAccessPolicyToken(ctx context.Context, c *client.Grafana, wal *credentialWAL, credentialName string, expiresAt time.Time, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
 
-		cloudAccessPolicyInput.Realms = realms
+	cloudAccessPolicyInput, err := accessPolicyInput(credentialName, roleEntry)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(roleEntry.AllowedSubnets) > 0 {
-		cloudAccessPolicyInput.Conditions.AllowedSubnets = roleEntry.AllowedSubnets
+	walID, err := wal.record(ctx, walKindAccessPolicy, walEntry{Name: credentialName, Region: roleEntry.Region})
+	if err != nil {
+		return nil, err
 	}
 
-	cloudAccessPolicy, err := c.CreateCloudAccessPolicy(roleEntry.Region, cloudAccessPolicyInput)
+	cloudAccessPolicy, err := c.CreateCloudAccessPolicy(ctx, roleEntry.Region, cloudAccessPolicyInput)
+
+	// The rollback of the WAL entry would delete the existing access policy with the same name.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, fmt.Errorf("an access policy named %s already exists: %w", credentialName, err)
+	}
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating cloud access policy: %w", err)
 	}
 
-	token, err := c.CreateCloudAccessPolicyToken(roleEntry.Region, client.CreateCloudAccessPolicyTokenInput{
+	token, err := c.CreateCloudAccessPolicyToken(ctx, roleEntry.Region, client.CreateCloudAccessPolicyTokenInput{
 		AccessPolicyID: cloudAccessPolicy.ID,
 		Name:           credentialName,
 		DisplayName:    credentialName,
+		ExpiresAt:      &expiresAt,
 	})
 
 	if err != nil {
-		err := c.DeleteCloudAccessPolicy(roleEntry.Region, cloudAccessPolicy.ID)
+		deleteErr := c.DeleteCloudAccessPolicy(ctx, roleEntry.Region, cloudAccessPolicy.ID)
 
-		if err != nil {
-			return nil, fmt.Errorf("error deleting cloud access policy after error creating token: %w", err)
+		if deleteErr != nil {
+			return nil, fmt.Errorf("error deleting cloud access policy after error creating token: %w", deleteErr)
 		}
 
 		return nil, fmt.Errorf("error creating cloud access policy token: %w", err)
@@ -164,74 +314,50 @@ func createCloudAccessPolicyToken(c *client.Grafana, credentialName string, role
 	}, nil
 }
 
-func createCloudServiceAccountToken(c *client.Grafana, credentialName string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
-	role := "None"
-	if roleEntry.Role != "" {
-		role = roleEntry.Role
+func createCloudServiceAccountToken(ctx context.Context, c *client.Grafana, wal *credentialWAL, credentialName string, expiresAt time.Time, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
+	walID, err := wal.record(ctx, walKindServiceAccount, walEntry{Name: credentialName, Stack: roleEntry.Stack})
+	if err != nil {
+		return nil, err
 	}
 
-	serviceAccount, err := c.CreateGrafanaServiceAccountFromCloud(roleEntry.Stack, client.CreateServiceAccountInput{
+	serviceAccount, err := c.CreateGrafanaServiceAccountFromCloud(ctx, roleEntry.Stack, client.CreateServiceAccountInput{
 		Name: credentialName,
-		Role: role,
+		Role: roleEntry.basicRole(),
 	})
 
+	// The rollback of the WAL entry would delete the existing service account with the same name.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, fmt.Errorf("a service account named %s already exists: %w", credentialName, err)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("error creating service account: %w", err)
 	}
 
 	if len(roleEntry.RBACRoles) > 0 {
-		instanceClient, cleanup, err := c.CreateTemporaryStackGrafanaClient(roleEntry.Stack, "vault-temp-service-account-", 5*time.Minute)
-
-		if err != nil {
-			err := c.DeleteGrafanaServiceAccountFromCloud(roleEntry.Stack, serviceAccount.ID)
-
-			if err != nil {
-				return nil, fmt.Errorf("error deleting service account after error creating temporary client: %w", err)
-			}
-
-			return nil, fmt.Errorf("error creating temporary client: %w", err)
-		}
-
-		defer cleanup()
-
-		roleUIDs, err := customRBACRoleNamesToIDs(instanceClient, roleEntry.RBACRoles)
-
-		if err != nil {
-			err := c.DeleteGrafanaServiceAccountFromCloud(roleEntry.Stack, serviceAccount.ID)
-
-			if err != nil {
-				return nil, fmt.Errorf("error deleting service account after error converting role names to IDs: %w", err)
-			}
-			return nil, fmt.Errorf("error converting role names to IDs: %w", err)
-		}
-
-		err = instanceClient.SetServiceAccountRoleAssignments(client.ServiceAccountRoleAssignmentsInput{
-			ServiceAccountID: serviceAccount.ID,
-			RoleUIDs:         roleUIDs,
-		})
-
-		if err != nil {
-			err := c.DeleteGrafanaServiceAccountFromCloud(roleEntry.Stack, serviceAccount.ID)
-
-			if err != nil {
-				return nil, fmt.Errorf("error deleting service account after error setting role assignments: %w", err)
+		if err := setRBACRoles(ctx, c, wal, roleEntry.Stack, serviceAccount.ID, roleEntry.RBACRoles); err != nil {
+			deleteErr := c.DeleteGrafanaServiceAccountFromCloud(ctx, roleEntry.Stack, serviceAccount.ID)
 
+			if deleteErr != nil {
+				return nil, fmt.Errorf("error deleting service account after error setting rbac roles: %w", deleteErr)
 			}
 
-			return nil, fmt.Errorf("error setting service account role assignments: %w", err)
+			return nil, fmt.Errorf("error setting rbac roles: %w", err)
 		}
 	}
 
-	token, err := c.CreateGrafanaServiceAccountTokenFromCloud(roleEntry.Stack, client.CreateServiceAccountTokenInput{
+	token, err := c.CreateGrafanaServiceAccountTokenFromCloud(ctx, roleEntry.Stack, client.CreateServiceAccountTokenInput{
 		Name:             credentialName,
 		ServiceAccountID: serviceAccount.ID,
+		SecondsToLive:    secondsToLive(expiresAt),
 	})
 
 	if err != nil {
-		err := c.DeleteGrafanaServiceAccountFromCloud(roleEntry.Stack, serviceAccount.ID)
+		deleteErr := c.DeleteGrafanaServiceAccountFromCloud(ctx, roleEntry.Stack, serviceAccount.ID)
 
-		if err != nil {
-			return nil, fmt.Errorf("error deleting service account after error creating token: %w", err)
+		if deleteErr != nil {
+			return nil, fmt.Errorf("error deleting service account after error creating token: %w", deleteErr)
 		}
 
 		return nil, fmt.Errorf("error creating service account token: %w", err)
@@ -245,61 +371,52 @@ func createCloudServiceAccountToken(c *client.Grafana, credentialName string, ro
 	}, nil
 }
 
-func createServiceAccountToken(c *client.Grafana, credentialName string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
-	role := "None"
-	if roleEntry.Role != "" {
-		role = roleEntry.Role
+func createServiceAccountToken(ctx context.Context, c *client.Grafana, wal *credentialWAL, credentialName string, expiresAt time.Time, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
+	walID, err := wal.record(ctx, walKindServiceAccount, walEntry{Name: credentialName})
+	if err != nil {
+		return nil, err
 	}
 
-	serviceAccount, err := c.CreateServiceAccount(client.CreateServiceAccountInput{
+	serviceAccount, err := c.CreateServiceAccount(ctx, client.CreateServiceAccountInput{
 		Name: credentialName,
-		Role: role,
+		Role: roleEntry.basicRole(),
 	})
 
+	// The rollback of the WAL entry would delete the existing service account with the same name.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, fmt.Errorf("a service account named %s already exists: %w", credentialName, err)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("error creating service account: %w", err)
 	}
 
 	if len(roleEntry.RBACRoles) > 0 {
-		roleUIDs, err := customRBACRoleNamesToIDs(c, roleEntry.RBACRoles)
-
-		if err != nil {
-			err := deleteServiceAccount(c, serviceAccount.ID)
-
-			if err != nil {
-				return nil, fmt.Errorf("error deleting service account after error converting role names to IDs: %w", err)
-			}
-			return nil, fmt.Errorf("error converting role names to IDs: %w", err)
-		}
-
-		err = c.SetServiceAccountRoleAssignments(client.ServiceAccountRoleAssignmentsInput{
-			ServiceAccountID: serviceAccount.ID,
-			RoleUIDs:         roleUIDs,
-		})
-
-		if err != nil {
-			err := deleteServiceAccount(c, serviceAccount.ID)
-
-			if err != nil {
-				return nil, fmt.Errorf("error deleting service account after error setting role assignments: %w", err)
+		if err := setRBACRoles(ctx, c, wal, "", serviceAccount.ID, roleEntry.RBACRoles); err != nil {
+			deleteErr := deleteServiceAccount(ctx, c, serviceAccount.ID)
 
+			if deleteErr != nil {
+				return nil, fmt.Errorf("error deleting service account after error setting rbac roles: %w", deleteErr)
 			}
 
-			return nil, fmt.Errorf("error setting service account role assignments: %w", err)
+			return nil, fmt.Errorf("error setting rbac roles: %w", err)
 		}
 	}
 
-	token, err := c.CreateServiceAccountToken(client.CreateServiceAccountTokenInput{
+	token, err := c.CreateServiceAccountToken(ctx, client.CreateServiceAccountTokenInput{
 		Name:             credentialName,
 		ServiceAccountID: serviceAccount.ID,
+		SecondsToLive:    secondsToLive(expiresAt),
 	})
 
 	if err != nil {
-		err := deleteServiceAccount(c, serviceAccount.ID)
+		deleteErr := deleteServiceAccount(ctx, c, serviceAccount.ID)
 
-		if err != nil {
-			return nil, fmt.Errorf("error deleting service account after error creating token: %w", err)
+		if deleteErr != nil {
+			return nil, fmt.Errorf("error deleting service account after error creating token: %w", deleteErr)
 		}
+
 		return nil, fmt.Errorf("error creating service account token: %w", err)
 	}
 
@@ -310,8 +427,19 @@ func createServiceAccountToken(c *client.Grafana, credentialName string, roleEnt
 	}, nil
 }
 
-func deleteServiceAccount(c *client.Grafana, serviceAccountID int64) error {
-	err := c.DeleteServiceAccount(serviceAccountID)
+// secondsToLive returns the lifetime of a service account token expiring at the given time, rounded up to whole
+// seconds as Grafana treats a lifetime of 0 as no expiry.
+func secondsToLive(expiresAt time.Time) int64 {
+	return max(int64(math.Ceil(time.Until(expiresAt).Seconds())), 1)
+}
+
+// ceilToSecond rounds a time up to whole seconds, so that a token never expires in Grafana before its lease.
+func ceilToSecond(t time.Time) time.Time {
+	return t.Add(time.Second - time.Nanosecond).Truncate(time.Second)
+}
+
+func deleteServiceAccount(ctx context.Context, c *client.Grafana, serviceAccountID int64) error {
+	err := c.DeleteServiceAccount(ctx, serviceAccountID)
 
 	if err != nil {
 		return fmt.Errorf("error deleting service account: %w", err)
@@ -320,10 +448,62 @@ func deleteServiceAccount(c *client.Grafana, serviceAccountID int64) error {
 	return nil
 }
 
-func customRBACRoleNamesToIDs(c *client.Grafana, roleNames []string) ([]string, error) {
+// setRBACRoles replaces the RBAC roles assigned to a service account or user. The RBAC API of Grafana Cloud stacks cannot be
+// reached with the cloud token, so the roles of service accounts in a stack are assigned through a temporary client.
+func setRBACRoles(ctx context.Context, c *client.Grafana, wal *credentialWAL, stack string, serviceAccountID int64, rbacRoles []string) error {
+	instanceClient := c
+
+	if stack != "" {
+		tempName := tempServiceAccountName(wal.mountMarker, time.Now())
+
+		tempWALID, err := wal.record(ctx, walKindServiceAccount, walEntry{Name: tempName, Stack: stack})
+		if err != nil {
+			return err
+		}
+
+		tempClient, cleanup, err := c.CreateTemporaryStackGrafanaClient(ctx, stack, tempName, 5*time.Minute)
+		if err != nil {
+			return fmt.Errorf("error creating temporary client: %w", err)
+		}
+
+		// The temporary service account is deleted even if the request was cancelled in the meantime. If that
+		// fails, its WAL entry is released so that it outlives the commit of the credentials, and the rollback
+		// deletes the service account later.
+		defer func() {
+			ctx := context.WithoutCancel(ctx)
+
+			if err := cleanup(ctx); err != nil {
+				wal.release(tempWALID)
+				return
+			}
+
+			_ = wal.forget(ctx, tempWALID)
+		}()
+
+		instanceClient = tempClient
+	}
+
+	roleUIDs, err := customRBACRoleNamesToIDs(ctx, instanceClient, rbacRoles)
+	if err != nil {
+		return fmt.Errorf("error converting role names to IDs: %w", err)
+	}
+
+	err = instanceClient.SetServiceAccountRoleAssignments(ctx, client.ServiceAccountRoleAssignmentsInput{
+		ServiceAccountID: serviceAccountID,
+		RoleUIDs:         roleUIDs,
+	})
+
+	if err != nil {
+		return fmt.Errorf("error setting service account role assignments: %w", err)
+	}
+
+	return nil
+}
+
+func customRBACRoleNamesToIDs(ctx context.Context, c *client.Grafana, roleNames []string) ([]string, error) {
 	var roleIDs []string
 
-	allRoles, err := c.GetAllRoles()
+	allRoles, err := c.GetAllRoles(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("error getting all roles: %w", err)
@@ -347,6 +527,32 @@ func customRBACRoleNamesToIDs(c *client.Grafana, roleNames []string) ([]string,
 	return roleIDs, nil
 }
 
+// accessPolicyInput returns the input for creating an access policy with the scopes, realms and allowed subnets of a
+// role.
+func accessPolicyInput(name string, roleEntry *grafanaRoleEntry) (client.CreateCloudAccessPolicyInput, error) {
+	input := client.CreateCloudAccessPolicyInput{
+		Name:        name,
+		DisplayName: name,
+		Scopes:      roleEntry.Scopes,
+	}
+
+	if roleEntry.Realms != "" {
+		realms, err := realmsStringToStruct(roleEntry.Realms)
+
+		if err != nil {
+			return input, fmt.Errorf("error converting realms string to struct: %w", err)
+		}
+
+		input.Realms = realms
+	}
+
+	if len(roleEntry.AllowedSubnets) > 0 {
+		input.Conditions = &client.CloudAccessPolicyConditions{AllowedSubnets: roleEntry.AllowedSubnets}
+	}
+
+	return input, nil
+}
+
 func realmsStringToStruct(realms string) ([]client.CloudAccessPolicyRealm, error) {
 	var result []client.CloudAccessPolicyRealm
 
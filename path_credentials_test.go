@@ -2,13 +2,17 @@ package vault_plugin_secrets_grafana
 
 import (
 	"context"
+	"encoding/json"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
 	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
 )
 
 func newCloudAcceptanceTestEnv() (*testCloudEnv, error) {
@@ -84,3 +88,1646 @@ func TestGrafanaInstanceToken(t *testing.T) {
 	t.Run("cleanup instance creds", instanceTestEnv.CleanupCreds)
 	t.Run("cleanup cloud creds", acceptanceTestEnv.CleanupCreds)
 }
+
+func TestNamedConnectionCredentials(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/onprem",
+		Data: map[string]interface{}{
+			"type":  GrafanaType,
+			"token": grafana.addServiceAccountToken(adminID),
+			"url":   grafana.URL,
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	t.Run("Create role - fail on unknown connection", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+			"connection": "missing",
+			"role":       "Viewer",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create role", func(t *testing.T) {
+		// The role is validated against the named connection, which does not require a type.
+		resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+			"connection": "onprem",
+			"role":       "Viewer",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testTokenRoleRead(t, b, s, "viewer")
+		require.NoError(t, err)
+		require.Equal(t, "onprem", resp.Data["connection"])
+	})
+
+	t.Run("Read and revoke credentials", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/viewer",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.NotEmpty(t, resp.Data["token"])
+		require.Equal(t, "onprem", resp.Secret.InternalData["connection"])
+
+		serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    resp.Secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Empty(t, grafana.tokens(serviceAccountID))
+	})
+}
+
+func TestRevokeDeletedCredentials(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+		"role": "Viewer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	revoke := func(t *testing.T, secret *logical.Secret) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	t.Run("service account deleted in Grafana", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/viewer",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+
+		grafana.lock.Lock()
+		delete(grafana.serviceAccounts, serviceAccountID)
+		grafana.lock.Unlock()
+
+		revoke(t, resp.Secret)
+	})
+
+	t.Run("internal data read back from storage", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/viewer",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+
+		for _, id := range []interface{}{json.Number(strconv.FormatInt(serviceAccountID, 10)), float64(serviceAccountID)} {
+			resp.Secret.InternalData["service_account_id"] = id
+			revoke(t, resp.Secret)
+		}
+
+		require.NotContains(t, grafana.serviceAccounts, serviceAccountID)
+	})
+
+	t.Run("access policy deleted in Grafana Cloud", func(t *testing.T) {
+		revoke(t, &logical.Secret{
+			InternalData: map[string]interface{}{
+				"secret_type":      grafanaTokenType,
+				"is_cloud":         true,
+				"stack":            "",
+				"region":           "us",
+				"access_policy_id": "does-not-exist",
+			},
+		})
+	})
+}
+
+func TestCredentialsWALRollback(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	rollback := func(t *testing.T, immediate bool) {
+		data := map[string]interface{}{}
+		if immediate {
+			data["immediate"] = true
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RollbackOperation,
+			Data:      data,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "%v", resp)
+	}
+
+	t.Run("issued credentials are not rolled back", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+			"role": "Viewer",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/viewer",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, wals)
+
+		rollback(t, true)
+		require.Contains(t, grafana.serviceAccounts, resp.Secret.InternalData["service_account_id"].(int64))
+	})
+
+	t.Run("orphaned service account is deleted", func(t *testing.T) {
+		_, err := framework.PutWAL(context.Background(), s, walKindServiceAccount, &walEntry{
+			Connection: defaultConnection,
+			Name:       "vault-orphaned",
+		})
+		require.NoError(t, err)
+
+		orphanID := grafana.addServiceAccount("vault-orphaned", "Viewer")
+
+		// Entries are only rolled back once they are old enough.
+		rollback(t, false)
+		require.Contains(t, grafana.serviceAccounts, orphanID)
+
+		rollback(t, true)
+		require.NotContains(t, grafana.serviceAccounts, orphanID)
+		require.Contains(t, grafana.serviceAccounts, adminID)
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, wals)
+	})
+
+	t.Run("orphaned access policy is deleted", func(t *testing.T) {
+		_, err := framework.PutWAL(context.Background(), s, walKindAccessPolicy, &walEntry{
+			Connection: defaultConnection,
+			Name:       "vault-orphaned",
+			Region:     "us",
+		})
+		require.NoError(t, err)
+
+		policyID := grafana.addAccessPolicy("vault-orphaned", []string{"metrics:read"})
+
+		rollback(t, true)
+		require.NotContains(t, grafana.accessPolicies, policyID)
+	})
+
+	t.Run("orphaned user is deleted", func(t *testing.T) {
+		_, err := framework.PutWAL(context.Background(), s, walKindUser, &walEntry{
+			Connection: defaultConnection,
+			Name:       "vault-orphaned",
+		})
+		require.NoError(t, err)
+
+		grafana.lock.Lock()
+		grafana.nextID++
+		userID := grafana.nextID
+		grafana.users[userID] = &fakeUser{Login: "vault-orphaned", Role: "Viewer"}
+		grafana.lock.Unlock()
+
+		rollback(t, true)
+
+		_, ok := grafana.user(userID)
+		require.False(t, ok)
+	})
+
+	t.Run("existing user with the same login is not rolled back", func(t *testing.T) {
+		c, err := b.getClient(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		userID := grafana.addUser("vault-existing", "Viewer")
+
+		wal := b.newCredentialWAL(s, defaultConnection)
+		_, err = b.createUser(context.Background(), c, wal, "vault-existing", &grafanaRoleEntry{Role: "Viewer"})
+		require.ErrorContains(t, err, "already exists")
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, wals)
+
+		_, ok := grafana.user(userID)
+		require.True(t, ok)
+	})
+
+	t.Run("existing objects with the same name are not rolled back", func(t *testing.T) {
+		c, err := b.getClient(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		expiresAt := time.Now().Add(time.Hour)
+		serviceAccountID := grafana.addServiceAccount("ci-existing", "Viewer")
+		policyID := grafana.addAccessPolicy("ci-existing", []string{"metrics:read"})
+
+		wal := b.newCredentialWAL(s, defaultConnection)
+
+		_, err = createServiceAccountToken(context.Background(), c, wal, "ci-existing", expiresAt, &grafanaRoleEntry{Role: "Viewer"})
+		require.ErrorContains(t, err, "already exists")
+
+		_, err = createCloudServiceAccountToken(context.Background(), c, wal, "ci-existing", expiresAt, &grafanaRoleEntry{Stack: "stack", Role: "Viewer"})
+		require.ErrorContains(t, err, "already exists")
+
+		_, err = createCloudAccessPolicyToken(context.Background(), c, wal, "ci-existing", expiresAt, &grafanaRoleEntry{
+			Region: "us",
+			Scopes: []string{"metrics:read"},
+			Realms: `[{"type":"stack","identifier":"1"}]`,
+		})
+		require.ErrorContains(t, err, "already exists")
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, wals)
+
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+		require.True(t, grafana.accessPolicyExists(policyID))
+	})
+
+	t.Run("created user is rolled back by its id", func(t *testing.T) {
+		userID := grafana.addUser("vault-renamed", "Viewer")
+
+		_, err := framework.PutWAL(context.Background(), s, walKindUser, &walEntry{
+			Connection: defaultConnection,
+			Name:       "vault-created",
+			UserID:     userID,
+		})
+		require.NoError(t, err)
+
+		rollback(t, true)
+
+		_, ok := grafana.user(userID)
+		require.False(t, ok)
+	})
+
+	t.Run("raised roles of user are restored", func(t *testing.T) {
+		userID := grafana.addUser("alice", "Admin")
+
+		_, err := framework.PutWAL(context.Background(), s, walKindUserElevation, &walEntry{
+			Connection: defaultConnection,
+			Name:       "alice",
+			Elevation:  &userElevation{UserID: userID, Login: "alice", PreviousRole: "Viewer", RoleChanged: true},
+		})
+		require.NoError(t, err)
+
+		rollback(t, true)
+
+		user, ok := grafana.user(userID)
+		require.True(t, ok)
+		require.Equal(t, "Viewer", user.Role)
+	})
+
+	t.Run("temporary service account that cannot be deleted is rolled back", func(t *testing.T) {
+		c, err := b.getClient(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		grafana.addRBACRole("custom:reader", false)
+		serviceAccountID := grafana.addServiceAccount("vault-rbac", "None")
+
+		grafana.lock.Lock()
+		grafana.failDeletes = true
+		grafana.lock.Unlock()
+
+		wal := b.newCredentialWAL(s, defaultConnection)
+		require.NoError(t, setRBACRoles(context.Background(), c, wal, "stack", serviceAccountID, []string{"custom:reader"}))
+		require.NoError(t, wal.commit(context.Background()))
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Len(t, wals, 1)
+
+		grafana.lock.Lock()
+		grafana.failDeletes = false
+		grafana.lock.Unlock()
+
+		rollback(t, true)
+
+		grafana.lock.Lock()
+		defer grafana.lock.Unlock()
+
+		for _, serviceAccount := range grafana.serviceAccounts {
+			require.False(t, tempServiceAccountNameRegex.MatchString(serviceAccount.Name))
+		}
+	})
+
+	t.Run("object that was never created", func(t *testing.T) {
+		_, err := framework.PutWAL(context.Background(), s, walKindServiceAccount, &walEntry{
+			Connection: defaultConnection,
+			Name:       "vault-never-created",
+		})
+		require.NoError(t, err)
+
+		rollback(t, true)
+
+		wals, err := framework.ListWAL(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, wals)
+	})
+}
+
+func TestCredentialsRBACRolesRejected(t *testing.T) {
+	for name, cloud := range map[string]bool{"grafana": false, "cloud": true} {
+		t.Run(name, func(t *testing.T) {
+			b, s := getTestBackend(t)
+			grafana := newFakeGrafana(t)
+			grafana.addRBACRole("custom:reader", false)
+
+			role := map[string]interface{}{
+				"role":       "Viewer",
+				"rbac_roles": "custom:reader",
+			}
+
+			if cloud {
+				err := testConfigCreate(b, s, map[string]interface{}{
+					"type":              GrafanaCloudType,
+					"token":             token,
+					"url":               grafana.URL,
+					"verify_connection": false,
+				})
+				require.NoError(t, err)
+
+				role["type"] = roleGrafanaServiceAccount
+				role["stack"] = "mystack"
+			} else {
+				adminID := grafana.addServiceAccount("vault", "Admin")
+
+				err := testConfigCreate(b, s, map[string]interface{}{
+					"type":  GrafanaType,
+					"token": grafana.addServiceAccountToken(adminID),
+					"url":   grafana.URL,
+				})
+				require.NoError(t, err)
+			}
+
+			resp, err := testTokenRoleCreate(t, b, s, "rbac", role)
+			require.NoError(t, err)
+			require.Nil(t, resp)
+
+			grafana.lock.Lock()
+			grafana.failRoleAssignments = true
+			serviceAccounts := len(grafana.serviceAccounts)
+			grafana.lock.Unlock()
+
+			_, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "creds/rbac",
+				Storage:   s,
+			})
+			require.ErrorContains(t, err, "error setting rbac roles")
+			require.ErrorContains(t, err, "permission denied")
+
+			grafana.lock.Lock()
+			defer grafana.lock.Unlock()
+
+			require.Len(t, grafana.serviceAccounts, serviceAccounts)
+		})
+	}
+}
+
+func TestCredentialsTokenRejected(t *testing.T) {
+	for name, role := range map[string]map[string]interface{}{
+		"grafana": {"role": "Viewer"},
+		"stack":   {"type": roleGrafanaServiceAccount, "stack": "mystack", "role": "Viewer"},
+		"policy": {
+			"type":   roleCloudAccessPolicy,
+			"region": "us",
+			"scopes": []string{"metrics:read"},
+			"realms": `[{"type":"stack","identifier":"1"}]`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, s := getTestBackend(t)
+			grafana := newFakeGrafana(t)
+
+			if name == "grafana" {
+				adminID := grafana.addServiceAccount("vault", "Admin")
+
+				err := testConfigCreate(b, s, map[string]interface{}{
+					"type":  GrafanaType,
+					"token": grafana.addServiceAccountToken(adminID),
+					"url":   grafana.URL,
+				})
+				require.NoError(t, err)
+			} else {
+				err := testConfigCreate(b, s, map[string]interface{}{
+					"type":              GrafanaCloudType,
+					"token":             token,
+					"url":               grafana.URL,
+					"verify_connection": false,
+				})
+				require.NoError(t, err)
+			}
+
+			resp, err := testTokenRoleCreate(t, b, s, "role", role)
+			require.NoError(t, err)
+			require.Nil(t, resp)
+
+			grafana.lock.Lock()
+			grafana.failTokens = true
+			serviceAccounts, accessPolicies := len(grafana.serviceAccounts), len(grafana.accessPolicies)
+			grafana.lock.Unlock()
+
+			_, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "creds/role",
+				Storage:   s,
+			})
+			require.ErrorContains(t, err, "token quota exceeded")
+
+			grafana.lock.Lock()
+			defer grafana.lock.Unlock()
+
+			require.Len(t, grafana.serviceAccounts, serviceAccounts)
+			require.Len(t, grafana.accessPolicies, accessPolicies)
+		})
+	}
+}
+
+func TestCredentialsNameTemplate(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	b.System().(*logical.StaticSystemView).EntityVal = &logical.Entity{
+		ID:       "entity-1",
+		Name:     "alice",
+		Metadata: map[string]string{"team": "sre"},
+	}
+
+	t.Run("invalid templates", func(t *testing.T) {
+		templates := map[string]string{
+			"Invalid syntax":      "vault-{{.RoleName",
+			"Not unique":          "vault-{{.RoleName}}",
+			"Short random":        "vault-{{random 4}}",
+			"Time based":          "vault-{{unix_time}}",
+			"Unused uuid":         "vault-{{if uuid}}{{.RoleName}}{{end}}",
+			"Invalid characters":  "vault {{random 8}}",
+			"Too long":            "{{random 200}}",
+			"Missing entity data": "vault-{{.EntityMetadata.team}}-{{random 8}}",
+		}
+		for d, v := range templates {
+			t.Run(d, func(t *testing.T) {
+				resp, err := testTokenRoleCreate(t, b, s, "templated", map[string]interface{}{
+					"role":          "Viewer",
+					"name_template": v,
+				})
+				require.NoError(t, err)
+				require.True(t, resp.IsError())
+			})
+		}
+	})
+
+	resp, err := testTokenRoleCreate(t, b, s, "templated", map[string]interface{}{
+		"role":          "Viewer",
+		"name_template": `{{.RoleName}}-{{.DisplayName | lowercase}}-{{index .EntityMetadata "team"}}-{{random 8}}`,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	t.Run("templated name", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "creds/templated",
+			Storage:     s,
+			EntityID:    "entity-1",
+			DisplayName: "oidc-Alice",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		name := resp.Secret.InternalData["credential_name"].(string)
+		require.Regexp(t, `^templated-oidc-alice-sre-[a-zA-Z0-9]{8}-`+b.mountMarker+`$`, name)
+
+		serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+		require.Equal(t, name, grafana.serviceAccounts[serviceAccountID].Name)
+	})
+
+	t.Run("invalid generated name", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "creds/templated",
+			Storage:     s,
+			EntityID:    "entity-1",
+			DisplayName: "alice smith",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.ErrorContains(t, resp.Error(), "generated an invalid name")
+	})
+}
+
+func TestCloudCredentialsNameTemplate(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	role := map[string]interface{}{
+		"type":          roleCloudAccessPolicy,
+		"region":        "us",
+		"scopes":        []string{"metrics:read"},
+		"realms":        `[{"type":"stack","identifier":"1"}]`,
+		"name_template": "{{.RoleName}}-{{random 8}}",
+	}
+
+	// Access policy names must be lowercase.
+	resp, err := testTokenRoleCreate(t, b, s, "policy", role)
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	role["name_template"] = "{{.RoleName}}-{{random 8 | lowercase}}"
+
+	resp, err = testTokenRoleCreate(t, b, s, "policy", role)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/policy",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	name := resp.Secret.InternalData["credential_name"].(string)
+	require.Regexp(t, `^policy-[a-z0-9]{8}-`+b.mountMarker+`$`, name)
+
+	policy := grafana.accessPolicies[resp.Secret.InternalData["access_policy_id"].(string)].Policy
+	require.Equal(t, name, policy.Name)
+	require.Equal(t, name, policy.DisplayName)
+}
+
+func TestCredentialsExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+		"role":        "Viewer",
+		"ttl":         "30m",
+		"max_ttl":     "1h",
+		"expiry_skew": "10m",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	requestedAt := time.Now()
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/viewer",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	secret := resp.Secret
+
+	expiresAt, err := time.Parse(time.RFC3339, resp.Data["expires_at"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(70*time.Minute), expiresAt, time.Minute)
+
+	// The expiry is rounded up to whole seconds, so the token does not expire before the lease and the skew.
+	require.False(t, expiresAt.Before(requestedAt.Add(70*time.Minute)))
+
+	serviceAccountID := secret.InternalData["service_account_id"].(int64)
+	require.Len(t, grafana.tokens(serviceAccountID), 1)
+
+	for tokenID := range grafana.tokens(serviceAccountID) {
+		require.InDelta(t, (70 * time.Minute).Seconds(), grafana.tokenSecondsToLive[tokenID], 60)
+	}
+
+	renew := func(t *testing.T, secret *logical.Secret) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("renew", func(t *testing.T) {
+		resp := renew(t, secret)
+		require.False(t, resp.IsError())
+		require.Equal(t, 30*time.Minute, resp.Secret.TTL)
+	})
+
+	t.Run("renew past the expiry in Grafana", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+			"ttl":     "5h",
+			"max_ttl": "10h",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp = renew(t, secret)
+		require.False(t, resp.IsError())
+		require.InDelta(t, time.Until(expiresAt).Seconds(), resp.Secret.TTL.Seconds(), 1)
+	})
+
+	t.Run("renew after the expiry in Grafana", func(t *testing.T) {
+		expired := *secret
+		expired.InternalData = map[string]interface{}{}
+		for k, v := range secret.InternalData {
+			expired.InternalData[k] = v
+		}
+		expired.InternalData["expires_at"] = time.Now().Add(-time.Minute).Format(time.RFC3339)
+
+		resp := renew(t, &expired)
+		require.True(t, resp.IsError())
+	})
+}
+
+func TestCloudCredentialsExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "policy", map[string]interface{}{
+		"type":    roleCloudAccessPolicy,
+		"region":  "us",
+		"scopes":  []string{"metrics:read"},
+		"realms":  `[{"type":"stack","identifier":"1"}]`,
+		"max_ttl": "1h",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = testTokenRoleCreate(t, b, s, "stack", map[string]interface{}{
+		"type":    roleGrafanaServiceAccount,
+		"stack":   "mystack",
+		"role":    "Viewer",
+		"max_ttl": "1h",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	t.Run("access policy", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/policy",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		expiresAt, err := time.Parse(time.RFC3339, resp.Data["expires_at"].(string))
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour+defaultExpirySkew), expiresAt, time.Minute)
+
+		tokens := grafana.accessPolicyTokens(resp.Secret.InternalData["access_policy_id"].(string))
+		require.Len(t, tokens, 1)
+
+		for _, token := range tokens {
+			require.NotNil(t, token.ExpiresAt)
+			require.True(t, expiresAt.Equal(*token.ExpiresAt))
+		}
+	})
+
+	t.Run("service account", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/stack",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+
+		for tokenID := range grafana.tokens(serviceAccountID) {
+			require.InDelta(t, (time.Hour + defaultExpirySkew).Seconds(), grafana.tokenSecondsToLive[tokenID], 60)
+		}
+	})
+}
+
+func TestLeaselessCredentials(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "ci", map[string]interface{}{
+		"role":     "Editor",
+		"ttl":      "10m",
+		"no_lease": true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/ci",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	require.Nil(t, resp.Secret)
+	require.NotEmpty(t, resp.Data["token"])
+
+	expiresAt, err := time.Parse(time.RFC3339, resp.Data["expires_at"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(10*time.Minute), expiresAt, time.Minute)
+
+	issued, err := listIssuedCredentials(context.Background(), s)
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	require.True(t, issued[0].NoLease)
+	require.True(t, expiresAt.Equal(issued[0].ExpiresAt))
+
+	serviceAccountID := issued[0].ServiceAccountID
+	require.Len(t, grafana.tokens(serviceAccountID), 1)
+
+	for tokenID := range grafana.tokens(serviceAccountID) {
+		require.InDelta(t, (10 * time.Minute).Seconds(), grafana.tokenSecondsToLive[tokenID], 60)
+	}
+
+	t.Run("valid credentials are kept", func(t *testing.T) {
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+	})
+
+	t.Run("expired credentials are deleted", func(t *testing.T) {
+		issued[0].ExpiresAt = time.Now().Add(-time.Minute)
+		require.NoError(t, putIssuedCredential(context.Background(), s, issued[0]))
+
+		b.lastLeaselessSweep = time.Time{}
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.False(t, grafana.serviceAccountExists(serviceAccountID))
+
+		remaining, err := listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, remaining)
+
+		entry, err := getHistoryEntry(context.Background(), s, issued[0].Name)
+		require.NoError(t, err)
+		require.Equal(t, revokeOutcomeExpired, entry.RevokeOutcome)
+		require.False(t, entry.RevokedAt.IsZero())
+	})
+}
+
+func TestSharedServiceAccount(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "shared", map[string]interface{}{
+		"role":                   "Viewer",
+		"shared_service_account": true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	issue := func(t *testing.T) *logical.Secret {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/shared",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		return resp.Secret
+	}
+
+	revoke := func(t *testing.T, secret *logical.Secret) {
+		t.Helper()
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+	}
+
+	serviceAccountRole := func(id int64) string {
+		grafana.lock.Lock()
+		defer grafana.lock.Unlock()
+
+		return grafana.serviceAccounts[id].Role
+	}
+
+	first := issue(t)
+	second := issue(t)
+
+	serviceAccountID := first.InternalData["service_account_id"].(int64)
+	require.Equal(t, serviceAccountID, second.InternalData["service_account_id"])
+	require.Len(t, grafana.tokens(serviceAccountID), 2)
+	require.Equal(t, "Viewer", serviceAccountRole(serviceAccountID))
+
+	resp, err = testTokenRoleRead(t, b, s, "shared")
+	require.NoError(t, err)
+	require.Equal(t, serviceAccountID, resp.Data["shared_service_account_id"])
+	require.Equal(t, sharedObjectNamePrefix+"shared", resp.Data["shared_service_account_name"])
+
+	t.Run("issued credentials are tokens", func(t *testing.T) {
+		issued, err := getIssuedCredential(context.Background(), s, first.InternalData["credential_name"].(string))
+		require.NoError(t, err)
+		require.Equal(t, kindServiceAccountToken, issued.kind())
+		require.Equal(t, first.InternalData["token_id"], issued.TokenID)
+	})
+
+	t.Run("revocation deletes only the token", func(t *testing.T) {
+		revoke(t, first)
+
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+		require.Contains(t, grafana.tokens(serviceAccountID), second.InternalData["token_id"])
+	})
+
+	t.Run("role changes are applied", func(t *testing.T) {
+		resp, err := testTokenRoleUpdate(t, b, s, "shared", map[string]interface{}{
+			"role": "Editor",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Equal(t, "Editor", serviceAccountRole(serviceAccountID))
+	})
+
+	t.Run("deleted service account is replaced", func(t *testing.T) {
+		grafana.lock.Lock()
+		delete(grafana.serviceAccounts, serviceAccountID)
+		grafana.lock.Unlock()
+
+		revoke(t, second)
+
+		secret := issue(t)
+		replacementID := secret.InternalData["service_account_id"].(int64)
+		require.NotEqual(t, serviceAccountID, replacementID)
+		require.Equal(t, "Editor", serviceAccountRole(replacementID))
+
+		serviceAccountID = replacementID
+	})
+
+	t.Run("revoke all deletes the service account", func(t *testing.T) {
+		resp, err := testRevokeAll(b, s, "revoke-all", nil)
+		require.NoError(t, err)
+		require.Empty(t, resp.Warnings)
+		require.Len(t, resp.Data["revoked"], 2)
+		require.False(t, grafana.serviceAccountExists(serviceAccountID))
+
+		issued, err := listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, issued)
+
+		serviceAccountID = issue(t).InternalData["service_account_id"].(int64)
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+	})
+
+	t.Run("role deletion deletes the service account", func(t *testing.T) {
+		resp, err := testTokenRoleDelete(t, b, s, "shared")
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.False(t, grafana.serviceAccountExists(serviceAccountID))
+	})
+}
+
+func TestSharedServiceAccountDisabled(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "policy", map[string]interface{}{
+		"type":                   roleCloudAccessPolicy,
+		"region":                 "us",
+		"scopes":                 []string{"metrics:read"},
+		"realms":                 `[{"type":"stack","identifier":"1"}]`,
+		"shared_service_account": true,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	resp, err = testTokenRoleCreate(t, b, s, "stack", map[string]interface{}{
+		"type":                   roleGrafanaServiceAccount,
+		"stack":                  "mystack",
+		"role":                   "Viewer",
+		"shared_service_account": true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/stack",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	require.Equal(t, "mystack", resp.Secret.InternalData["stack"])
+
+	serviceAccountID := resp.Secret.InternalData["service_account_id"].(int64)
+	require.True(t, grafana.serviceAccountExists(serviceAccountID))
+
+	// Turning the option off deletes the shared service account, and credentials get their own service account again.
+	resp, err = testTokenRoleUpdate(t, b, s, "stack", map[string]interface{}{
+		"shared_service_account": false,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.False(t, grafana.serviceAccountExists(serviceAccountID))
+
+	resp, err = testTokenRoleRead(t, b, s, "stack")
+	require.NoError(t, err)
+	require.NotContains(t, resp.Data, "shared_service_account_id")
+}
+
+func TestSharedAccessPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "service_account", map[string]interface{}{
+		"type":                 roleGrafanaServiceAccount,
+		"stack":                "mystack",
+		"shared_access_policy": true,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	resp, err = testTokenRoleCreate(t, b, s, "metrics_reader", map[string]interface{}{
+		"type":                 roleCloudAccessPolicy,
+		"region":               "us",
+		"scopes":               []string{"metrics:read"},
+		"realms":               `[{"type":"stack","identifier":"1"}]`,
+		"allowed_subnets":      []string{"10.0.0.0/8"},
+		"shared_access_policy": true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	issue := func(t *testing.T) *logical.Secret {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/metrics_reader",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		return resp.Secret
+	}
+
+	policyScopes := func(id string) []string {
+		grafana.lock.Lock()
+		defer grafana.lock.Unlock()
+
+		return grafana.accessPolicies[id].Policy.Scopes
+	}
+
+	first := issue(t)
+	second := issue(t)
+
+	accessPolicyID := first.InternalData["access_policy_id"].(string)
+	require.Equal(t, accessPolicyID, second.InternalData["access_policy_id"])
+	require.Len(t, grafana.accessPolicyTokens(accessPolicyID), 2)
+
+	resp, err = testTokenRoleRead(t, b, s, "metrics_reader")
+	require.NoError(t, err)
+	require.Equal(t, accessPolicyID, resp.Data["shared_access_policy_id"])
+	require.Equal(t, "vault-role-metrics-reader", resp.Data["shared_access_policy_name"])
+
+	t.Run("revocation deletes only the token", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    first,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		require.True(t, grafana.accessPolicyExists(accessPolicyID))
+		require.Len(t, grafana.accessPolicyTokens(accessPolicyID), 1)
+		require.Contains(t, grafana.accessPolicyTokens(accessPolicyID), second.InternalData["access_policy_token_id"])
+
+		issued, err := listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Len(t, issued, 1)
+		require.Equal(t, kindAccessPolicyToken, issued[0].kind())
+	})
+
+	t.Run("role changes update the policy in place", func(t *testing.T) {
+		resp, err := testTokenRoleUpdate(t, b, s, "metrics_reader", map[string]interface{}{
+			"scopes": []string{"metrics:read", "logs:read"},
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Equal(t, []string{"metrics:read", "logs:read"}, policyScopes(accessPolicyID))
+		require.Len(t, grafana.accessPolicyTokens(accessPolicyID), 1)
+	})
+
+	t.Run("deleted policy is replaced", func(t *testing.T) {
+		grafana.lock.Lock()
+		delete(grafana.accessPolicies, accessPolicyID)
+		grafana.lock.Unlock()
+
+		replacementID := issue(t).InternalData["access_policy_id"].(string)
+		require.NotEqual(t, accessPolicyID, replacementID)
+		require.Equal(t, []string{"metrics:read", "logs:read"}, policyScopes(replacementID))
+
+		accessPolicyID = replacementID
+	})
+
+	t.Run("role deletion deletes the policy", func(t *testing.T) {
+		resp, err := testTokenRoleDelete(t, b, s, "metrics_reader")
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.False(t, grafana.accessPolicyExists(accessPolicyID))
+	})
+}
+
+func TestRevocationModeDisable(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "shared", map[string]interface{}{
+		"shared_service_account": true,
+		"revocation_mode":        revocationModeDisable,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	resp, err = testTokenRoleCreate(t, b, s, "disabled", map[string]interface{}{
+		"role":               "Viewer",
+		"revocation_mode":    revocationModeDisable,
+		"disabled_retention": "1h",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = testTokenRoleRead(t, b, s, "disabled")
+	require.NoError(t, err)
+	require.Equal(t, revocationModeDisable, resp.Data["revocation_mode"])
+	require.Equal(t, float64(3600), resp.Data["disabled_retention"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/disabled",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	secret := resp.Secret
+	credentialName := secret.InternalData["credential_name"].(string)
+	serviceAccountID := secret.InternalData["service_account_id"].(int64)
+	require.Len(t, grafana.tokens(serviceAccountID), 1)
+
+	t.Run("revocation disables the service account", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+		require.Empty(t, grafana.tokens(serviceAccountID))
+
+		grafana.lock.Lock()
+		sa := *grafana.serviceAccounts[serviceAccountID]
+		grafana.lock.Unlock()
+
+		require.True(t, sa.IsDisabled)
+		require.Equal(t, disabledServiceAccountNamePrefix+credentialName, sa.Name)
+
+		entry, err := getHistoryEntry(context.Background(), s, credentialName)
+		require.NoError(t, err)
+		require.Equal(t, revokeOutcomeDisabled, entry.RevokeOutcome)
+
+		disabled, err := listDisabledServiceAccounts(context.Background(), s)
+		require.NoError(t, err)
+		require.Len(t, disabled, 1)
+		require.WithinDuration(t, time.Now().Add(time.Hour), disabled[0].DeleteAt, time.Minute)
+	})
+
+	t.Run("tidy keeps the disabled service account", func(t *testing.T) {
+		grafana.age(serviceAccountID, 7*24*time.Hour)
+
+		_, _, err := b.tidy(context.Background(), s, 0, false)
+		require.NoError(t, err)
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+	})
+
+	t.Run("disabled service account is kept during retention", func(t *testing.T) {
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.True(t, grafana.serviceAccountExists(serviceAccountID))
+	})
+
+	t.Run("disabled service account is deleted after retention", func(t *testing.T) {
+		disabled, err := listDisabledServiceAccounts(context.Background(), s)
+		require.NoError(t, err)
+
+		disabled[0].DeleteAt = time.Now().Add(-time.Minute)
+		require.NoError(t, putDisabledServiceAccount(context.Background(), s, disabled[0]))
+
+		b.lastDisabledPurge = time.Time{}
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.False(t, grafana.serviceAccountExists(serviceAccountID))
+
+		remaining, err := listDisabledServiceAccounts(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, remaining)
+	})
+}
+
+func TestGrafanaUser(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	t.Run("invalid roles", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "leaseless", map[string]interface{}{
+			"type":     roleGrafanaUser,
+			"no_lease": true,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = testTokenRoleCreate(t, b, s, "service_account", map[string]interface{}{
+			"password_policy": "policy",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	resp, err := testTokenRoleCreate(t, b, s, "breakglass", map[string]interface{}{
+		"type": roleGrafanaUser,
+		"role": "Admin",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	issue := func(t *testing.T) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/breakglass",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		return resp
+	}
+
+	resp = issue(t)
+	require.NotContains(t, resp.Data, "token")
+	require.NotContains(t, resp.Secret.InternalData, "expires_at")
+
+	login := resp.Data["login"].(string)
+	password := resp.Data["password"].(string)
+	userID := resp.Secret.InternalData["user_id"].(int64)
+
+	require.Len(t, password, defaultPasswordLength)
+	require.True(t, hasAllPasswordCharsets(password))
+
+	user, ok := grafana.user(userID)
+	require.True(t, ok)
+	require.Equal(t, fakeUser{Login: login, Password: password, OrgID: 1, Role: "Admin"}, user)
+
+	t.Run("lookup by user id", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "issued/lookup",
+			Data:      map[string]interface{}{"user_id": userID},
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		credentials := resp.Data["credentials"].([]map[string]interface{})
+		require.Len(t, credentials, 1)
+		require.Equal(t, walKindUser, credentials[0]["type"])
+		require.Equal(t, login, credentials[0]["name"])
+	})
+
+	t.Run("revocation deletes the user", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    resp.Secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		_, ok := grafana.user(userID)
+		require.False(t, ok)
+
+		entry, err := getHistoryEntry(context.Background(), s, login)
+		require.NoError(t, err)
+		require.Equal(t, revokeOutcomeRevoked, entry.RevokeOutcome)
+		require.Equal(t, userID, entry.UserID)
+	})
+
+	t.Run("password policy", func(t *testing.T) {
+		systemView := b.System().(*logical.StaticSystemView)
+		systemView.SetPasswordPolicy("breakglass", func() (string, error) { return "Policy-Password-1", nil })
+
+		resp, err := testTokenRoleUpdate(t, b, s, "breakglass", map[string]interface{}{
+			"password_policy": "breakglass",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp = issue(t)
+		require.Equal(t, "Policy-Password-1", resp.Data["password"])
+
+		user, ok := grafana.user(resp.Secret.InternalData["user_id"].(int64))
+		require.True(t, ok)
+		require.Equal(t, "Policy-Password-1", user.Password)
+	})
+
+	t.Run("user is deleted if its roles cannot be set", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "missing_rbac_role", map[string]interface{}{
+			"type":       roleGrafanaUser,
+			"role":       "Viewer",
+			"rbac_roles": "custom:missing",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		grafana.lock.Lock()
+		users := len(grafana.users)
+		grafana.lock.Unlock()
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/missing_rbac_role",
+			Storage:   s,
+		})
+		require.ErrorContains(t, err, "rbac role does not exist")
+
+		grafana.lock.Lock()
+		defer grafana.lock.Unlock()
+
+		require.Len(t, grafana.users, users)
+	})
+
+	t.Run("revoke all deletes users", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "revoke-all",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		grafana.lock.Lock()
+		defer grafana.lock.Unlock()
+
+		require.Empty(t, grafana.users)
+	})
+}
+
+func TestUserElevation(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	aliceID := grafana.addUser("alice", "Viewer")
+	readerUID := grafana.addRBACRole("custom:reader", false)
+	writerUID := grafana.addRBACRole("custom:writer", false)
+	globalUID := grafana.addRBACRole("custom:global", true)
+	grafana.assignRBACRoles(aliceID, readerUID, globalUID)
+
+	b.System().(*logical.StaticSystemView).EntityVal = &logical.Entity{
+		ID:       "entity-1",
+		Name:     "alice",
+		Metadata: map[string]string{"grafana_login": "alice"},
+		Aliases:  []*logical.Alias{{MountAccessor: "auth_oidc_1234", MountType: "oidc", Name: "alice"}},
+	}
+
+	t.Run("invalid roles", func(t *testing.T) {
+		roles := map[string]map[string]interface{}{
+			"Missing login template": {"role": "Admin"},
+			"Missing roles":          {"login_template": "alice"},
+			"Invalid role":           {"login_template": "alice", "role": "Owner"},
+			"Invalid template":       {"login_template": "{{.EntityName", "role": "Admin"},
+			"Name template":          {"login_template": "alice", "role": "Admin", "name_template": "vault-{{random 8}}"},
+			"No lease":               {"login_template": "alice", "role": "Admin", "no_lease": true},
+		}
+		for d, data := range roles {
+			t.Run(d, func(t *testing.T) {
+				data["type"] = roleGrafanaUserElevation
+
+				resp, err := testTokenRoleCreate(t, b, s, "invalid", data)
+				require.NoError(t, err)
+				require.True(t, resp.IsError())
+			})
+		}
+
+		resp, err := testTokenRoleCreate(t, b, s, "invalid", map[string]interface{}{
+			"role":           "Viewer",
+			"login_template": "alice",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	resp, err := testTokenRoleCreate(t, b, s, "oncall", map[string]interface{}{
+		"type":           roleGrafanaUserElevation,
+		"login_template": `{{index .EntityAliases "auth_oidc_1234"}}`,
+		"role":           "Admin",
+		"rbac_roles":     "custom:reader,custom:writer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	issue := func(t *testing.T, role string, entityID string) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/" + role,
+			Storage:   s,
+			EntityID:  entityID,
+		})
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp = issue(t, "oncall", "entity-1")
+	require.False(t, resp.IsError())
+	require.Equal(t, "alice", resp.Data["login"])
+	require.Equal(t, "Admin", resp.Data["role"])
+	require.NotContains(t, resp.Data, "token")
+	require.NotContains(t, resp.Secret.InternalData, "user_id")
+	require.NotContains(t, resp.Secret.InternalData, "expires_at")
+
+	user, ok := grafana.user(aliceID)
+	require.True(t, ok)
+	require.Equal(t, "Admin", user.Role)
+	require.ElementsMatch(t, []string{readerUID, writerUID, globalUID}, user.RoleUIDs)
+
+	t.Run("user can only be elevated once", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/oncall",
+			Storage:   s,
+			EntityID:  "entity-1",
+		})
+		require.ErrorContains(t, err, "already raised")
+	})
+
+	t.Run("lookup by user id", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "issued/lookup",
+			Data:      map[string]interface{}{"user_id": aliceID},
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		credentials := resp.Data["credentials"].([]map[string]interface{})
+		require.Len(t, credentials, 1)
+		require.Equal(t, walKindUserElevation, credentials[0]["type"])
+		require.Equal(t, "alice", credentials[0]["login"])
+		require.Equal(t, "Viewer", credentials[0]["previous_role"])
+	})
+
+	t.Run("revocation restores the previous roles", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    resp.Secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		user, ok := grafana.user(aliceID)
+		require.True(t, ok)
+		require.Equal(t, "Viewer", user.Role)
+		require.ElementsMatch(t, []string{readerUID, globalUID}, user.RoleUIDs)
+
+		entry, err := getHistoryEntry(context.Background(), s, resp.Secret.InternalData["credential_name"].(string))
+		require.NoError(t, err)
+		require.Equal(t, revokeOutcomeRevoked, entry.RevokeOutcome)
+		require.Equal(t, aliceID, entry.UserID)
+	})
+
+	t.Run("role is never lowered", func(t *testing.T) {
+		bobID := grafana.addUser("bob", "Admin")
+
+		resp, err := testTokenRoleCreate(t, b, s, "bob_editor", map[string]interface{}{
+			"type":           roleGrafanaUserElevation,
+			"login_template": "bob",
+			"role":           "Editor",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp = issue(t, "bob_editor", "")
+		require.False(t, resp.IsError())
+
+		user, _ := grafana.user(bobID)
+		require.Equal(t, "Admin", user.Role)
+
+		// Roles changed in Grafana during the lease are kept if the lease did not change them.
+		grafana.lock.Lock()
+		grafana.users[bobID].Role = "Viewer"
+		grafana.lock.Unlock()
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    resp.Secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+
+		user, _ = grafana.user(bobID)
+		require.Equal(t, "Viewer", user.Role)
+	})
+
+	t.Run("login from entity metadata", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "metadata", map[string]interface{}{
+			"type":           roleGrafanaUserElevation,
+			"login_template": "{{.EntityMetadata.grafana_login}}",
+			"role":           "Editor",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp = issue(t, "metadata", "")
+		require.True(t, resp.IsError())
+		require.ErrorContains(t, resp.Error(), "did not generate a login")
+
+		resp = issue(t, "metadata", "entity-1")
+		require.False(t, resp.IsError())
+		require.Equal(t, "alice", resp.Data["login"])
+
+		user, _ := grafana.user(aliceID)
+		require.Equal(t, "Editor", user.Role)
+	})
+
+	t.Run("revoke all restores roles without deleting users", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "revoke-all",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		user, ok := grafana.user(aliceID)
+		require.True(t, ok)
+		require.Equal(t, "Viewer", user.Role)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "unknown", map[string]interface{}{
+			"type":           roleGrafanaUserElevation,
+			"login_template": "carol",
+			"role":           "Editor",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/unknown",
+			Storage:   s,
+		})
+		require.ErrorContains(t, err, "does not exist")
+	})
+}
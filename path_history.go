@@ -0,0 +1,445 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	historyStoragePrefix        = "history/credentials/"
+	historyConfigStoragePath    = "history/config"
+	defaultHistoryRetention     = 90 * 24 * time.Hour
+	historyPruneInterval        = time.Hour
+	revokeOutcomeRevoked        = "revoked"
+	revokeOutcomeAlreadyDeleted = "already_deleted"
+	revokeOutcomeDisabled       = "disabled"
+	revokeOutcomeFailed         = "failed"
+	revokeOutcomeExpired        = "expired"
+)
+
+// historyEntry records the credentials of a lease for auditing. Unlike the record of issued credentials, it is kept
+// after the lease is revoked until the retention period has passed.
+type historyEntry struct {
+	issuedCredential
+	RevokedAt     time.Time `json:"revoked_at"`               // Zero until the credentials were revoked
+	RevokeOutcome string    `json:"revoke_outcome,omitempty"` // Outcome of the last revocation attempt
+	RevokeError   string    `json:"revoke_error,omitempty"`   // Error of the last revocation attempt, if it failed
+}
+
+type historyConfig struct {
+	Retention time.Duration `json:"retention"`
+}
+
+// endedAt returns when the credentials stopped being valid: when they were revoked, or otherwise when their lease
+// expires at the latest.
+func (h *historyEntry) endedAt() time.Time {
+	if !h.RevokedAt.IsZero() {
+		return h.RevokedAt
+	}
+
+	return h.ExpiresAt
+}
+
+func (h *historyEntry) toResponseData() map[string]interface{} {
+	data := h.issuedCredential.toResponseData()
+
+	data["revoked_at"] = nil
+	if !h.RevokedAt.IsZero() {
+		data["revoked_at"] = h.RevokedAt.Format(time.RFC3339)
+	}
+
+	data["revoke_outcome"] = h.RevokeOutcome
+	data["revoke_error"] = h.RevokeError
+
+	return data
+}
+
+func pathHistory(b *grafanaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "history$",
+			Fields: map[string]*framework.FieldSchema{
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Only return credentials issued by this role",
+					Required:    false,
+				},
+				"entity_id": {
+					Type:        framework.TypeString,
+					Description: "Only return credentials requested by this Vault entity",
+					Required:    false,
+				},
+				"start": {
+					Type:        framework.TypeTime,
+					Description: "Only return credentials that were valid at or after this time, in RFC 3339 format or as seconds since the epoch",
+					Required:    false,
+				},
+				"end": {
+					Type:        framework.TypeTime,
+					Description: "Only return credentials that were issued at or before this time, in RFC 3339 format or as seconds since the epoch",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathHistoryRead,
+				},
+			},
+			HelpSynopsis:    pathHistoryHelpSynopsis,
+			HelpDescription: pathHistoryHelpDescription,
+		},
+		{
+			Pattern: "history/config$",
+			Fields: map[string]*framework.FieldSchema{
+				"retention": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the history of credentials is kept after they were revoked or expired. Set to 0 to keep it forever. Defaults to 90 days",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathHistoryConfigRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathHistoryConfigWrite,
+				},
+			},
+			HelpSynopsis:    pathHistoryConfigHelpSynopsis,
+			HelpDescription: pathHistoryConfigHelpDescription,
+		},
+	}
+}
+
+func (b *grafanaBackend) pathHistoryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role := d.Get("role").(string)
+	entityID := d.Get("entity_id").(string)
+
+	var start, end time.Time
+
+	if val, ok := d.GetOk("start"); ok {
+		start = val.(time.Time)
+	}
+
+	if val, ok := d.GetOk("end"); ok {
+		end = val.(time.Time)
+	}
+
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return logical.ErrorResponse("end must not be before start"), nil
+	}
+
+	entries, err := listHistoryEntries(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b *historyEntry) int { return a.IssuedAt.Compare(b.IssuedAt) })
+
+	credentials := []map[string]interface{}{}
+
+	for _, entry := range entries {
+		if role != "" && entry.Role != role {
+			continue
+		}
+
+		if entityID != "" && entry.EntityID != entityID {
+			continue
+		}
+
+		if !start.IsZero() && entry.endedAt().Before(start) {
+			continue
+		}
+
+		if !end.IsZero() && entry.IssuedAt.After(end) {
+			continue
+		}
+
+		credentials = append(credentials, entry.toResponseData())
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"credentials": credentials,
+		},
+	}, nil
+}
+
+// recordIssued adds the credentials of a new lease to the history.
+func recordIssued(ctx context.Context, s logical.Storage, issued *issuedCredential) error {
+	return putHistoryEntry(ctx, s, &historyEntry{issuedCredential: *issued})
+}
+
+// recordRevocation updates the history of the credentials of a lease with the outcome of revoking them. Leases
+// issued before the history was introduced are added to it from the record of issued credentials or, if there is
+// none, from the internal data of the lease.
+func (b *grafanaBackend) recordRevocation(ctx context.Context, req *logical.Request, outcome string, revokeErr error) error {
+	name, ok := req.Secret.InternalData["credential_name"].(string)
+	if !ok || name == "" {
+		return nil
+	}
+
+	entry, err := getHistoryEntry(ctx, req.Storage, name)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		entry, err = historyEntryFromLease(ctx, req, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.setRevokeOutcome(outcome, revokeErr)
+
+	return putHistoryEntry(ctx, req.Storage, entry)
+}
+
+// recordExpiry updates the history of lease-less credentials with the outcome of deleting their objects after they
+// expired.
+func recordExpiry(ctx context.Context, s logical.Storage, issued *issuedCredential, deleteErr error) error {
+	entry, err := getHistoryEntry(ctx, s, issued.Name)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		entry = &historyEntry{issuedCredential: *issued}
+	}
+
+	entry.setRevokeOutcome(revokeOutcomeExpired, deleteErr)
+
+	return putHistoryEntry(ctx, s, entry)
+}
+
+// setRevokeOutcome records the outcome of an attempt to delete the objects of the credentials. A failed attempt
+// does not set the revocation time, as the credentials may still be valid.
+func (h *historyEntry) setRevokeOutcome(outcome string, err error) {
+	if err != nil {
+		h.RevokeOutcome = revokeOutcomeFailed
+		h.RevokeError = err.Error()
+		return
+	}
+
+	h.RevokedAt = time.Now()
+	h.RevokeOutcome = outcome
+	h.RevokeError = ""
+}
+
+func historyEntryFromLease(ctx context.Context, req *logical.Request, name string) (*historyEntry, error) {
+	issued, err := getIssuedCredential(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if issued != nil {
+		return &historyEntry{issuedCredential: *issued}, nil
+	}
+
+	data := req.Secret.InternalData
+	entry := &historyEntry{
+		issuedCredential: issuedCredential{
+			Name:      name,
+			IssuedAt:  req.Secret.IssueTime,
+			ExpiresAt: req.Secret.ExpirationTime(),
+		},
+	}
+
+	entry.Connection, _ = data["connection"].(string)
+	entry.Role, _ = data["vault_role"].(string)
+	entry.IsCloud, _ = data["is_cloud"].(bool)
+	entry.Stack, _ = data["stack"].(string)
+	entry.Region, _ = data["region"].(string)
+	entry.AccessPolicyID, _ = data["access_policy_id"].(string)
+	entry.ServiceAccountID, _ = internalDataInt64(data, "service_account_id")
+
+	if _, ok := data["token_id"]; ok {
+		entry.TokenID, _ = internalDataInt64(data, "token_id")
+	}
+
+	entry.AccessPolicyTokenID, _ = data["access_policy_token_id"].(string)
+
+	if _, ok := data["user_id"]; ok {
+		entry.UserID, _ = internalDataInt64(data, "user_id")
+	}
+
+	if val, ok := data["elevation"]; ok {
+		entry.Elevation, _ = decodeUserElevation(val)
+
+		if entry.Elevation != nil {
+			entry.UserID = entry.Elevation.UserID
+		}
+	}
+
+	return entry, nil
+}
+
+// pruneHistory is run by the periodic function and deletes the history of credentials that were revoked or expired
+// longer ago than the retention period.
+func (b *grafanaBackend) pruneHistory(ctx context.Context, s logical.Storage) error {
+	if time.Since(b.lastHistoryPrune) < historyPruneInterval {
+		return nil
+	}
+
+	config, err := getHistoryConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config.Retention > 0 {
+		entries, err := listHistoryEntries(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		cutoff := time.Now().Add(-config.Retention)
+
+		for _, entry := range entries {
+			if entry.endedAt().Before(cutoff) {
+				if err := s.Delete(ctx, historyStoragePrefix+entry.Name); err != nil {
+					return fmt.Errorf("error deleting credential history: %w", err)
+				}
+			}
+		}
+	}
+
+	b.lastHistoryPrune = time.Now()
+
+	return nil
+}
+
+func (b *grafanaBackend) pathHistoryConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := getHistoryConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"retention": config.Retention.Seconds(),
+		},
+	}, nil
+}
+
+func (b *grafanaBackend) pathHistoryConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getHistoryConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := d.GetOk("retention"); ok {
+		config.Retention = time.Duration(val.(int)) * time.Second
+	}
+
+	if config.Retention < 0 {
+		return logical.ErrorResponse("retention must not be negative"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(historyConfigStoragePath, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("error storing history configuration: %w", err)
+	}
+
+	return nil, nil
+}
+
+// getHistoryConfig returns the history configuration, with the default retention if it was never written.
+func getHistoryConfig(ctx context.Context, s logical.Storage) (*historyConfig, error) {
+	config := &historyConfig{
+		Retention: defaultHistoryRetention,
+	}
+
+	entry, err := s.Get(ctx, historyConfigStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading history configuration: %w", err)
+	}
+
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, fmt.Errorf("error decoding history configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+func putHistoryEntry(ctx context.Context, s logical.Storage, h *historyEntry) error {
+	entry, err := logical.StorageEntryJSON(historyStoragePrefix+h.Name, h)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return fmt.Errorf("error storing credential history: %w", err)
+	}
+
+	return nil
+}
+
+func getHistoryEntry(ctx context.Context, s logical.Storage, name string) (*historyEntry, error) {
+	entry, err := s.Get(ctx, historyStoragePrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential history: %w", err)
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var h historyEntry
+	if err := entry.DecodeJSON(&h); err != nil {
+		return nil, fmt.Errorf("error decoding credential history: %w", err)
+	}
+
+	return &h, nil
+}
+
+func listHistoryEntries(ctx context.Context, s logical.Storage) ([]*historyEntry, error) {
+	names, err := s.List(ctx, historyStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing credential history: %w", err)
+	}
+
+	entries := make([]*historyEntry, 0, len(names))
+
+	for _, name := range names {
+		h, err := getHistoryEntry(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if h != nil {
+			entries = append(entries, h)
+		}
+	}
+
+	return entries, nil
+}
+
+const (
+	pathHistoryHelpSynopsis    = `Read the history of credentials issued and revoked by the backend.`
+	pathHistoryHelpDescription = `
+This path returns the credentials issued by the backend, including the ones whose leases were revoked or expired
+within the retention period, with the role, the Vault entity that requested them, the IDs of the objects in Grafana,
+when they were issued and revoked, and the outcome of revoking them. The history can be filtered by role, entity and
+time range.
+`
+
+	pathHistoryConfigHelpSynopsis    = `Configure how long the history of credentials is kept.`
+	pathHistoryConfigHelpDescription = `
+This path configures how long the history of credentials is kept after they were revoked or their lease expired.
+Older history is deleted periodically.
+`
+)
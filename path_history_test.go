@@ -0,0 +1,187 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	for _, role := range []string{"viewer", "editor"} {
+		resp, err := testTokenRoleCreate(t, b, s, role, map[string]interface{}{
+			"role": "Viewer",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	issue := func(t *testing.T, role, entityID string) *logical.Secret {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/" + role,
+			Storage:   s,
+			EntityID:  entityID,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		return resp.Secret
+	}
+
+	revoke := func(secret *logical.Secret) error {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		return err
+	}
+
+	viewer := issue(t, "viewer", "entity-1")
+	editor := issue(t, "editor", "entity-2")
+	failing := issue(t, "editor", "entity-2")
+
+	require.NoError(t, revoke(viewer))
+
+	grafana.lock.Lock()
+	delete(grafana.serviceAccounts, editor.InternalData["service_account_id"].(int64))
+	grafana.lock.Unlock()
+
+	require.NoError(t, revoke(editor))
+
+	failingCopy := *failing
+	failingCopy.InternalData = map[string]interface{}{}
+	for k, v := range failing.InternalData {
+		failingCopy.InternalData[k] = v
+	}
+	failingCopy.InternalData["connection"] = "missing"
+
+	require.Error(t, revoke(&failingCopy))
+
+	t.Run("outcomes", func(t *testing.T) {
+		credentials := testHistoryRead(t, b, s, nil)
+		require.Len(t, credentials, 3)
+
+		byName := map[string]map[string]interface{}{}
+		for _, credential := range credentials {
+			byName[credential["name"].(string)] = credential
+		}
+
+		revoked := byName[viewer.InternalData["credential_name"].(string)]
+		require.Equal(t, revokeOutcomeRevoked, revoked["revoke_outcome"])
+		require.NotNil(t, revoked["revoked_at"])
+		require.Equal(t, "entity-1", revoked["entity_id"])
+		require.Equal(t, "viewer", revoked["role"])
+
+		alreadyDeleted := byName[editor.InternalData["credential_name"].(string)]
+		require.Equal(t, revokeOutcomeAlreadyDeleted, alreadyDeleted["revoke_outcome"])
+		require.NotNil(t, alreadyDeleted["revoked_at"])
+
+		failed := byName[failing.InternalData["credential_name"].(string)]
+		require.Equal(t, revokeOutcomeFailed, failed["revoke_outcome"])
+		require.NotEmpty(t, failed["revoke_error"])
+		require.Nil(t, failed["revoked_at"])
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		require.Len(t, testHistoryRead(t, b, s, map[string]interface{}{"role": "editor"}), 2)
+		require.Len(t, testHistoryRead(t, b, s, map[string]interface{}{"entity_id": "entity-1"}), 1)
+		require.Len(t, testHistoryRead(t, b, s, map[string]interface{}{"role": "viewer", "entity_id": "entity-2"}), 0)
+		require.Len(t, testHistoryRead(t, b, s, map[string]interface{}{"end": time.Now().Add(-time.Hour).Format(time.RFC3339)}), 0)
+
+		// Revoked credentials are not returned for a time range after they were revoked.
+		start := time.Now().Add(time.Minute).Format(time.RFC3339)
+		credentials := testHistoryRead(t, b, s, map[string]interface{}{"start": start})
+		require.Len(t, credentials, 1)
+		require.Equal(t, failing.InternalData["credential_name"], credentials[0]["name"])
+	})
+
+	t.Run("leases issued before the history", func(t *testing.T) {
+		name := "vault-" + uuid.NewString()
+
+		err := revoke(&logical.Secret{
+			LeaseOptions: logical.LeaseOptions{IssueTime: time.Now().Add(-time.Hour)},
+			InternalData: map[string]interface{}{
+				"secret_type":        grafanaTokenType,
+				"is_cloud":           false,
+				"service_account_id": int64(9999),
+				"vault_role":         "viewer",
+				"connection":         defaultConnection,
+				"credential_name":    name,
+			},
+		})
+		require.NoError(t, err)
+
+		entry, err := getHistoryEntry(context.Background(), s, name)
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		require.Equal(t, "viewer", entry.Role)
+		require.Equal(t, int64(9999), entry.ServiceAccountID)
+		require.Equal(t, revokeOutcomeAlreadyDeleted, entry.RevokeOutcome)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		resp, err := testHistoryConfig(b, s, logical.ReadOperation, nil)
+		require.NoError(t, err)
+		require.Equal(t, defaultHistoryRetention.Seconds(), resp.Data["retention"])
+
+		resp, err = testHistoryConfig(b, s, logical.UpdateOperation, map[string]interface{}{"retention": "1h"})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		entry, err := getHistoryEntry(context.Background(), s, viewer.InternalData["credential_name"].(string))
+		require.NoError(t, err)
+		entry.RevokedAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, putHistoryEntry(context.Background(), s, entry))
+
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+
+		entry, err = getHistoryEntry(context.Background(), s, viewer.InternalData["credential_name"].(string))
+		require.NoError(t, err)
+		require.Nil(t, entry)
+
+		// Credentials whose lease has not ended yet are kept.
+		entry, err = getHistoryEntry(context.Background(), s, failing.InternalData["credential_name"].(string))
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+	})
+}
+
+func testHistoryRead(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "history",
+		Data:      d,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	return resp.Data["credentials"].([]map[string]interface{})
+}
+
+func testHistoryConfig(b logical.Backend, s logical.Storage, op logical.Operation, d map[string]interface{}) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      "history/config",
+		Data:      d,
+		Storage:   s,
+	})
+}
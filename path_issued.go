@@ -0,0 +1,152 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathIssued(b *grafanaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issued/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathIssuedList,
+				},
+			},
+			HelpSynopsis:    pathIssuedListHelpSynopsis,
+			HelpDescription: pathIssuedListHelpDescription,
+		},
+		{
+			Pattern: "issued/lookup$",
+			Fields: map[string]*framework.FieldSchema{
+				"service_account_id": {
+					Type:        framework.TypeInt64,
+					Description: "Find the credentials backed by the Grafana service account with this ID",
+					Required:    false,
+				},
+				"access_policy_id": {
+					Type:        framework.TypeString,
+					Description: "Find the credentials backed by the Grafana Cloud access policy with this ID",
+					Required:    false,
+				},
+				"user_id": {
+					Type:        framework.TypeInt64,
+					Description: "Find the credentials backed by the Grafana user with this ID",
+					Required:    false,
+				},
+				"stack": {
+					Type:        framework.TypeString,
+					Description: "Find the credentials issued for service accounts in this Grafana Cloud stack",
+					Required:    false,
+				},
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Find the credentials issued by this role",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuedLookup,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssuedLookup,
+				},
+			},
+			HelpSynopsis:    pathIssuedLookupHelpSynopsis,
+			HelpDescription: pathIssuedLookupHelpDescription,
+		},
+	}
+}
+
+func (b *grafanaBackend) pathIssuedList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	issued, err := listIssuedCredentials(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(issued))
+	keyInfo := make(map[string]interface{}, len(issued))
+
+	for _, i := range issued {
+		keys = append(keys, i.Name)
+		keyInfo[i.Name] = i.toResponseData()
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *grafanaBackend) pathIssuedLookup(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	var filters []func(i *issuedCredential) bool
+
+	if val, ok := d.GetOk("service_account_id"); ok {
+		serviceAccountID := val.(int64)
+		filters = append(filters, func(i *issuedCredential) bool { return i.ServiceAccountID == serviceAccountID })
+	}
+
+	if val, ok := d.GetOk("access_policy_id"); ok {
+		accessPolicyID := val.(string)
+		filters = append(filters, func(i *issuedCredential) bool { return i.AccessPolicyID == accessPolicyID })
+	}
+
+	if val, ok := d.GetOk("user_id"); ok {
+		userID := val.(int64)
+		filters = append(filters, func(i *issuedCredential) bool { return i.UserID == userID })
+	}
+
+	if val, ok := d.GetOk("stack"); ok {
+		stack := val.(string)
+		filters = append(filters, func(i *issuedCredential) bool { return strings.EqualFold(i.Stack, stack) })
+	}
+
+	if val, ok := d.GetOk("role"); ok {
+		role := val.(string)
+		filters = append(filters, func(i *issuedCredential) bool { return i.Role == role })
+	}
+
+	if len(filters) == 0 {
+		return logical.ErrorResponse("at least one of service_account_id, access_policy_id, user_id, stack or role must be set"), nil
+	}
+
+	issued, err := listIssuedCredentials(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []map[string]interface{}{}
+
+	for _, i := range issued {
+		if slices.ContainsFunc(filters, func(filter func(i *issuedCredential) bool) bool { return !filter(i) }) {
+			continue
+		}
+
+		matches = append(matches, i.toResponseData())
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"credentials": matches,
+		},
+	}, nil
+}
+
+const (
+	pathIssuedListHelpSynopsis    = `List the credentials of leases that were not revoked yet.`
+	pathIssuedListHelpDescription = `
+Credentials are listed by the name of the Grafana service account or Grafana Cloud access policy backing them. The
+key info holds the role, the Vault entity, display name and request ID that requested them, when they were issued
+and the IDs of the objects in Grafana.
+`
+
+	pathIssuedLookupHelpSynopsis    = `Find the lease that created a Grafana service account or Grafana Cloud access policy.`
+	pathIssuedLookupHelpDescription = `
+This path returns the credentials of leases that were not revoked yet and match all of the given service account ID,
+access policy ID, user ID, stack and role. The request ID of a credential can be looked up in the Vault audit log to find its
+lease ID.
+`
+)
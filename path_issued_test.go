@@ -0,0 +1,177 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssued(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	for _, role := range []string{"viewer", "editor"} {
+		resp, err := testTokenRoleCreate(t, b, s, role, map[string]interface{}{
+			"role": "Viewer",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		ID:          "request-1",
+		Operation:   logical.ReadOperation,
+		Path:        "creds/viewer",
+		Storage:     s,
+		EntityID:    "entity-1",
+		DisplayName: "oidc-alice",
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	viewerSecret := resp.Secret
+	viewerName := viewerSecret.InternalData["credential_name"].(string)
+	viewerID := viewerSecret.InternalData["service_account_id"].(int64)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/editor",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	editorName := resp.Secret.InternalData["credential_name"].(string)
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "issued/",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{viewerName, editorName}, resp.Data["keys"])
+
+		info := resp.Data["key_info"].(map[string]interface{})[viewerName].(map[string]interface{})
+		require.Equal(t, "viewer", info["role"])
+		require.Equal(t, "entity-1", info["entity_id"])
+		require.Equal(t, "oidc-alice", info["display_name"])
+		require.Equal(t, "request-1", info["request_id"])
+		require.Equal(t, viewerID, info["service_account_id"])
+		require.Equal(t, walKindServiceAccount, info["type"])
+	})
+
+	t.Run("lookup by service account id", func(t *testing.T) {
+		resp, err := testIssuedLookup(b, s, map[string]interface{}{"service_account_id": viewerID})
+		require.NoError(t, err)
+
+		credentials := resp.Data["credentials"].([]map[string]interface{})
+		require.Len(t, credentials, 1)
+		require.Equal(t, viewerName, credentials[0]["name"])
+		require.Equal(t, "entity-1", credentials[0]["entity_id"])
+	})
+
+	t.Run("lookup by role", func(t *testing.T) {
+		resp, err := testIssuedLookup(b, s, map[string]interface{}{"role": "editor"})
+		require.NoError(t, err)
+
+		credentials := resp.Data["credentials"].([]map[string]interface{})
+		require.Len(t, credentials, 1)
+		require.Equal(t, editorName, credentials[0]["name"])
+	})
+
+	t.Run("lookup with all filters", func(t *testing.T) {
+		resp, err := testIssuedLookup(b, s, map[string]interface{}{"role": "editor", "service_account_id": viewerID})
+		require.NoError(t, err)
+		require.Empty(t, resp.Data["credentials"])
+	})
+
+	t.Run("lookup without filter", func(t *testing.T) {
+		_, err := testIssuedLookup(b, s, nil)
+		require.ErrorContains(t, err, "at least one of")
+	})
+
+	t.Run("revoked credentials are removed", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    viewerSecret,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testIssuedLookup(b, s, map[string]interface{}{"service_account_id": viewerID})
+		require.NoError(t, err)
+		require.Empty(t, resp.Data["credentials"])
+	})
+}
+
+func TestIssuedLookupCloud(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "policy", map[string]interface{}{
+		"type":   roleCloudAccessPolicy,
+		"region": "us",
+		"scopes": []string{"metrics:read"},
+		"realms": `[{"type":"stack","identifier":"1"}]`,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/policy",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	accessPolicyID := resp.Secret.InternalData["access_policy_id"].(string)
+
+	resp, err = testIssuedLookup(b, s, map[string]interface{}{"access_policy_id": accessPolicyID})
+	require.NoError(t, err)
+
+	credentials := resp.Data["credentials"].([]map[string]interface{})
+	require.Len(t, credentials, 1)
+	require.Equal(t, "policy", credentials[0]["role"])
+	require.Equal(t, "us", credentials[0]["region"])
+	require.Equal(t, walKindAccessPolicy, credentials[0]["type"])
+}
+
+func testIssuedLookup(b logical.Backend, s logical.Storage, d map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "issued/lookup",
+		Data:      d,
+		Storage:   s,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+
+	return resp, nil
+}
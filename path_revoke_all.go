@@ -0,0 +1,278 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const staticRoleTokenType = "static_role_token"
+
+func pathRevokeAll(b *grafanaBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revoke-all" + optionalConnectionRegex(),
+		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the connection whose credentials are revoked. Defaults to the default connection",
+				Required:    false,
+			},
+			"name_prefix": {
+				Type:        framework.TypeString,
+				Description: "Revoke all service accounts and access policies whose names start with this prefix, instead of the ones named like the objects created by the backend. The owners of the configured tokens and of the tokens of static roles are never revoked",
+				Required:    false,
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "Report the credentials that would be revoked without revoking them",
+				Required:    false,
+				Default:     false,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathRevokeAllUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathRevokeAllHelpSynopsis,
+		HelpDescription: pathRevokeAllHelpDescription,
+	}
+}
+
+func (b *grafanaBackend) pathRevokeAllUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	connection := connectionName(d)
+	dryRun := d.Get("dry_run").(bool)
+
+	config, err := getConfig(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("connection %s is not configured", connection), nil
+	}
+
+	revoked, warnings, err := b.revokeAll(ctx, req.Storage, connection, config, d.Get("name_prefix").(string), dryRun)
+	if errors.Is(err, errCleanupInProgress) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := "revoked"
+	if dryRun {
+		key = "would_revoke"
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"connection": connection,
+			"dry_run":    dryRun,
+			key:          revoked,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// revokeAll deletes every service account, access policy and token created by the backend through a connection,
+// whether or not it belongs to a lease, including the shared service accounts of roles and the tokens minted for
+// static roles. Objects are found by listing them in Grafana, so that they are also deleted when the records of the
+// backend are incomplete. The revoked credentials are returned together with warnings for the ones that could not be
+// revoked.
+func (b *grafanaBackend) revokeAll(ctx context.Context, s logical.Storage, connection string, config *grafanaConfig, namePrefix string, dryRun bool) ([]map[string]interface{}, []string, error) {
+	if !b.cleanupLock.TryLock() {
+		return nil, nil, errCleanupInProgress
+	}
+	defer b.cleanupLock.Unlock()
+
+	c, err := b.getClient(ctx, s, connection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A name prefix may match the owner of the configured token, which is only known once the token was rotated. It
+	// is looked up so that it is left alone.
+	if namePrefix != "" && config.ServiceAccountID == 0 && config.AccessPolicyID == "" {
+		resolved := *config
+
+		if err := resolveConfigTokenOwner(ctx, c, &resolved); err != nil {
+			return nil, nil, fmt.Errorf("error looking up the owner of the configured token of connection %s: %w", connection, err)
+		}
+
+		config = &resolved
+	}
+
+	issued, err := listIssuedCredentials(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, warnings, err := b.listManagedObjects(ctx, s, c, connection, config, issued, namePrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing objects of connection %s: %w", connection, err)
+	}
+
+	seen := map[string]bool{}
+	for _, object := range objects {
+		seen[object.Name] = true
+	}
+
+	// Shared service accounts and access policies are not named like the other objects. Roles create new ones when
+	// credentials are issued next.
+	shared, err := b.sharedObjects(ctx, s, connection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, object := range shared {
+		if !seen[object.Name] {
+			seen[object.Name] = true
+			objects = append(objects, object)
+		}
+	}
+
+	// Objects of leases are revoked even if they were not found by their names.
+	issuedByName := map[string]bool{}
+
+	for _, i := range issued {
+		if i.Connection != connection {
+			continue
+		}
+
+		issuedByName[i.Name] = true
+
+		if !seen[i.Name] {
+			objects = append(objects, i.toManagedObject())
+		}
+	}
+
+	revoked := make([]map[string]interface{}, 0, len(objects))
+
+	for _, object := range objects {
+		if !dryRun {
+			if err := deleteManagedObject(ctx, c, object); err != nil {
+				warnings = append(warnings, fmt.Sprintf("error deleting %s %s: %s", object.Kind, object.Name, err))
+				continue
+			}
+
+			if issuedByName[object.Name] {
+				if err := deleteIssuedCredential(ctx, s, object.Name); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		revoked = append(revoked, object.toResponseData())
+	}
+
+	staticRevoked, staticWarnings, err := b.revokeStaticRoleTokens(ctx, s, connection, config, dryRun)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revoked = append(revoked, staticRevoked...)
+	warnings = append(warnings, staticWarnings...)
+
+	if !dryRun {
+		b.Logger().Warn("revoked all credentials of connection", "connection", connection, "revoked", len(revoked), "failed", len(warnings))
+	}
+
+	return revoked, warnings, nil
+}
+
+// revokeStaticRoleTokens deletes the tokens minted for the static roles of a connection. A static role whose tokens
+// were all deleted is rotated by the next run of the periodic function.
+func (b *grafanaBackend) revokeStaticRoleTokens(ctx context.Context, s logical.Storage, connection string, config *grafanaConfig, dryRun bool) ([]map[string]interface{}, []string, error) {
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	names, err := s.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing static roles: %w", err)
+	}
+
+	c, err := b.getClient(ctx, s, connection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		revoked  []map[string]interface{}
+		warnings []string
+	)
+
+	for _, name := range names {
+		role, err := b.getStaticRole(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if role == nil || role.Connection != connection {
+			continue
+		}
+
+		for _, token := range []**staticToken{&role.CurrentToken, &role.PreviousToken} {
+			if *token == nil {
+				continue
+			}
+
+			if !dryRun {
+				if err := deleteStaticToken(ctx, c, config.Type, role, *token); err != nil {
+					warnings = append(warnings, fmt.Sprintf("error deleting token %s of static role %s: %s", (*token).ID, name, err))
+					continue
+				}
+			}
+
+			revoked = append(revoked, map[string]interface{}{
+				"type":        staticRoleTokenType,
+				"connection":  connection,
+				"static_role": name,
+				"id":          (*token).ID,
+				"created_at":  (*token).CreatedAt.Format(time.RFC3339),
+			})
+
+			if !dryRun {
+				*token = nil
+			}
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if role.PreviousToken == nil {
+			role.PreviousTokenDeleteAt = time.Time{}
+		}
+
+		if role.CurrentToken == nil {
+			role.LastRotated = time.Time{}
+		}
+
+		if err := setStaticRole(ctx, s, name, role); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return revoked, warnings, nil
+}
+
+const (
+	pathRevokeAllHelpSynopsis    = `Revoke all credentials created by the backend through a connection.`
+	pathRevokeAllHelpDescription = `
+This path deletes every service account and access policy the backend created through a connection, including the
+ones whose leases are still valid and the shared objects of roles, as well as the tokens minted for static roles.
+Objects are found by listing them in the Grafana instance, or in the stacks and regions referenced by the roles and
+leases of a Grafana Cloud connection, so that they are deleted even if the lease records of Vault are unreliable.
+Objects are recognized by the marker of the mount in their names, including the names generated by name templates,
+or by "name_prefix". Use "revoke-all/<connection>" for a named connection and set "dry_run" to report the credentials
+that would be revoked without revoking them.
+`
+)
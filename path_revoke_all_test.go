@@ -0,0 +1,243 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeAll(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+	staticID := grafana.addServiceAccount(staticServiceAccountName, "Editor")
+	unrelatedID := grafana.addServiceAccount("grafana-bot", "Viewer")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+		"role": "Viewer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+		"service_account_name": staticServiceAccountName,
+		"rotation_period":      "1h",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Len(t, grafana.tokens(staticID), 1)
+
+	var secrets []*logical.Secret
+
+	for range 2 {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/viewer",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		secrets = append(secrets, resp.Secret)
+	}
+
+	leaseIDs := []int64{
+		secrets[0].InternalData["service_account_id"].(int64),
+		secrets[1].InternalData["service_account_id"].(int64),
+	}
+
+	orphanName := defaultCredentialName(b.mountMarker)
+	orphanID := grafana.addServiceAccount(orphanName, "Viewer")
+
+	// Objects of other mounts and objects named before names were marked are left alone.
+	otherMountName := defaultCredentialName(newMountMarker("other"))
+	otherMountID := grafana.addServiceAccount(otherMountName, "Viewer")
+	unmarkedName := "vault-" + uuid.NewString()
+	unmarkedID := grafana.addServiceAccount(unmarkedName, "Viewer")
+
+	leaseNames := []string{
+		secrets[0].InternalData["credential_name"].(string),
+		secrets[1].InternalData["credential_name"].(string),
+	}
+
+	t.Run("unknown connection", func(t *testing.T) {
+		_, err := testRevokeAll(b, s, "revoke-all/other", nil)
+		require.ErrorContains(t, err, "connection other is not configured")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		resp, err := testRevokeAll(b, s, "revoke-all", map[string]interface{}{"dry_run": true})
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data["dry_run"])
+		require.Equal(t, defaultConnection, resp.Data["connection"])
+		require.NotContains(t, resp.Data, "revoked")
+
+		revoked := resp.Data["would_revoke"].([]map[string]interface{})
+		require.ElementsMatch(t, append([]string{orphanName}, leaseNames...), tidiedNames(resp, "would_revoke"))
+		require.Len(t, revoked, 4)
+		require.Equal(t, staticRoleTokenType, revoked[3]["type"])
+		require.Equal(t, staticRoleName, revoked[3]["static_role"])
+
+		for _, id := range append([]int64{orphanID}, leaseIDs...) {
+			require.True(t, grafana.serviceAccountExists(id))
+		}
+
+		require.Len(t, grafana.tokens(staticID), 1)
+	})
+
+	t.Run("revoke all", func(t *testing.T) {
+		resp, err := testRevokeAll(b, s, "revoke-all", nil)
+		require.NoError(t, err)
+		require.Empty(t, resp.Warnings)
+		require.Len(t, resp.Data["revoked"], 4)
+
+		for _, id := range append([]int64{orphanID}, leaseIDs...) {
+			require.False(t, grafana.serviceAccountExists(id))
+		}
+
+		require.True(t, grafana.serviceAccountExists(adminID))
+		require.True(t, grafana.serviceAccountExists(staticID))
+		require.True(t, grafana.serviceAccountExists(unrelatedID))
+		require.True(t, grafana.serviceAccountExists(otherMountID))
+		require.True(t, grafana.serviceAccountExists(unmarkedID))
+		require.Empty(t, grafana.tokens(staticID))
+
+		issued, err := listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, issued)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "static-creds/" + staticRoleName,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	t.Run("revoke leases", func(t *testing.T) {
+		for _, secret := range secrets {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.RevokeOperation,
+				Storage:   s,
+				Secret:    secret,
+			})
+			require.NoError(t, err)
+			require.Nil(t, resp)
+		}
+	})
+
+	t.Run("static role is rotated", func(t *testing.T) {
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.Len(t, grafana.tokens(staticID), 1)
+		require.NotEmpty(t, testStaticCredsRead(t, b, s))
+	})
+
+	t.Run("name prefix", func(t *testing.T) {
+		ciID := grafana.addServiceAccount("ci-deploy", "Editor")
+
+		resp, err := testRevokeAll(b, s, "revoke-all", map[string]interface{}{"name_prefix": "ci-", "dry_run": true})
+		require.NoError(t, err)
+		require.Equal(t, []string{"ci-deploy"}, tidiedNames(resp, "would_revoke"))
+
+		resp, err = testRevokeAll(b, s, "revoke-all", map[string]interface{}{"name_prefix": "ci-"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"ci-deploy"}, tidiedNames(resp, "revoked"))
+
+		require.False(t, grafana.serviceAccountExists(ciID))
+		require.True(t, grafana.serviceAccountExists(unrelatedID))
+	})
+
+	t.Run("name prefix never matches the owners of tokens", func(t *testing.T) {
+		resp, err := testRevokeAll(b, s, "revoke-all", map[string]interface{}{"name_prefix": "vault"})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{otherMountName, unmarkedName}, tidiedNames(resp, "revoked"))
+
+		resp, err = testRevokeAll(b, s, "revoke-all", map[string]interface{}{"name_prefix": "alert-"})
+		require.NoError(t, err)
+		require.Empty(t, tidiedNames(resp, "revoked"))
+
+		require.True(t, grafana.serviceAccountExists(adminID))
+		require.True(t, grafana.serviceAccountExists(staticID))
+	})
+}
+
+func TestRevokeAllCloud(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "policy", map[string]interface{}{
+		"type":   roleCloudAccessPolicy,
+		"region": "us",
+		"scopes": []string{"metrics:read"},
+		"realms": `[{"type":"stack","identifier":"1"}]`,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = testTokenRoleCreate(t, b, s, "stack", map[string]interface{}{
+		"type":  roleGrafanaServiceAccount,
+		"stack": "mystack",
+		"role":  "Viewer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/policy",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	leasePolicyID := resp.Secret.InternalData["access_policy_id"].(string)
+
+	orphanServiceAccountID := grafana.addServiceAccount(defaultCredentialName(b.mountMarker), "Viewer")
+	unrelatedPolicyID := grafana.addAccessPolicy("vault", []string{"accesspolicies:write"})
+
+	resp, err = testRevokeAll(b, s, "revoke-all", nil)
+	require.NoError(t, err)
+	require.Len(t, resp.Data["revoked"], 2)
+
+	require.False(t, grafana.accessPolicyExists(leasePolicyID))
+	require.False(t, grafana.serviceAccountExists(orphanServiceAccountID))
+	require.True(t, grafana.accessPolicyExists(unrelatedPolicyID))
+}
+
+func testRevokeAll(b logical.Backend, s logical.Storage, path string, d map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path,
+		Data:      d,
+		Storage:   s,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+
+	return resp, nil
+}
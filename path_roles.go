@@ -13,6 +13,12 @@ import (
 const (
 	roleCloudAccessPolicy     = "cloud_access_policy"
 	roleGrafanaServiceAccount = "grafana_service_account"
+	roleGrafanaUser           = "grafana_user"
+	roleGrafanaUserElevation  = "grafana_user_elevation"
+	defaultExpirySkew         = 5 * time.Minute
+	defaultDisabledRetention  = 7 * 24 * time.Hour
+	revocationModeDelete      = "delete"
+	revocationModeDisable     = "disable"
 )
 
 type realm struct {
@@ -24,16 +30,40 @@ type realm struct {
 }
 
 type grafanaRoleEntry struct {
-	Type           string        `json:"type"`            // Set when configuration type is "cloud". Should be "cloud_access_policy" or "grafana_service_account"
+	Connection     string        `json:"connection"`      // Name of the connection used to generate credentials
+	Type           string        `json:"type"`            // "cloud_access_policy" or "grafana_service_account" when configuration type is "cloud", "grafana_user" or "grafana_user_elevation" for Grafana users
 	Stack          string        `json:"stack"`           // For Grafana service accounts where configuration type is "cloud"
 	Region         string        `json:"region"`          // For Grafana Cloud access policies
 	Scopes         []string      `json:"scopes"`          // For Grafana Cloud access policies
 	Realms         string        `json:"realms"`          // For Grafana Cloud access policies
 	AllowedSubnets []string      `json:"allowed_subnets"` // For Grafana Cloud access policies
-	Role           string        `json:"role"`            // For Grafana service accounts
-	RBACRoles      []string      `json:"rbac_roles"`      // For Grafana service accounts
+	Role           string        `json:"role"`            // For Grafana service accounts, and the role existing Grafana users are raised to
+	RBACRoles      []string      `json:"rbac_roles"`      // For Grafana service accounts and users
+	PasswordPolicy string        `json:"password_policy"` // For Grafana users, the Vault password policy generating their passwords
+	LoginTemplate  string        `json:"login_template"`  // For existing Grafana users, template for the login or email of the user to elevate
+	NameTemplate   string        `json:"name_template"`   // Template for the names of service accounts and access policies
+	ExpirySkew     time.Duration `json:"expiry_skew"`     // How long tokens remain valid in Grafana after the max TTL of their lease
+	NoLease        bool          `json:"no_lease"`        // Whether credentials are issued without a lease and expire in Grafana after the TTL
 	TTL            time.Duration `json:"ttl"`
 	MaxTTL         time.Duration `json:"max_ttl"`
+
+	// SharedServiceAccount makes credentials tokens of a single service account owned by the role, instead of
+	// creating a service account for every lease.
+	SharedServiceAccount bool `json:"shared_service_account"`
+
+	// SharedAccessPolicy makes credentials tokens of a single access policy owned by the role, instead of creating an
+	// access policy for every lease.
+	SharedAccessPolicy bool `json:"shared_access_policy"`
+
+	// RevocationMode is "disable" to disable and rename the service account of a lease when it is revoked, instead of
+	// deleting it, so that it stays visible in Grafana until DisabledRetention has passed.
+	RevocationMode    string        `json:"revocation_mode"`
+	DisabledRetention time.Duration `json:"disabled_retention"`
+
+	// ServiceAccount and AccessPolicy are managed by the backend and track the shared service account or access
+	// policy once it was created.
+	ServiceAccount *sharedServiceAccount `json:"service_account,omitempty"`
+	AccessPolicy   *sharedAccessPolicy   `json:"access_policy,omitempty"`
 }
 
 func (r *grafanaRoleEntry) validate(configType string) error {
@@ -68,21 +98,125 @@ func (r *grafanaRoleEntry) validate(configType string) error {
 		}
 	}
 
+	if r.Type == roleGrafanaUser && r.NoLease {
+		return fmt.Errorf(`no_lease is not supported when type is "%s", as Grafana users do not expire`, roleGrafanaUser)
+	}
+
+	if r.credentialKind(configType) == walKindUserElevation {
+		if err := r.validateUserElevation(); err != nil {
+			return err
+		}
+	} else if r.LoginTemplate != "" {
+		return fmt.Errorf(`login_template is only supported when type is "%s"`, roleGrafanaUserElevation)
+	}
+
+	if r.PasswordPolicy != "" && r.credentialKind(configType) != walKindUser {
+		return fmt.Errorf(`password_policy is only supported when type is "%s"`, roleGrafanaUser)
+	}
+
+	if r.SharedServiceAccount && r.credentialKind(configType) != walKindServiceAccount {
+		return fmt.Errorf(`shared_service_account is only supported when type is "%s"`, roleGrafanaServiceAccount)
+	}
+
+	if r.SharedAccessPolicy && r.credentialKind(configType) != walKindAccessPolicy {
+		return fmt.Errorf(`shared_access_policy is only supported when type is "%s"`, roleCloudAccessPolicy)
+	}
+
+	if r.RevocationMode != revocationModeDelete && r.RevocationMode != revocationModeDisable {
+		return fmt.Errorf(`revocation_mode must be "%s" or "%s"`, revocationModeDelete, revocationModeDisable)
+	}
+
+	if r.RevocationMode == revocationModeDisable {
+		if r.credentialKind(configType) != walKindServiceAccount {
+			return fmt.Errorf(`revocation_mode "%s" is only supported when type is "%s"`, revocationModeDisable, roleGrafanaServiceAccount)
+		}
+
+		if r.SharedServiceAccount {
+			return fmt.Errorf(`revocation_mode "%s" is not supported with shared_service_account, as leases only get a token of the shared service account`, revocationModeDisable)
+		}
+
+		if r.NoLease {
+			return fmt.Errorf(`revocation_mode "%s" is not supported with no_lease, as credentials without a lease are never revoked`, revocationModeDisable)
+		}
+	}
+
+	if r.DisabledRetention < 0 {
+		return fmt.Errorf("disabled_retention must not be negative")
+	}
+
 	return nil
 }
 
+// credentialKind returns the kind of Grafana object created for credentials of the role.
+func (r *grafanaRoleEntry) credentialKind(configType string) string {
+	if configType == GrafanaCloudType && r.Type == roleCloudAccessPolicy {
+		return walKindAccessPolicy
+	}
+
+	if configType == GrafanaType && r.Type == roleGrafanaUser {
+		return walKindUser
+	}
+
+	if configType == GrafanaType && r.Type == roleGrafanaUserElevation {
+		return walKindUserElevation
+	}
+
+	return walKindServiceAccount
+}
+
+// basicRole returns the basic role granted to the service accounts of the role.
+func (r *grafanaRoleEntry) basicRole() string {
+	if r.Role != "" {
+		return r.Role
+	}
+
+	return "None"
+}
+
+// serviceAccountStack returns the Grafana Cloud stack the service accounts of the role are created in, or an empty
+// string for a Grafana instance.
+func (r *grafanaRoleEntry) serviceAccountStack(configType string) string {
+	if configType == GrafanaCloudType {
+		return r.Stack
+	}
+
+	return ""
+}
+
 func (r *grafanaRoleEntry) toResponseData() map[string]interface{} {
 	respData := map[string]interface{}{
-		"type":       r.Type,
-		"stack":      r.Stack,
-		"region":     r.Region,
-		"scopes":     r.Scopes,
-		"realms":     r.Realms,
-		"role":       r.Role,
-		"rbac_roles": r.RBACRoles,
-		"ttl":        r.TTL.Seconds(),
-		"max_ttl":    r.MaxTTL.Seconds(),
+		"connection":      r.Connection,
+		"type":            r.Type,
+		"stack":           r.Stack,
+		"region":          r.Region,
+		"scopes":          r.Scopes,
+		"realms":          r.Realms,
+		"role":            r.Role,
+		"rbac_roles":      r.RBACRoles,
+		"password_policy": r.PasswordPolicy,
+		"login_template":  r.LoginTemplate,
+		"name_template":   r.NameTemplate,
+		"expiry_skew":     r.ExpirySkew.Seconds(),
+		"no_lease":        r.NoLease,
+		"ttl":             r.TTL.Seconds(),
+		"max_ttl":         r.MaxTTL.Seconds(),
+
+		"shared_service_account": r.SharedServiceAccount,
+		"shared_access_policy":   r.SharedAccessPolicy,
+		"revocation_mode":        r.RevocationMode,
+		"disabled_retention":     r.DisabledRetention.Seconds(),
+	}
+
+	if r.ServiceAccount != nil {
+		respData["shared_service_account_id"] = r.ServiceAccount.ID
+		respData["shared_service_account_name"] = r.ServiceAccount.Name
+	}
+
+	if r.AccessPolicy != nil {
+		respData["shared_access_policy_id"] = r.AccessPolicy.ID
+		respData["shared_access_policy_name"] = r.AccessPolicy.Name
 	}
+
 	return respData
 
 }
@@ -97,9 +231,14 @@ func pathRole(b *grafanaBackend) []*framework.Path {
 					Description: "Name of the role",
 					Required:    true,
 				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the connection used to generate credentials. Defaults to the default connection",
+					Required:    false,
+				},
 				"type": {
 					Type:        framework.TypeString,
-					Description: `The type of Grafana Cloud credentials generated by the role, "cloud_access_policy" or "grafana_service_account"`,
+					Description: `The type of credentials generated by the role: "cloud_access_policy" or "grafana_service_account" for Grafana Cloud, "grafana_user" for temporary users of a Grafana instance, or "grafana_user_elevation" to raise the roles of existing users of a Grafana instance`,
 					Required:    false,
 				},
 				"stack": {
@@ -129,14 +268,61 @@ func pathRole(b *grafanaBackend) []*framework.Path {
 				},
 				"role": {
 					Type:        framework.TypeString,
-					Description: "The role to grant to the Grafana service account",
+					Description: "The role to grant to the Grafana service account or user, or to raise existing Grafana users to",
 					Required:    false,
 				},
 				"rbac_roles": {
 					Type:        framework.TypeCommaStringSlice,
-					Description: "The RBAC roles to grant to the Grafana service account",
+					Description: "The RBAC roles to grant to the Grafana service account or user",
+					Required:    false,
+				},
+				"password_policy": {
+					Type:        framework.TypeString,
+					Description: "The Vault password policy generating the passwords of Grafana users. Defaults to 32 characters of letters, digits and symbols",
+					Required:    false,
+				},
+				"login_template": {
+					Type:        framework.TypeString,
+					Description: "The login or email of the existing Grafana user whose roles are raised, or a template deriving it from the Vault entity requesting credentials",
 					Required:    false,
 				},
+				"name_template": {
+					Type:        framework.TypeString,
+					Description: "Template for the names of the service accounts, access policies and tokens created for credentials. Defaults to vault-<uuid>",
+					Required:    false,
+				},
+				"expiry_skew": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long generated tokens remain valid in Grafana after the max TTL of their lease has passed. Defaults to 5 minutes",
+					Default:     int(defaultExpirySkew.Seconds()),
+				},
+				"no_lease": {
+					Type:        framework.TypeBool,
+					Description: "Issue credentials without a lease. Their tokens expire in Grafana after the TTL and their objects are deleted periodically",
+					Required:    false,
+				},
+				"shared_service_account": {
+					Type:        framework.TypeBool,
+					Description: "Issue tokens of a single service account created for the role, instead of creating a service account for every lease",
+					Required:    false,
+				},
+				"shared_access_policy": {
+					Type:        framework.TypeBool,
+					Description: "Issue tokens of a single access policy created for the role, instead of creating an access policy for every lease",
+					Required:    false,
+				},
+				"revocation_mode": {
+					Type:        framework.TypeString,
+					Description: `What happens to the service account of a lease when it is revoked: "delete" deletes it, "disable" deletes its tokens, disables and renames it, and deletes it after disabled_retention`,
+					Required:    false,
+					Default:     revocationModeDelete,
+				},
+				"disabled_retention": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long service accounts disabled on revocation are kept before they are deleted. Set to 0 to keep them until they are deleted manually. Defaults to 7 days",
+					Required:    false,
+					Default:     int(defaultDisabledRetention.Seconds()),
+				},
 				"ttl": {
 					Type:        framework.TypeDurationSecond,
 					Description: "Default lease for generated credentials. If not set or set to 0, will use system default.",
@@ -216,25 +402,40 @@ func (b *grafanaBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 		return logical.ErrorResponse("missing role name"), nil
 	}
 
-	config, err := getConfig(ctx, req.Storage)
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	roleEntry, err := b.getRole(ctx, req.Storage, name.(string))
 	if err != nil {
 		return nil, err
 	}
 
-	if config == nil {
-		return nil, fmt.Errorf("cannot write role when backend configuration is unset")
+	if roleEntry == nil {
+		roleEntry = &grafanaRoleEntry{
+			Connection:        defaultConnection,
+			RevocationMode:    revocationModeDelete,
+			DisabledRetention: defaultDisabledRetention,
+		}
 	}
 
-	roleEntry, err := b.getRole(ctx, req.Storage, name.(string))
+	createOperation := req.Operation == logical.CreateOperation
+
+	if connection, ok := d.GetOk("connection"); ok && connection.(string) != "" {
+		roleEntry.Connection = connection.(string)
+	}
+
+	config, err := getConfig(ctx, req.Storage, roleEntry.Connection)
 	if err != nil {
 		return nil, err
 	}
 
-	if roleEntry == nil {
-		roleEntry = &grafanaRoleEntry{}
-	}
+	if config == nil {
+		if roleEntry.Connection == defaultConnection {
+			return nil, fmt.Errorf("cannot write role when backend configuration is unset")
+		}
 
-	createOperation := req.Operation == logical.CreateOperation
+		return logical.ErrorResponse("connection %s is not configured", roleEntry.Connection), nil
+	}
 
 	if roleType, ok := d.GetOk("type"); ok {
 		roleEntry.Type = roleType.(string)
@@ -268,10 +469,48 @@ func (b *grafanaBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 		roleEntry.RBACRoles = roleType.([]string)
 	}
 
+	if passwordPolicy, ok := d.GetOk("password_policy"); ok {
+		roleEntry.PasswordPolicy = passwordPolicy.(string)
+	}
+
+	if loginTemplate, ok := d.GetOk("login_template"); ok {
+		roleEntry.LoginTemplate = loginTemplate.(string)
+	}
+
+	if nameTemplate, ok := d.GetOk("name_template"); ok {
+		roleEntry.NameTemplate = nameTemplate.(string)
+	}
+
+	if shared, ok := d.GetOk("shared_service_account"); ok {
+		roleEntry.SharedServiceAccount = shared.(bool)
+	}
+
+	if shared, ok := d.GetOk("shared_access_policy"); ok {
+		roleEntry.SharedAccessPolicy = shared.(bool)
+	}
+
+	if noLease, ok := d.GetOk("no_lease"); ok {
+		roleEntry.NoLease = noLease.(bool)
+	}
+
+	if revocationMode, ok := d.GetOk("revocation_mode"); ok {
+		roleEntry.RevocationMode = revocationMode.(string)
+	}
+
+	if retentionRaw, ok := d.GetOk("disabled_retention"); ok {
+		roleEntry.DisabledRetention = time.Duration(retentionRaw.(int)) * time.Second
+	}
+
 	if err := roleEntry.validate(config.Type); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
+	if roleEntry.NameTemplate != "" {
+		if err := validateNameTemplate(roleEntry.NameTemplate, name.(string), roleEntry.credentialKind(config.Type), b.mountMarker); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
 	if ttlRaw, ok := d.GetOk("ttl"); ok {
 		roleEntry.TTL = time.Duration(ttlRaw.(int)) * time.Second
 	} else if createOperation {
@@ -284,14 +523,31 @@ func (b *grafanaBackend) pathRolesWrite(ctx context.Context, req *logical.Reques
 		roleEntry.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
 	}
 
+	if expirySkewRaw, ok := d.GetOk("expiry_skew"); ok {
+		roleEntry.ExpirySkew = time.Duration(expirySkewRaw.(int)) * time.Second
+	} else if createOperation {
+		roleEntry.ExpirySkew = time.Duration(d.Get("expiry_skew").(int)) * time.Second
+	}
+
+	if roleEntry.ExpirySkew < 0 {
+		return logical.ErrorResponse("expiry_skew must not be negative"), nil
+	}
+
 	if roleEntry.MaxTTL != 0 && roleEntry.TTL > roleEntry.MaxTTL {
 		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
 	}
 
+	warnings := b.updateSharedServiceAccount(ctx, req.Storage, config.Type, roleEntry)
+	warnings = append(warnings, b.updateSharedAccessPolicy(ctx, req.Storage, roleEntry)...)
+
 	if err := setRole(ctx, req.Storage, name.(string), roleEntry); err != nil {
 		return nil, err
 	}
 
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
 	return nil, nil
 }
 
@@ -301,11 +557,35 @@ func (b *grafanaBackend) pathRolesDelete(ctx context.Context, req *logical.Reque
 		return logical.ErrorResponse("missing role"), nil
 	}
 
-	err := req.Storage.Delete(ctx, "roles/"+roleName)
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	roleEntry, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+
+	// The shared service account or access policy is owned by the role, so it is deleted together with the tokens of
+	// its leases.
+	if roleEntry != nil && roleEntry.ServiceAccount != nil {
+		warnings = append(warnings, b.deleteSharedObject(ctx, req.Storage, roleEntry.ServiceAccount.toManagedObject())...)
+	}
+
+	if roleEntry != nil && roleEntry.AccessPolicy != nil {
+		warnings = append(warnings, b.deleteSharedObject(ctx, req.Storage, roleEntry.AccessPolicy.toManagedObject())...)
+	}
+
+	err = req.Storage.Delete(ctx, "roles/"+roleName)
 	if err != nil {
 		return nil, fmt.Errorf("error deleting grafana role: %w", err)
 	}
 
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
 	return nil, nil
 }
 
@@ -345,6 +625,29 @@ func (b *grafanaBackend) getRole(ctx context.Context, s logical.Storage, name st
 	if err := entry.DecodeJSON(&role); err != nil {
 		return nil, err
 	}
+
+	// Roles written before named connections were supported use the default connection.
+	if role.Connection == "" {
+		role.Connection = defaultConnection
+	}
+
+	// Roles written before revocation modes were supported delete the service accounts of revoked leases.
+	if role.RevocationMode == "" {
+		role.RevocationMode = revocationModeDelete
+		role.DisabledRetention = defaultDisabledRetention
+	}
+
+	// Roles written before the expiry skew was configurable use the default, while an expiry skew of 0 is kept.
+	var stored map[string]json.RawMessage
+
+	if err := entry.DecodeJSON(&stored); err != nil {
+		return nil, err
+	}
+
+	if _, ok := stored["expiry_skew"]; !ok {
+		role.ExpirySkew = defaultExpirySkew
+	}
+
 	return &role, nil
 }
 
@@ -30,8 +30,9 @@ func TestCloudAccessPolicyRole(t *testing.T) {
 	b, s := getTestBackend(t)
 
 	err := testConfigCreate(b, s, map[string]interface{}{
-		"type":  GrafanaCloudType,
-		"token": "abcd",
+		"type":              GrafanaCloudType,
+		"token":             "abcd",
+		"verify_connection": false,
 	})
 	assert.NoError(t, err)
 
@@ -262,8 +263,9 @@ func TestServiceAccountRole(t *testing.T) {
 	b, s := getTestBackend(t)
 
 	err := testConfigCreate(b, s, map[string]interface{}{
-		"type":  GrafanaCloudType,
-		"token": "abcd",
+		"type":              GrafanaCloudType,
+		"token":             "abcd",
+		"verify_connection": false,
 	})
 	assert.NoError(t, err)
 
@@ -501,3 +503,35 @@ func testTokenRoleDelete(t *testing.T, b *grafanaBackend, s logical.Storage, vRo
 		Storage:   s,
 	})
 }
+
+func TestRoleExpirySkewNotStored(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaType,
+		"token":             token,
+		"url":               "http://localhost:3000",
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	err = s.Put(context.Background(), &logical.StorageEntry{
+		Key:   "roles/legacy",
+		Value: []byte(`{"type":"grafana_service_account","role":"Viewer","max_ttl":3600000000000}`),
+	})
+	require.NoError(t, err)
+
+	role, err := b.getRole(context.Background(), s, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, defaultExpirySkew, role.ExpirySkew)
+
+	resp, err := testTokenRoleCreate(t, b, s, "legacy", map[string]interface{}{
+		"expiry_skew": 0,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	role, err = b.getRole(context.Background(), s, "legacy")
+	require.NoError(t, err)
+	require.Zero(t, role.ExpirySkew)
+}
@@ -0,0 +1,397 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	cloudTokenPrefix          = "glc_"
+	serviceAccountLoginPrefix = "sa-"
+	tokenExpiryCheckInterval  = time.Hour
+)
+
+func pathRotateRoot(b *grafanaBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rotate-root" + optionalConnectionRegex(),
+		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the connection whose token is rotated. Defaults to the default connection",
+				Required:    false,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathRotateRootUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathRotateRootHelpSynopsis,
+		HelpDescription: pathRotateRootHelpDescription,
+	}
+}
+
+func (b *grafanaBackend) pathRotateRootUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	warnings, err := b.rotateRoot(ctx, req.Storage, connectionName(d))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
+
+// rotateRoot replaces the token in the mount configuration with a newly minted token for the same service account
+// or access policy and deletes the old token. The token is not rotated if the old token cannot be identified, so that
+// it is never left behind. Failing to delete the old token is reported as a warning, as the new token is already in
+// use at that point.
+func (b *grafanaBackend) rotateRoot(ctx context.Context, s logical.Storage, connection string) ([]string, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("configuration of connection %s is unset", connection)
+	}
+
+	c, err := b.getClient(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolveConfigTokenOwner(ctx, c, config); err != nil {
+		return nil, fmt.Errorf("error finding owner of the configured token: %w", err)
+	}
+
+	if config.TokenID == "" {
+		return nil, fmt.Errorf("the configured token cannot be identified among the tokens of service account %d, set token_id in the configuration of connection %s", config.ServiceAccountID, connection)
+	}
+
+	oldTokenID := config.TokenID
+	tokenName := fmt.Sprintf("vault-root-%d", time.Now().Unix())
+
+	if config.Type == GrafanaCloudType {
+		token, err := c.CreateCloudAccessPolicyToken(ctx, config.Region, client.CreateCloudAccessPolicyTokenInput{
+			AccessPolicyID: config.AccessPolicyID,
+			Name:           tokenName,
+			DisplayName:    tokenName,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating cloud access policy token: %w", err)
+		}
+
+		config.Token = token.Token
+		config.TokenID = token.ID
+		config.TokenExpiresAt = token.ExpiresAt
+	} else {
+		token, err := c.CreateServiceAccountToken(ctx, client.CreateServiceAccountTokenInput{
+			Name:             tokenName,
+			ServiceAccountID: config.ServiceAccountID,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating service account token: %w", err)
+		}
+
+		config.Token = token.Key
+		config.TokenID = strconv.FormatInt(token.ID, 10)
+		config.TokenExpiresAt = nil
+	}
+
+	config.LastRotated = time.Now()
+
+	if err := putConfig(ctx, s, connection, config); err != nil {
+		// The old token is still stored and valid, so only the unused new token is deleted.
+		if deleteErr := deleteConfigToken(ctx, c, config, config.TokenID); deleteErr != nil {
+			b.Logger().Error("error deleting the new token after error storing the rotated configuration, it must be deleted manually", "connection", connection, "token_id", config.TokenID, "error", deleteErr)
+		}
+
+		return nil, fmt.Errorf("error storing rotated configuration: %w", err)
+	}
+
+	// reset the client so the next invocation will pick up the new token
+	b.reset(connection)
+
+	c, err = b.getClient(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := deleteConfigToken(ctx, c, config, oldTokenID); err != nil {
+		return []string{fmt.Sprintf("error deleting previous token %s, it must be deleted manually: %s", oldTokenID, err)}, nil
+	}
+
+	return nil, nil
+}
+
+// deleteConfigToken deletes a token of the service account or access policy that owns the configured token.
+func deleteConfigToken(ctx context.Context, c *client.Grafana, config *grafanaConfig, tokenID string) error {
+	if config.Type == GrafanaCloudType {
+		return c.DeleteCloudAccessPolicyToken(ctx, config.Region, tokenID)
+	}
+
+	id, err := strconv.ParseInt(tokenID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token ID %q: %w", tokenID, err)
+	}
+
+	return c.DeleteServiceAccountToken(ctx, config.ServiceAccountID, id)
+}
+
+// rotateRootIfDue is run by the periodic function and rotates the configured token when automated rotation is
+// enabled and the token is due.
+func (b *grafanaBackend) rotateRootIfDue(ctx context.Context, s logical.Storage, connection string) error {
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		return nil
+	}
+
+	nextRotation := config.nextRotation()
+	if nextRotation.IsZero() || time.Now().Before(nextRotation) {
+		return nil
+	}
+
+	warnings, err := b.rotateRoot(ctx, s, connection)
+	if err != nil {
+		b.Logger().Error("error rotating the configured token", "connection", connection, "error", err)
+		return fmt.Errorf("error rotating the configured token of connection %s: %w", connection, err)
+	}
+
+	for _, warning := range warnings {
+		b.Logger().Warn(warning, "connection", connection)
+	}
+
+	b.Logger().Info("rotated the configured token", "connection", connection)
+
+	return nil
+}
+
+// checkTokenExpiry is run by the periodic function. It looks up when the configured token expires at most once
+// per tokenExpiryCheckInterval and logs a warning when the token has expired or is about to expire.
+func (b *grafanaBackend) checkTokenExpiry(ctx context.Context, s logical.Storage, connection string) error {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	if time.Since(b.lastExpiryCheck[connection]) < tokenExpiryCheckInterval {
+		return nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		return nil
+	}
+
+	c, err := b.getClient(ctx, s, connection)
+	if err != nil {
+		return err
+	}
+
+	b.lastExpiryCheck[connection] = time.Now()
+
+	if err := resolveConfigTokenOwner(ctx, c, config); err != nil {
+		b.Logger().Debug("unable to determine the expiry of the configured token", "connection", connection, "error", err)
+		return nil
+	}
+
+	expiresAt, err := lookupConfigTokenExpiry(ctx, c, config)
+	if err != nil {
+		b.Logger().Debug("unable to determine the expiry of the configured token", "connection", connection, "error", err)
+		return nil
+	}
+
+	config.TokenExpiresAt = expiresAt
+
+	if err := putConfig(ctx, s, connection, config); err != nil {
+		return fmt.Errorf("error storing configuration: %w", err)
+	}
+
+	if warning := config.expiryWarning(); warning != "" {
+		b.Logger().Warn(warning, "connection", connection)
+	}
+
+	return nil
+}
+
+func lookupConfigTokenExpiry(ctx context.Context, c *client.Grafana, config *grafanaConfig) (*time.Time, error) {
+	if config.TokenID == "" {
+		return nil, errors.New("the configured token could not be identified")
+	}
+
+	if config.Type == GrafanaCloudType {
+		tokens, err := c.ListCloudAccessPolicyTokens(ctx, config.Region, config.AccessPolicyID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, token := range tokens {
+			if token.ID == config.TokenID {
+				return token.ExpiresAt, nil
+			}
+		}
+	} else {
+		tokens, err := c.ListServiceAccountTokens(ctx, config.ServiceAccountID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, token := range tokens {
+			if strconv.FormatInt(token.ID, 10) == config.TokenID {
+				return token.Expiration, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("token %s not found", config.TokenID)
+}
+
+// resolveConfigTokenOwner fills in the service account or access policy that owns the configured token, as well as
+// the ID of the token itself if it can be determined.
+func resolveConfigTokenOwner(ctx context.Context, c *client.Grafana, config *grafanaConfig) error {
+	if config.Type == GrafanaCloudType {
+		if config.AccessPolicyID != "" && config.TokenID != "" {
+			return nil
+		}
+
+		return resolveCloudTokenOwner(ctx, c, config)
+	}
+
+	if config.ServiceAccountID == 0 {
+		user, err := c.CurrentUser(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(user.Login, serviceAccountLoginPrefix) {
+			return fmt.Errorf("the configured token belongs to user %q, not to a service account", user.Login)
+		}
+
+		config.ServiceAccountID = user.ID
+	}
+
+	if config.TokenID == "" {
+		tokens, err := c.ListServiceAccountTokens(ctx, config.ServiceAccountID)
+		if err != nil {
+			return err
+		}
+
+		// The configured token can only be identified when it is the only token of the service account. Otherwise its
+		// ID must be set in the configuration.
+		if len(tokens) == 1 {
+			config.TokenID = strconv.FormatInt(tokens[0].ID, 10)
+		}
+	}
+
+	return nil
+}
+
+type cloudTokenPayload struct {
+	Name     string `json:"n"`
+	Metadata struct {
+		Region string `json:"r"`
+	} `json:"m"`
+}
+
+// parseCloudToken extracts the region and the name of a Grafana Cloud access policy token. The name is the name
+// of the access policy and the name of the token joined by a dash.
+func parseCloudToken(token string) (*cloudTokenPayload, error) {
+	if !strings.HasPrefix(token, cloudTokenPrefix) {
+		return nil, errors.New("token is not a Grafana Cloud access policy token")
+	}
+
+	raw := strings.TrimPrefix(token, cloudTokenPrefix)
+
+	decoded, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(raw, "="))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding token: %w", err)
+		}
+	}
+
+	payload := &cloudTokenPayload{}
+	if err := json.Unmarshal(decoded, payload); err != nil {
+		return nil, fmt.Errorf("error decoding token: %w", err)
+	}
+
+	if payload.Metadata.Region == "" || payload.Name == "" {
+		return nil, errors.New("token does not contain a region and name")
+	}
+
+	return payload, nil
+}
+
+func resolveCloudTokenOwner(ctx context.Context, c *client.Grafana, config *grafanaConfig) error {
+	payload, err := parseCloudToken(config.Token)
+	if err != nil {
+		return err
+	}
+
+	policies, err := c.ListCloudAccessPolicies(ctx, payload.Metadata.Region)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if config.AccessPolicyID != "" && policy.ID != config.AccessPolicyID {
+			continue
+		}
+
+		tokenName, ok := strings.CutPrefix(payload.Name, policy.Name+"-")
+		if !ok {
+			continue
+		}
+
+		tokens, err := c.ListCloudAccessPolicyTokens(ctx, payload.Metadata.Region, policy.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if token.Name == tokenName {
+				config.Region = payload.Metadata.Region
+				config.AccessPolicyID = policy.ID
+				config.TokenID = token.ID
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no access policy in region %s owns the configured token", payload.Metadata.Region)
+}
+
+const (
+	pathRotateRootHelpSynopsis    = `Rotate the token used by the backend to manage Grafana.`
+	pathRotateRootHelpDescription = `
+This path mints a new token for the service account or access policy that owns the configured token, stores it in
+the configuration and deletes the previous token. Use "rotate-root/<connection>" to rotate the token of a named
+connection.
+`
+)
@@ -0,0 +1,273 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateRoot(t *testing.T) {
+	t.Run("Grafana", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+		rootToken := grafana.addServiceAccountToken(serviceAccountID)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		resp, err := testRotateRoot(b, s)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.NotEqual(t, rootToken, config.Token)
+		require.Equal(t, serviceAccountID, config.ServiceAccountID)
+
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+		mustTokenID(t, grafana, serviceAccountID, config.Token)
+
+		// The second rotation deletes the token by its stored ID.
+		resp, err = testRotateRoot(b, s)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+	})
+
+	t.Run("Grafana - previous token unknown", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+		rootToken := grafana.addServiceAccountToken(serviceAccountID)
+		otherToken := grafana.addServiceAccountToken(serviceAccountID)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		// The token is not rotated, as the previous token would be left behind.
+		_, err = testRotateRoot(b, s)
+		require.ErrorContains(t, err, "set token_id")
+		require.Len(t, grafana.tokens(serviceAccountID), 2)
+
+		rootTokenID := mustTokenID(t, grafana, serviceAccountID, rootToken)
+
+		err = testConfigUpdate(b, s, map[string]interface{}{
+			"token_id": strconv.FormatInt(rootTokenID, 10),
+		})
+		require.NoError(t, err)
+
+		resp, err := testRotateRoot(b, s)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+
+		tokens := grafana.tokens(serviceAccountID)
+		require.Len(t, tokens, 2)
+		require.NotContains(t, tokens, rootTokenID)
+		mustTokenID(t, grafana, serviceAccountID, otherToken)
+		mustTokenID(t, grafana, serviceAccountID, config.Token)
+	})
+
+	t.Run("Grafana - new token deleted if the configuration cannot be stored", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+		rootToken := grafana.addServiceAccountToken(serviceAccountID)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		_, err = testRotateRoot(b, &failingPutStorage{Storage: s, key: configStoragePath})
+		require.ErrorContains(t, err, "error storing rotated configuration")
+
+		tokens := grafana.tokens(serviceAccountID)
+		require.Len(t, tokens, 1)
+		mustTokenID(t, grafana, serviceAccountID, rootToken)
+	})
+
+	t.Run("Cloud", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		accessPolicyID := grafana.addAccessPolicy("vault", requiredCloudScopes)
+		rootToken := grafana.addAccessPolicyToken(accessPolicyID, "root", cloudAccessPolicyRegion, nil)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaCloudType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		resp, err := testRotateRoot(b, s)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.NotEqual(t, rootToken, config.Token)
+		require.Equal(t, accessPolicyID, config.AccessPolicyID)
+		require.Equal(t, cloudAccessPolicyRegion, config.Region)
+
+		tokens := grafana.accessPolicyTokens(accessPolicyID)
+		require.Len(t, tokens, 1)
+		require.Contains(t, tokens, config.TokenID)
+
+		err = testConfigRead(b, s, map[string]interface{}{
+			"type":                 GrafanaCloudType,
+			"token_fingerprint":    tokenFingerprint(config.Token),
+			"url":                  grafana.URL,
+			"rotation_period":      float64(0),
+			"rotation_schedule":    "",
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "",
+			"insecure_skip_verify": false,
+			"proxy_url":            "",
+			"request_timeout":      client.DefaultTimeout.Seconds(),
+			"max_retries":          defaultMaxRetries,
+			"requests_per_second":  float64(0),
+			"max_in_flight":        0,
+			"last_rotated":         anyValue,
+			"access_policy_id":     accessPolicyID,
+			"region":               cloudAccessPolicyRegion,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Scheduled rotation", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		serviceAccountID := grafana.addServiceAccount("vault", "Admin")
+		rootToken := grafana.addServiceAccountToken(serviceAccountID)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":            GrafanaType,
+			"token":           rootToken,
+			"url":             grafana.URL,
+			"rotation_period": "24h",
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.rotateRootIfDue(context.Background(), s, defaultConnection))
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.Equal(t, rootToken, config.Token)
+
+		config.LastRotated = time.Now().Add(-25 * time.Hour)
+		require.NoError(t, putConfig(context.Background(), s, defaultConnection, config))
+
+		require.NoError(t, b.rotateRootIfDue(context.Background(), s, defaultConnection))
+
+		config, err = getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.NotEqual(t, rootToken, config.Token)
+		require.WithinDuration(t, time.Now(), config.LastRotated, time.Minute)
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+	})
+
+	t.Run("Invalid rotation settings", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":              GrafanaCloudType,
+			"token":             token,
+			"rotation_period":   "24h",
+			"rotation_schedule": "0 0 * * SAT",
+		})
+		require.Error(t, err)
+
+		err = testConfigCreate(b, s, map[string]interface{}{
+			"type":              GrafanaCloudType,
+			"token":             token,
+			"rotation_schedule": "not a schedule",
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("Expiry warning", func(t *testing.T) {
+		b, s := getTestBackend(t)
+		grafana := newFakeGrafana(t)
+
+		expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+		accessPolicyID := grafana.addAccessPolicy("vault", requiredCloudScopes)
+		rootToken := grafana.addAccessPolicyToken(accessPolicyID, "root", cloudAccessPolicyRegion, &expiresAt)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"type":  GrafanaCloudType,
+			"token": rootToken,
+			"url":   grafana.URL,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.checkTokenExpiry(context.Background(), s, defaultConnection))
+
+		config, err := getConfig(context.Background(), s, defaultConnection)
+		require.NoError(t, err)
+		require.NotNil(t, config.TokenExpiresAt)
+		require.True(t, expiresAt.Equal(*config.TokenExpiresAt))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configStoragePath,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Warnings, 1)
+	})
+
+	t.Run("Unconfigured", func(t *testing.T) {
+		b, s := getTestBackend(t)
+
+		_, err := testRotateRoot(b, s)
+		require.Error(t, err)
+	})
+}
+
+// failingPutStorage fails writes of a single key.
+type failingPutStorage struct {
+	logical.Storage
+	key string
+}
+
+func (s *failingPutStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if entry.Key == s.key {
+		return errors.New("storage unavailable")
+	}
+
+	return s.Storage.Put(ctx, entry)
+}
+
+func testRotateRoot(b logical.Backend, s logical.Storage) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rotate-root",
+		Storage:   s,
+	})
+}
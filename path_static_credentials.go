@@ -0,0 +1,80 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathStaticCredentials(b *grafanaBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-creds/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the static role",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStaticCredentialsRead,
+			},
+		},
+		HelpSynopsis:    pathStaticCredentialsHelpSyn,
+		HelpDescription: pathStaticCredentialsHelpDesc,
+	}
+}
+
+func (b *grafanaBackend) pathStaticCredentialsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.staticRoleLock.RLock()
+	defer b.staticRoleLock.RUnlock()
+
+	role, err := b.getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil || role.CurrentToken == nil {
+		return nil, nil
+	}
+
+	ttl := time.Until(role.nextRotation())
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	respData := map[string]interface{}{
+		"token":           role.CurrentToken.Token,
+		"last_rotated":    role.LastRotated.Format(time.RFC3339),
+		"rotation_period": role.RotationPeriod.Seconds(),
+		"ttl":             ttl.Seconds(),
+	}
+
+	if role.Type == roleCloudAccessPolicy {
+		respData["region"] = role.Region
+		respData["access_policy_id"] = role.AccessPolicyID
+	} else {
+		respData["stack"] = role.Stack
+		respData["service_account_id"] = role.ServiceAccountID
+	}
+
+	if role.CurrentToken.ExpiresAt != nil {
+		respData["expires_at"] = role.CurrentToken.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+const pathStaticCredentialsHelpSyn = `
+Read the current token of a static role.
+`
+
+const pathStaticCredentialsHelpDesc = `
+This path returns the current token of a static role. The token is
+rotated automatically based on the role's rotation period.
+`
@@ -0,0 +1,457 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	staticRoleStoragePrefix = "static-roles/"
+	minRotationPeriod       = 5 * time.Minute
+)
+
+type staticToken struct {
+	ID        string     `json:"id"`
+	Token     string     `json:"token"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type grafanaStaticRoleEntry struct {
+	Connection       string        `json:"connection"`         // Name of the connection used to rotate tokens
+	Type             string        `json:"type"`               // Set when configuration type is "cloud". Should be "cloud_access_policy" or "grafana_service_account"
+	Stack            string        `json:"stack"`              // For Grafana service accounts where configuration type is "cloud"
+	ServiceAccountID int64         `json:"service_account_id"` // For Grafana service accounts
+	Region           string        `json:"region"`             // For Grafana Cloud access policies
+	AccessPolicyID   string        `json:"access_policy_id"`   // For Grafana Cloud access policies
+	RotationPeriod   time.Duration `json:"rotation_period"`
+	GracePeriod      time.Duration `json:"grace_period"`
+
+	// The following fields are managed by the backend and track the rotation state.
+	CurrentToken          *staticToken `json:"current_token,omitempty"`
+	PreviousToken         *staticToken `json:"previous_token,omitempty"`
+	PreviousTokenDeleteAt time.Time    `json:"previous_token_delete_at,omitempty"`
+	LastRotated           time.Time    `json:"last_rotated,omitempty"`
+}
+
+func (r *grafanaStaticRoleEntry) validate(configType string) error {
+	if configType == GrafanaCloudType {
+		if r.Type != roleCloudAccessPolicy && r.Type != roleGrafanaServiceAccount {
+			return fmt.Errorf(`type must be "%s" or "%s"`, roleCloudAccessPolicy, roleGrafanaServiceAccount)
+		}
+
+		if r.Type == roleGrafanaServiceAccount && r.Stack == "" {
+			return fmt.Errorf(`stack must be set when type is "%s"`, roleGrafanaServiceAccount)
+		}
+
+		if r.Type == roleCloudAccessPolicy {
+			if r.Region == "" {
+				return fmt.Errorf(`region must be set when type is "%s"`, roleCloudAccessPolicy)
+			}
+
+			if r.AccessPolicyID == "" {
+				return fmt.Errorf(`access_policy_id must be set when type is "%s"`, roleCloudAccessPolicy)
+			}
+		}
+	} else if r.Type == roleCloudAccessPolicy {
+		return fmt.Errorf(`type "%s" is only supported when configuration type is "%s"`, roleCloudAccessPolicy, GrafanaCloudType)
+	}
+
+	if r.isServiceAccount() && r.ServiceAccountID <= 0 {
+		return errors.New("service_account_id or service_account_name must be set")
+	}
+
+	if r.RotationPeriod < minRotationPeriod {
+		return fmt.Errorf("rotation_period must be at least %s", minRotationPeriod)
+	}
+
+	if r.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+
+	if r.GracePeriod >= r.RotationPeriod {
+		return errors.New("grace_period must be less than rotation_period")
+	}
+
+	return nil
+}
+
+func (r *grafanaStaticRoleEntry) isServiceAccount() bool {
+	return r.Type != roleCloudAccessPolicy
+}
+
+// sameTarget reports whether both static roles manage tokens for the same service account or access policy.
+func (r *grafanaStaticRoleEntry) sameTarget(other *grafanaStaticRoleEntry) bool {
+	return r.Connection == other.Connection &&
+		r.Type == other.Type &&
+		r.Stack == other.Stack &&
+		r.ServiceAccountID == other.ServiceAccountID &&
+		r.Region == other.Region &&
+		r.AccessPolicyID == other.AccessPolicyID
+}
+
+func (r *grafanaStaticRoleEntry) nextRotation() time.Time {
+	return r.LastRotated.Add(r.RotationPeriod)
+}
+
+func (r *grafanaStaticRoleEntry) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"connection":         r.Connection,
+		"type":               r.Type,
+		"stack":              r.Stack,
+		"service_account_id": r.ServiceAccountID,
+		"region":             r.Region,
+		"access_policy_id":   r.AccessPolicyID,
+		"rotation_period":    r.RotationPeriod.Seconds(),
+		"grace_period":       r.GracePeriod.Seconds(),
+	}
+
+	if !r.LastRotated.IsZero() {
+		respData["last_rotated"] = r.LastRotated.Format(time.RFC3339)
+		respData["next_rotation"] = r.nextRotation().Format(time.RFC3339)
+	}
+
+	return respData
+}
+
+func pathStaticRole(b *grafanaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "static-roles/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the connection used to rotate tokens. Defaults to the default connection",
+					Required:    false,
+				},
+				"type": {
+					Type:        framework.TypeString,
+					Description: `The type of Grafana Cloud credentials managed by the static role, "cloud_access_policy" or "grafana_service_account"`,
+					Required:    false,
+				},
+				"stack": {
+					Type:        framework.TypeString,
+					Description: "The stack slug of the Grafana Cloud instance the service account belongs to",
+					Required:    false,
+				},
+				"service_account_id": {
+					Type:        framework.TypeInt64,
+					Description: "The ID of the existing Grafana service account to rotate tokens for",
+					Required:    false,
+				},
+				"service_account_name": {
+					Type:        framework.TypeString,
+					Description: "The name of the existing Grafana service account to rotate tokens for. Resolved to an ID when the role is written",
+					Required:    false,
+				},
+				"region": {
+					Type:        framework.TypeString,
+					Description: "The region of the existing Grafana Cloud access policy",
+					Required:    false,
+				},
+				"access_policy_id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the existing Grafana Cloud access policy to rotate tokens for",
+					Required:    false,
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often the token is rotated. Must be at least 5 minutes.",
+					Required:    true,
+				},
+				"grace_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the previous token is kept after a rotation before it is deleted. Defaults to 0, deleting the previous token immediately.",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesDelete,
+				},
+			},
+			ExistenceCheck:  b.pathStaticRoleExistenceCheck,
+			HelpSynopsis:    pathStaticRoleHelpSynopsis,
+			HelpDescription: pathStaticRoleHelpDescription,
+		},
+		{
+			Pattern: "static-roles/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesList,
+				},
+			},
+			HelpSynopsis:    pathStaticRoleListHelpSynopsis,
+			HelpDescription: pathStaticRoleListHelpDescription,
+		},
+	}
+}
+
+func (b *grafanaBackend) pathStaticRoleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	entry, err := b.getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+func (b *grafanaBackend) pathStaticRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *grafanaBackend) pathStaticRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.staticRoleLock.RLock()
+	defer b.staticRoleLock.RUnlock()
+
+	entry, err := b.getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: entry.toResponseData(),
+	}, nil
+}
+
+func (b *grafanaBackend) pathStaticRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name, ok := d.GetOk("name")
+	if !ok {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	roleEntry, err := b.getStaticRole(ctx, req.Storage, name.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		roleEntry = &grafanaStaticRoleEntry{Connection: defaultConnection}
+	}
+
+	previous := *roleEntry
+
+	if connection, ok := d.GetOk("connection"); ok && connection.(string) != "" {
+		roleEntry.Connection = connection.(string)
+	}
+
+	config, err := getConfig(ctx, req.Storage, roleEntry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		if roleEntry.Connection == defaultConnection {
+			return nil, fmt.Errorf("cannot write static role when backend configuration is unset")
+		}
+
+		return logical.ErrorResponse("connection %s is not configured", roleEntry.Connection), nil
+	}
+
+	if roleType, ok := d.GetOk("type"); ok {
+		roleEntry.Type = roleType.(string)
+	}
+
+	if stack, ok := d.GetOk("stack"); ok {
+		roleEntry.Stack = stack.(string)
+	}
+
+	if region, ok := d.GetOk("region"); ok {
+		roleEntry.Region = region.(string)
+	}
+
+	if accessPolicyID, ok := d.GetOk("access_policy_id"); ok {
+		roleEntry.AccessPolicyID = accessPolicyID.(string)
+	}
+
+	if rotationPeriod, ok := d.GetOk("rotation_period"); ok {
+		roleEntry.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+	}
+
+	if gracePeriod, ok := d.GetOk("grace_period"); ok {
+		roleEntry.GracePeriod = time.Duration(gracePeriod.(int)) * time.Second
+	}
+
+	serviceAccountID, hasID := d.GetOk("service_account_id")
+	serviceAccountName, hasName := d.GetOk("service_account_name")
+
+	if hasID && hasName {
+		return logical.ErrorResponse("only one of service_account_id or service_account_name may be set"), nil
+	}
+
+	c, err := b.getClient(ctx, req.Storage, roleEntry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasID {
+		roleEntry.ServiceAccountID = serviceAccountID.(int64)
+	} else if hasName {
+		id, err := findServiceAccountIDByName(ctx, c, config.Type, roleEntry.Stack, serviceAccountName.(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		roleEntry.ServiceAccountID = id
+	}
+
+	if err := roleEntry.validate(config.Type); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var warnings []string
+
+	// When the role now points to a different service account or access policy, the tokens minted for the old
+	// one are deleted and a token is minted for the new one straight away.
+	// The new target is checked first, so that the tokens of the old one are kept if it is invalid.
+	if !roleEntry.sameTarget(&previous) {
+		if err := checkStaticRoleTarget(ctx, c, config.Type, roleEntry); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		if roleEntry.CurrentToken != nil || roleEntry.PreviousToken != nil {
+			warnings = append(warnings, b.deleteStaticRoleTokens(ctx, req.Storage, &previous)...)
+
+			roleEntry.CurrentToken = nil
+			roleEntry.PreviousToken = nil
+			roleEntry.PreviousTokenDeleteAt = time.Time{}
+		}
+	}
+
+	if roleEntry.CurrentToken == nil {
+		if err := b.rotateStaticRole(ctx, c, config.Type, name.(string), roleEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setStaticRole(ctx, req.Storage, name.(string), roleEntry); err != nil {
+		return nil, err
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
+
+func (b *grafanaBackend) pathStaticRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	roleEntry, err := b.getStaticRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		return nil, nil
+	}
+
+	// The tokens minted by this role are deleted, but the service account itself is left untouched
+	// as it is not owned by Vault.
+	warnings := b.deleteStaticRoleTokens(ctx, req.Storage, roleEntry)
+
+	if err := req.Storage.Delete(ctx, staticRoleStoragePrefix+roleName); err != nil {
+		return nil, fmt.Errorf("error deleting grafana static role: %w", err)
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
+
+func setStaticRole(ctx context.Context, s logical.Storage, name string, roleEntry *grafanaStaticRoleEntry) error {
+	entry, err := logical.StorageEntryJSON(staticRoleStoragePrefix+name, roleEntry)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for static role")
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *grafanaBackend) getStaticRole(ctx context.Context, s logical.Storage, name string) (*grafanaStaticRoleEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing static role name")
+	}
+
+	entry, err := s.Get(ctx, staticRoleStoragePrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role grafanaStaticRoleEntry
+
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+
+	// Static roles written before named connections were supported use the default connection.
+	if role.Connection == "" {
+		role.Connection = defaultConnection
+	}
+
+	return &role, nil
+}
+
+const (
+	pathStaticRoleHelpSynopsis    = `Manages static roles that rotate tokens for existing Grafana service accounts and Grafana Cloud access policies.`
+	pathStaticRoleHelpDescription = `
+This path allows you to read and write static roles. A static role adopts an existing Grafana or Grafana Cloud
+stack service account, or an existing Grafana Cloud access policy, and periodically rotates a token for it,
+keeping the previous token valid for the configured grace period.
+`
+
+	pathStaticRoleListHelpSynopsis    = `List the existing static roles in Grafana backend`
+	pathStaticRoleListHelpDescription = `Static roles will be listed by the role name.`
+)
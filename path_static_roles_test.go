@@ -0,0 +1,329 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	staticRoleName           = "static-role"
+	staticServiceAccountName = "alert-provisioning"
+)
+
+func TestStaticRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	serviceAccountID := grafana.addServiceAccount(staticServiceAccountName, "Editor")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	t.Run("Create Static Role - fail on invalid rotation period", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"service_account_id": serviceAccountID,
+			"rotation_period":    "1m",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role - fail on grace period not less than rotation period", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"service_account_id": serviceAccountID,
+			"rotation_period":    "1h",
+			"grace_period":       "1h",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role - fail on unknown service account name", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"service_account_name": "does-not-exist",
+			"rotation_period":      "1h",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role - fail on unknown service account id", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"service_account_id": 9999,
+			"rotation_period":    "1h",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		require.ErrorContains(t, resp.Error(), "service account 9999 does not exist")
+	})
+
+	t.Run("Create Static Role - pass", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"service_account_name": staticServiceAccountName,
+			"rotation_period":      "1h",
+			"grace_period":         "10m",
+		})
+
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+	})
+
+	t.Run("Read Static Role", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "static-roles/" + staticRoleName,
+			Storage:   s,
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.Equal(t, serviceAccountID, resp.Data["service_account_id"])
+		require.Equal(t, float64(3600), resp.Data["rotation_period"])
+		require.Equal(t, float64(600), resp.Data["grace_period"])
+		require.NotEmpty(t, resp.Data["next_rotation"])
+	})
+
+	t.Run("List Static Roles", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "static-roles/",
+			Storage:   s,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, []string{staticRoleName}, resp.Data["keys"])
+	})
+
+	var firstToken string
+
+	t.Run("Read Static Credentials", func(t *testing.T) {
+		firstToken = testStaticCredsRead(t, b, s)
+		mustTokenID(t, grafana, serviceAccountID, firstToken)
+	})
+
+	t.Run("Rotate Static Role - previous token kept during grace period", func(t *testing.T) {
+		testStaticRoleExpire(t, b, s, func(role *grafanaStaticRoleEntry) {
+			role.LastRotated = time.Now().Add(-2 * time.Hour)
+		})
+
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+
+		secondToken := testStaticCredsRead(t, b, s)
+		require.NotEqual(t, firstToken, secondToken)
+
+		tokens := grafana.tokens(serviceAccountID)
+		require.Len(t, tokens, 2)
+		require.Contains(t, tokens, mustTokenID(t, grafana, serviceAccountID, firstToken))
+	})
+
+	t.Run("Rotate Static Role - previous token deleted after grace period", func(t *testing.T) {
+		testStaticRoleExpire(t, b, s, func(role *grafanaStaticRoleEntry) {
+			role.PreviousTokenDeleteAt = time.Now().Add(-time.Minute)
+		})
+
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+		require.Len(t, grafana.tokens(serviceAccountID), 1)
+	})
+
+	t.Run("Update Static Role - tokens kept on unknown service account id", func(t *testing.T) {
+		token := testStaticCredsRead(t, b, s)
+
+		resp, err := testStaticRoleWrite(t, b, s, logical.UpdateOperation, staticRoleName, map[string]interface{}{
+			"service_account_id": 9999,
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		require.ErrorContains(t, resp.Error(), "service account 9999 does not exist")
+
+		require.Equal(t, token, testStaticCredsRead(t, b, s))
+		require.Contains(t, grafana.tokens(serviceAccountID), mustTokenID(t, grafana, serviceAccountID, token))
+	})
+
+	t.Run("Delete Static Role - tokens deleted", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "static-roles/" + staticRoleName,
+			Storage:   s,
+		})
+
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Empty(t, grafana.tokens(serviceAccountID))
+	})
+}
+
+func TestStaticCloudAccessPolicyRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	accessPolicyID := grafana.addAccessPolicy("alloy", []string{"metrics:write"})
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	t.Run("Create Static Role - fail on missing access policy id", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"type":            roleCloudAccessPolicy,
+			"region":          cloudAccessPolicyRegion,
+			"rotation_period": "1h",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role - fail on unknown access policy", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"type":             roleCloudAccessPolicy,
+			"region":           cloudAccessPolicyRegion,
+			"access_policy_id": "does-not-exist",
+			"rotation_period":  "1h",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role - pass", func(t *testing.T) {
+		resp, err := testStaticRoleWrite(t, b, s, logical.CreateOperation, staticRoleName, map[string]interface{}{
+			"type":             roleCloudAccessPolicy,
+			"region":           cloudAccessPolicyRegion,
+			"access_policy_id": accessPolicyID,
+			"rotation_period":  "1h",
+		})
+
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.Len(t, grafana.accessPolicyTokens(accessPolicyID), 1)
+	})
+
+	t.Run("Read Static Credentials - includes expiry", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "static-creds/" + staticRoleName,
+			Storage:   s,
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.NotEmpty(t, resp.Data["token"])
+		require.Equal(t, accessPolicyID, resp.Data["access_policy_id"])
+
+		expiresAt, err := time.Parse(time.RFC3339, resp.Data["expires_at"].(string))
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+	})
+
+	t.Run("Rotate Static Role - previous token deleted without grace period", func(t *testing.T) {
+		before := grafana.accessPolicyTokens(accessPolicyID)
+
+		testStaticRoleExpire(t, b, s, func(role *grafanaStaticRoleEntry) {
+			role.LastRotated = time.Now().Add(-2 * time.Hour)
+		})
+
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+
+		after := grafana.accessPolicyTokens(accessPolicyID)
+		require.Len(t, after, 1)
+
+		for id := range before {
+			require.NotContains(t, after, id)
+		}
+	})
+
+	t.Run("Rotate Static Role - only stale tokens of the role deleted", func(t *testing.T) {
+		grafana.addAccessPolicyToken(accessPolicyID, "vault-static-"+staticRoleName+"-1", cloudAccessPolicyRegion, nil)
+		grafana.addAccessPolicyToken(accessPolicyID, "vault-static-"+staticRoleName+"-other-1", cloudAccessPolicyRegion, nil)
+
+		testStaticRoleExpire(t, b, s, func(role *grafanaStaticRoleEntry) {
+			role.LastRotated = time.Now().Add(-2 * time.Hour)
+		})
+
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+
+		var names []string
+		for _, token := range grafana.accessPolicyTokens(accessPolicyID) {
+			names = append(names, token.Name)
+		}
+
+		require.Len(t, names, 2)
+		require.Contains(t, names, "vault-static-"+staticRoleName+"-other-1")
+		require.NotContains(t, names, "vault-static-"+staticRoleName+"-1")
+	})
+}
+
+func testStaticRoleWrite(t *testing.T, b *grafanaBackend, s logical.Storage, op logical.Operation, roleName string, d map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      "static-roles/" + roleName,
+		Data:      d,
+		Storage:   s,
+	})
+}
+
+func testStaticCredsRead(t *testing.T, b *grafanaBackend, s logical.Storage) string {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "static-creds/" + staticRoleName,
+		Storage:   s,
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.NotEmpty(t, resp.Data["token"])
+
+	return resp.Data["token"].(string)
+}
+
+// testStaticRoleExpire modifies the stored rotation state of the static role to simulate the passage of time.
+func testStaticRoleExpire(t *testing.T, b *grafanaBackend, s logical.Storage, modify func(role *grafanaStaticRoleEntry)) {
+	t.Helper()
+	role, err := b.getStaticRole(context.Background(), s, staticRoleName)
+	require.NoError(t, err)
+	require.NotNil(t, role)
+
+	modify(role)
+
+	require.NoError(t, setStaticRole(context.Background(), s, staticRoleName, role))
+}
+
+func mustTokenID(t *testing.T, grafana *fakeGrafana, serviceAccountID int64, key string) int64 {
+	t.Helper()
+	for id, k := range grafana.tokens(serviceAccountID) {
+		if k == key {
+			return id
+		}
+	}
+
+	t.Fatalf("token %s not found for service account %d", key, serviceAccountID)
+	return 0
+}
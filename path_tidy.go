@@ -0,0 +1,382 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tidyConfigStoragePath   = "tidy/config"
+	defaultTidySafetyBuffer = 72 * time.Hour
+	defaultAutoTidyInterval = 12 * time.Hour
+	minAutoTidyInterval     = time.Hour
+)
+
+var errCleanupInProgress = errors.New("a tidy or revoke-all operation is already in progress")
+
+type tidyConfig struct {
+	AutoTidy         bool          `json:"auto_tidy"`
+	AutoTidyInterval time.Duration `json:"auto_tidy_interval"`
+	SafetyBuffer     time.Duration `json:"safety_buffer"`
+}
+
+func pathTidy(b *grafanaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "tidy$",
+			Fields: map[string]*framework.FieldSchema{
+				"safety_buffer": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How old an object must be before it is deleted. Defaults to the safety buffer of the tidy configuration",
+					Required:    false,
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Report the objects that would be deleted without deleting them",
+					Required:    false,
+					Default:     false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathTidyUpdate,
+					ForwardPerformanceStandby:   true,
+					ForwardPerformanceSecondary: true,
+				},
+			},
+			HelpSynopsis:    pathTidyHelpSynopsis,
+			HelpDescription: pathTidyHelpDescription,
+		},
+		{
+			Pattern: "tidy/config$",
+			Fields: map[string]*framework.FieldSchema{
+				"auto_tidy": {
+					Type:        framework.TypeBool,
+					Description: "Whether orphaned objects are tidied periodically",
+					Required:    false,
+				},
+				"auto_tidy_interval": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often orphaned objects are tidied when auto_tidy is enabled. Must be at least 1 hour. Defaults to 12 hours",
+					Required:    false,
+				},
+				"safety_buffer": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How old an object must be before it is deleted. Defaults to 72 hours",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTidyConfigRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTidyConfigWrite,
+				},
+			},
+			HelpSynopsis:    pathTidyConfigHelpSynopsis,
+			HelpDescription: pathTidyConfigHelpDescription,
+		},
+	}
+}
+
+func (b *grafanaBackend) pathTidyUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	safetyBuffer := config.SafetyBuffer
+
+	if val, ok := d.GetOk("safety_buffer"); ok {
+		safetyBuffer = time.Duration(val.(int)) * time.Second
+	}
+
+	if safetyBuffer < 0 {
+		return logical.ErrorResponse("safety_buffer must not be negative"), nil
+	}
+
+	dryRun := d.Get("dry_run").(bool)
+
+	deleted, warnings, err := b.tidy(ctx, req.Storage, safetyBuffer, dryRun)
+	if errors.Is(err, errCleanupInProgress) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]map[string]interface{}, 0, len(deleted))
+	for _, object := range deleted {
+		objects = append(objects, object.toResponseData())
+	}
+
+	key := "deleted"
+	if dryRun {
+		key = "would_delete"
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run":       dryRun,
+			"safety_buffer": safetyBuffer.Seconds(),
+			key:             objects,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// tidy deletes the service accounts and access policies created by the backend that no longer belong to a lease
+// and are older than the safety buffer. An object belongs to a lease while the lease has not been revoked and has
+// not expired, and to a request issuing credentials while it has a WAL entry. Only objects named with the marker of
+// the mount are candidates, as the leases of other mounts and the ones issued before names were marked are not
+// recorded. The deleted objects are returned together with warnings for the objects that could not be checked or
+// deleted.
+func (b *grafanaBackend) tidy(ctx context.Context, s logical.Storage, safetyBuffer time.Duration, dryRun bool) ([]*managedObject, []string, error) {
+	if !b.cleanupLock.TryLock() {
+		return nil, nil, errCleanupInProgress
+	}
+	defer b.cleanupLock.Unlock()
+
+	connections, err := listConnections(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issued, err := listIssuedCredentials(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issuedByName := map[string]*issuedCredential{}
+	for _, i := range issued {
+		issuedByName[i.Name] = i
+	}
+
+	pending, err := pendingWALNames(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		deleted  []*managedObject
+		warnings []string
+	)
+
+	now := time.Now()
+
+	for _, connection := range connections {
+		config, err := getConfig(ctx, s, connection)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if config == nil {
+			continue
+		}
+
+		c, err := b.getClient(ctx, s, connection)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		objects, listWarnings, err := b.listManagedObjects(ctx, s, c, connection, config, issued, "")
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("error listing objects of connection %s: %s", connection, err))
+			continue
+		}
+
+		warnings = append(warnings, listWarnings...)
+
+		seen := map[string]bool{}
+
+		for _, object := range objects {
+			seen[object.Name] = true
+
+			if pending[object.Name] {
+				continue
+			}
+
+			if i, ok := issuedByName[object.Name]; ok {
+				// The lease may still be in use until it expires.
+				if now.Before(i.ExpiresAt.Add(safetyBuffer)) {
+					continue
+				}
+			} else {
+				if err := lookupCreatedAt(ctx, c, object); err != nil {
+					warnings = append(warnings, fmt.Sprintf("error looking up %s %s: %s", object.Kind, object.Name, err))
+					continue
+				}
+
+				// Objects of unknown age are kept, as they may have just been created.
+				if object.CreatedAt.IsZero() || now.Sub(object.CreatedAt) < safetyBuffer {
+					continue
+				}
+			}
+
+			if !dryRun {
+				if err := deleteManagedObject(ctx, c, object); err != nil {
+					warnings = append(warnings, fmt.Sprintf("error deleting %s %s: %s", object.Kind, object.Name, err))
+					continue
+				}
+
+				if _, ok := issuedByName[object.Name]; ok {
+					if err := deleteIssuedCredential(ctx, s, object.Name); err != nil {
+						return nil, nil, err
+					}
+				}
+			}
+
+			deleted = append(deleted, object)
+		}
+
+		// The records of expired leases whose objects are already gone can be dropped, unless an object may have
+		// been missed because a stack or region could not be listed.
+		if dryRun || len(listWarnings) > 0 {
+			continue
+		}
+
+		for _, i := range issued {
+			if i.Connection == connection && !seen[i.Name] && !now.Before(i.ExpiresAt.Add(safetyBuffer)) {
+				if err := deleteIssuedCredential(ctx, s, i.Name); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	return deleted, warnings, nil
+}
+
+// autoTidy is run by the periodic function and tidies orphaned objects when auto tidy is enabled and due.
+func (b *grafanaBackend) autoTidy(ctx context.Context, s logical.Storage) error {
+	config, err := getTidyConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if !config.AutoTidy || time.Since(b.lastAutoTidy) < config.AutoTidyInterval {
+		return nil
+	}
+
+	deleted, warnings, err := b.tidy(ctx, s, config.SafetyBuffer, false)
+	if errors.Is(err, errCleanupInProgress) {
+		return nil
+	}
+
+	if err != nil {
+		b.Logger().Error("error tidying orphaned objects", "error", err)
+		return fmt.Errorf("error tidying orphaned objects: %w", err)
+	}
+
+	b.lastAutoTidy = time.Now()
+
+	for _, warning := range warnings {
+		b.Logger().Warn(warning)
+	}
+
+	for _, object := range deleted {
+		b.Logger().Info("deleted orphaned object", "type", object.Kind, "name", object.Name, "id", object.id(), "connection", object.Connection)
+	}
+
+	return nil
+}
+
+func (b *grafanaBackend) pathTidyConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"auto_tidy":          config.AutoTidy,
+			"auto_tidy_interval": config.AutoTidyInterval.Seconds(),
+			"safety_buffer":      config.SafetyBuffer.Seconds(),
+		},
+	}, nil
+}
+
+func (b *grafanaBackend) pathTidyConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := d.GetOk("auto_tidy"); ok {
+		config.AutoTidy = val.(bool)
+	}
+
+	if val, ok := d.GetOk("auto_tidy_interval"); ok {
+		config.AutoTidyInterval = time.Duration(val.(int)) * time.Second
+	}
+
+	if val, ok := d.GetOk("safety_buffer"); ok {
+		config.SafetyBuffer = time.Duration(val.(int)) * time.Second
+	}
+
+	if config.AutoTidyInterval < minAutoTidyInterval {
+		return logical.ErrorResponse("auto_tidy_interval must be at least %s", minAutoTidyInterval), nil
+	}
+
+	if config.SafetyBuffer < 0 {
+		return logical.ErrorResponse("safety_buffer must not be negative"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(tidyConfigStoragePath, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("error storing tidy configuration: %w", err)
+	}
+
+	return nil, nil
+}
+
+// getTidyConfig returns the tidy configuration, with defaults for the settings that were never written.
+func getTidyConfig(ctx context.Context, s logical.Storage) (*tidyConfig, error) {
+	config := &tidyConfig{
+		AutoTidyInterval: defaultAutoTidyInterval,
+		SafetyBuffer:     defaultTidySafetyBuffer,
+	}
+
+	entry, err := s.Get(ctx, tidyConfigStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tidy configuration: %w", err)
+	}
+
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, fmt.Errorf("error decoding tidy configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+const (
+	pathTidyHelpSynopsis    = `Delete service accounts and access policies created by the backend that no longer belong to a lease.`
+	pathTidyHelpDescription = `
+This path finds the service accounts and access policies created by the backend in the Grafana instances, stacks and
+regions used by its connections and roles, and deletes the ones that no longer belong to a lease and are older than
+the safety buffer. Objects are recognized by their names, which carry a marker of the mount, so that the objects of
+other mounts are left alone. Names generated by the name template of a role end with the marker. Set "dry_run" to
+report the objects that would be deleted without deleting them.
+`
+
+	pathTidyConfigHelpSynopsis    = `Configure the periodic tidy of orphaned service accounts and access policies.`
+	pathTidyConfigHelpDescription = `
+This path configures whether and how often the backend tidies orphaned service accounts and access policies, and the
+safety buffer used by the tidy operation.
+`
+)
@@ -0,0 +1,295 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTidy(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+	grafana.age(adminID, 1000*time.Hour)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "viewer", map[string]interface{}{
+		"role": "Viewer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/viewer",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	leaseID := resp.Secret.InternalData["service_account_id"].(int64)
+	grafana.age(leaseID, 100*time.Hour)
+
+	addServiceAccount := func(name string, age time.Duration) int64 {
+		id := grafana.addServiceAccount(name, "Viewer")
+		grafana.age(id, age)
+		return id
+	}
+
+	orphanName := defaultCredentialName(b.mountMarker)
+	orphanID := addServiceAccount(orphanName, 100*time.Hour)
+	youngOrphanID := addServiceAccount(defaultCredentialName(b.mountMarker), time.Hour)
+	tempName := tempServiceAccountName(b.mountMarker, time.Now().Add(-100*time.Hour))
+	tempID := addServiceAccount(tempName, 0)
+	unrelatedID := addServiceAccount("vault-admin", 100*time.Hour)
+
+	// Names generated by name templates end with the marker of the mount, unless they were disabled.
+	templatedName := "ci-deploy-" + b.mountMarker
+	templatedID := addServiceAccount(templatedName, 100*time.Hour)
+	disabledID := addServiceAccount(disabledServiceAccountNamePrefix+"ci-release-"+b.mountMarker, 100*time.Hour)
+
+	// Service accounts of leases issued before names were marked have no record, and the ones of other mounts
+	// belong to leases this mount does not know of.
+	unmarkedID := addServiceAccount("vault-"+uuid.NewString(), 100*time.Hour)
+	otherMountID := addServiceAccount(defaultCredentialName(newMountMarker("other")), 100*time.Hour)
+	otherTempID := addServiceAccount(tempServiceAccountName(newMountMarker("other"), time.Now().Add(-100*time.Hour)), 0)
+
+	pendingName := defaultCredentialName(b.mountMarker)
+	pendingID := addServiceAccount(pendingName, 100*time.Hour)
+
+	_, err = framework.PutWAL(context.Background(), s, walKindServiceAccount, &walEntry{
+		Connection: defaultConnection,
+		Name:       pendingName,
+	})
+	require.NoError(t, err)
+
+	t.Run("dry run", func(t *testing.T) {
+		resp, err := testTidy(b, s, map[string]interface{}{"dry_run": true})
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data["dry_run"])
+		require.Equal(t, defaultTidySafetyBuffer.Seconds(), resp.Data["safety_buffer"])
+		require.ElementsMatch(t, []string{orphanName, tempName, templatedName}, tidiedNames(resp, "would_delete"))
+		require.NotContains(t, resp.Data, "deleted")
+
+		require.True(t, grafana.serviceAccountExists(orphanID))
+		require.True(t, grafana.serviceAccountExists(tempID))
+	})
+
+	t.Run("tidy", func(t *testing.T) {
+		resp, err := testTidy(b, s, nil)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{orphanName, tempName, templatedName}, tidiedNames(resp, "deleted"))
+
+		require.False(t, grafana.serviceAccountExists(orphanID))
+		require.False(t, grafana.serviceAccountExists(tempID))
+		require.False(t, grafana.serviceAccountExists(templatedID))
+
+		for _, id := range []int64{adminID, leaseID, youngOrphanID, unrelatedID, pendingID, unmarkedID, otherMountID, otherTempID, disabledID} {
+			require.True(t, grafana.serviceAccountExists(id))
+		}
+	})
+
+	t.Run("tidy without safety buffer", func(t *testing.T) {
+		resp, err := testTidy(b, s, map[string]interface{}{"safety_buffer": 0})
+		require.NoError(t, err)
+		require.Len(t, tidiedNames(resp, "deleted"), 1)
+
+		require.False(t, grafana.serviceAccountExists(youngOrphanID))
+		require.True(t, grafana.serviceAccountExists(leaseID))
+		require.True(t, grafana.serviceAccountExists(pendingID))
+		require.True(t, grafana.serviceAccountExists(unmarkedID))
+		require.True(t, grafana.serviceAccountExists(otherMountID))
+	})
+
+	t.Run("tidy expired lease", func(t *testing.T) {
+		issued, err := listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Len(t, issued, 1)
+
+		// The lease expired, but its service account could not be deleted when it was revoked.
+		issued[0].ExpiresAt = time.Now().Add(-time.Hour)
+		require.NoError(t, putIssuedCredential(context.Background(), s, issued[0]))
+
+		resp, err := testTidy(b, s, map[string]interface{}{"safety_buffer": 0})
+		require.NoError(t, err)
+		require.Equal(t, []string{issued[0].Name}, tidiedNames(resp, "deleted"))
+		require.False(t, grafana.serviceAccountExists(leaseID))
+
+		issued, err = listIssuedCredentials(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, issued)
+	})
+}
+
+func TestTidyCloud(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":              GrafanaCloudType,
+		"token":             token,
+		"url":               grafana.URL,
+		"verify_connection": false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testTokenRoleCreate(t, b, s, "policy", map[string]interface{}{
+		"type":   roleCloudAccessPolicy,
+		"region": "us",
+		"scopes": []string{"metrics:read"},
+		"realms": `[{"type":"stack","identifier":"1"}]`,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = testTokenRoleCreate(t, b, s, "stack", map[string]interface{}{
+		"type":  roleGrafanaServiceAccount,
+		"stack": "mystack",
+		"role":  "Viewer",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/policy",
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	leasePolicyID := resp.Secret.InternalData["access_policy_id"].(string)
+	grafana.age(leasePolicyID, 100*time.Hour)
+
+	orphanPolicyName := defaultCredentialName(b.mountMarker)
+	orphanPolicyID := grafana.addAccessPolicy(orphanPolicyName, []string{"metrics:read"})
+	grafana.age(orphanPolicyID, 100*time.Hour)
+
+	unrelatedPolicyID := grafana.addAccessPolicy("vault", []string{"accesspolicies:write"})
+	grafana.age(unrelatedPolicyID, 100*time.Hour)
+
+	orphanServiceAccountName := defaultCredentialName(b.mountMarker)
+	orphanServiceAccountID := grafana.addServiceAccount(orphanServiceAccountName, "Viewer")
+	grafana.age(orphanServiceAccountID, 100*time.Hour)
+
+	resp, err = testTidy(b, s, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{orphanPolicyName, orphanServiceAccountName}, tidiedNames(resp, "deleted"))
+
+	require.False(t, grafana.accessPolicyExists(orphanPolicyID))
+	require.False(t, grafana.serviceAccountExists(orphanServiceAccountID))
+	require.True(t, grafana.accessPolicyExists(leasePolicyID))
+	require.True(t, grafana.accessPolicyExists(unrelatedPolicyID))
+}
+
+func TestTidyConfig(t *testing.T) {
+	b, s := getTestBackend(t)
+	grafana := newFakeGrafana(t)
+
+	adminID := grafana.addServiceAccount("vault", "Admin")
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"type":  GrafanaType,
+		"token": grafana.addServiceAccountToken(adminID),
+		"url":   grafana.URL,
+	})
+	require.NoError(t, err)
+
+	t.Run("defaults", func(t *testing.T) {
+		resp, err := testTidyConfig(b, s, logical.ReadOperation, nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"auto_tidy":          false,
+			"auto_tidy_interval": defaultAutoTidyInterval.Seconds(),
+			"safety_buffer":      defaultTidySafetyBuffer.Seconds(),
+		}, resp.Data)
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		resp, err := testTidyConfig(b, s, logical.UpdateOperation, map[string]interface{}{
+			"auto_tidy_interval": "1m",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("auto tidy", func(t *testing.T) {
+		resp, err := testTidyConfig(b, s, logical.UpdateOperation, map[string]interface{}{
+			"auto_tidy":     true,
+			"safety_buffer": "1h",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testTidyConfig(b, s, logical.ReadOperation, nil)
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data["auto_tidy"])
+		require.Equal(t, time.Hour.Seconds(), resp.Data["safety_buffer"])
+		require.Equal(t, defaultAutoTidyInterval.Seconds(), resp.Data["auto_tidy_interval"])
+
+		orphanID := grafana.addServiceAccount(defaultCredentialName(b.mountMarker), "Viewer")
+		grafana.age(orphanID, 2*time.Hour)
+
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.False(t, grafana.serviceAccountExists(orphanID))
+
+		// The next tidy only runs once the interval has passed.
+		orphanID = grafana.addServiceAccount(defaultCredentialName(b.mountMarker), "Viewer")
+		grafana.age(orphanID, 2*time.Hour)
+
+		require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: s}))
+		require.True(t, grafana.serviceAccountExists(orphanID))
+	})
+}
+
+func testTidy(b logical.Backend, s logical.Storage, d map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Data:      d,
+		Storage:   s,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+
+	return resp, nil
+}
+
+func testTidyConfig(b logical.Backend, s logical.Storage, op logical.Operation, d map[string]interface{}) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      "tidy/config",
+		Data:      d,
+		Storage:   s,
+	})
+}
+
+func tidiedNames(resp *logical.Response, key string) []string {
+	var names []string
+
+	for _, object := range resp.Data[key].([]map[string]interface{}) {
+		// Static role tokens have no name.
+		if name, ok := object["name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,283 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// sharedAccessPolicy is the access policy owned by a role with shared_access_policy set. Every lease of the role gets
+// a token of it, and revoking the lease deletes only that token.
+type sharedAccessPolicy struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Connection     string   `json:"connection"`
+	Region         string   `json:"region"`
+	Scopes         []string `json:"scopes"`          // Scopes last applied to the access policy
+	Realms         string   `json:"realms"`          // Realms last applied to the access policy
+	AllowedSubnets []string `json:"allowed_subnets"` // Allowed subnets last applied to the access policy
+}
+
+func (p *sharedAccessPolicy) toManagedObject() *managedObject {
+	return &managedObject{
+		Kind:           walKindAccessPolicy,
+		Connection:     p.Connection,
+		Name:           p.Name,
+		Region:         p.Region,
+		AccessPolicyID: p.ID,
+	}
+}
+
+// applied reports whether the scopes, realms and allowed subnets of the role were applied to the access policy.
+func (p *sharedAccessPolicy) applied(role *grafanaRoleEntry) bool {
+	return slices.Equal(p.Scopes, role.Scopes) && p.Realms == role.Realms && slices.Equal(p.AllowedSubnets, role.AllowedSubnets)
+}
+
+func (p *sharedAccessPolicy) setApplied(role *grafanaRoleEntry) {
+	p.Scopes = slices.Clone(role.Scopes)
+	p.Realms = role.Realms
+	p.AllowedSubnets = slices.Clone(role.AllowedSubnets)
+}
+
+// sharedAccessPolicyName returns the name of the shared access policy of a role. Access policy names may only contain
+// lowercase letters, digits and dashes, so other characters of the role name are replaced by dashes.
+func sharedAccessPolicyName(roleName string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+
+		return '-'
+	}, strings.ToLower(roleName))
+
+	return strings.TrimRight(sharedObjectNamePrefix+name, "-")
+}
+
+// createSharedAccessPolicyToken mints a token of the shared access policy of a role, creating the access policy first
+// if the role has none yet. An access policy that was deleted outside of Vault is replaced.
+func (b *grafanaBackend) createSharedAccessPolicyToken(ctx context.Context, s logical.Storage, roleName string, credentialName string, expiresAt time.Time) (*grafanaToken, error) {
+	policy, err := b.sharedAccessPolicy(ctx, s, roleName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := b.getClient(ctx, s, policy.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := mintSharedAccessPolicyToken(ctx, c, policy, credentialName, expiresAt)
+	if client.IsNotFound(err) {
+		policy, err = b.sharedAccessPolicy(ctx, s, roleName, policy.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err = mintSharedAccessPolicyToken(ctx, c, policy, credentialName, expiresAt)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating cloud access policy token: %w", err)
+	}
+
+	token.Name = credentialName
+
+	return token, nil
+}
+
+// sharedAccessPolicy returns the shared access policy of a role, creating it if the role has none yet, and applies
+// the scopes, realms and allowed subnets of the role to it if they changed since they were last applied. deletedID is
+// the ID of an access policy that was found to be deleted outside of Vault and must be replaced, or empty.
+func (b *grafanaBackend) sharedAccessPolicy(ctx context.Context, s logical.Storage, roleName string, deletedID string) (*sharedAccessPolicy, error) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if role == nil {
+		return nil, errors.New("error retrieving role: role is nil")
+	}
+
+	if role.AccessPolicy != nil && role.AccessPolicy.ID == deletedID {
+		b.Logger().Warn("shared access policy of role was deleted outside of vault, creating a new one", "role", roleName, "access_policy_id", deletedID)
+		role.AccessPolicy = nil
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.AccessPolicy == nil {
+		wal := b.newCredentialWAL(s, role.Connection)
+
+		role.AccessPolicy, err = createSharedAccessPolicy(ctx, c, wal, roleName, role)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setRole(ctx, s, roleName, role); err != nil {
+			return nil, err
+		}
+
+		// The access policy now belongs to the role, so it must not be rolled back.
+		if err := wal.commit(ctx); err != nil {
+			return nil, err
+		}
+
+		return role.AccessPolicy, nil
+	}
+
+	if role.AccessPolicy.applied(role) {
+		return role.AccessPolicy, nil
+	}
+
+	if err := syncSharedAccessPolicy(ctx, c, role); err != nil {
+		return nil, err
+	}
+
+	if err := setRole(ctx, s, roleName, role); err != nil {
+		return nil, err
+	}
+
+	return role.AccessPolicy, nil
+}
+
+// createSharedAccessPolicy creates the shared access policy of a role with the scopes, realms and allowed subnets of
+// the role. The caller must commit the WAL once the role was stored.
+func createSharedAccessPolicy(ctx context.Context, c *client.Grafana, wal *credentialWAL, roleName string, role *grafanaRoleEntry) (*sharedAccessPolicy, error) {
+	policy := &sharedAccessPolicy{
+		Name:       sharedAccessPolicyName(roleName),
+		Connection: role.Connection,
+		Region:     role.Region,
+	}
+
+	input, err := accessPolicyInput(policy.Name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	input.DisplayName = fmt.Sprintf("Vault role %s", roleName)
+
+	walID, err := wal.record(ctx, walKindAccessPolicy, walEntry{Name: policy.Name, Region: policy.Region})
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.CreateCloudAccessPolicy(ctx, policy.Region, input)
+
+	// The rollback of the WAL entry would delete the existing access policy with the same name, which is not owned by
+	// the role.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, fmt.Errorf("an access policy named %s already exists in region %s, delete it so that the role can create its shared access policy: %w", policy.Name, policy.Region, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating shared cloud access policy: %w", err)
+	}
+
+	policy.ID = created.ID
+	policy.setApplied(role)
+
+	return policy, nil
+}
+
+// syncSharedAccessPolicy updates the shared access policy of a role in place with the scopes, realms and allowed
+// subnets of the role.
+func syncSharedAccessPolicy(ctx context.Context, c *client.Grafana, role *grafanaRoleEntry) error {
+	policy := role.AccessPolicy
+
+	input, err := accessPolicyInput(policy.Name, role)
+	if err != nil {
+		return err
+	}
+
+	// Allowed subnets that were removed from the role are only removed from the access policy if the conditions are
+	// sent.
+	if input.Conditions == nil {
+		input.Conditions = &client.CloudAccessPolicyConditions{}
+	}
+
+	_, err = c.UpdateCloudAccessPolicy(ctx, policy.Region, policy.ID, client.UpdateCloudAccessPolicyInput{
+		Scopes:     input.Scopes,
+		Realms:     input.Realms,
+		Conditions: input.Conditions,
+	})
+
+	if err != nil {
+		return fmt.Errorf("error updating shared access policy %s: %w", policy.Name, err)
+	}
+
+	policy.setApplied(role)
+
+	return nil
+}
+
+// updateSharedAccessPolicy is called when a role is written. It deletes the shared access policy if the role no
+// longer uses it or now issues credentials for another connection or region, and otherwise updates it with the
+// scopes, realms and allowed subnets of the role. Errors are returned as warnings, as a failed update is retried when
+// credentials are issued.
+func (b *grafanaBackend) updateSharedAccessPolicy(ctx context.Context, s logical.Storage, role *grafanaRoleEntry) []string {
+	policy := role.AccessPolicy
+	if policy == nil {
+		return nil
+	}
+
+	if !role.SharedAccessPolicy || policy.Connection != role.Connection || policy.Region != role.Region {
+		role.AccessPolicy = nil
+		return b.deleteSharedObject(ctx, s, policy.toManagedObject())
+	}
+
+	if policy.applied(role) {
+		return nil
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return []string{fmt.Sprintf("error creating client, the shared access policy is updated when credentials are issued: %s", err)}
+	}
+
+	err = syncSharedAccessPolicy(ctx, c, role)
+	if client.IsNotFound(err) {
+		// The access policy was deleted outside of Vault, a new one is created when credentials are issued.
+		role.AccessPolicy = nil
+		return nil
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("%s, it is updated again when credentials are issued", err)}
+	}
+
+	return nil
+}
+
+func mintSharedAccessPolicyToken(ctx context.Context, c *client.Grafana, policy *sharedAccessPolicy, credentialName string, expiresAt time.Time) (*grafanaToken, error) {
+	token, err := c.CreateCloudAccessPolicyToken(ctx, policy.Region, client.CreateCloudAccessPolicyTokenInput{
+		AccessPolicyID: policy.ID,
+		Name:           credentialName,
+		DisplayName:    credentialName,
+		ExpiresAt:      &expiresAt,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &grafanaToken{
+		IsCloud:             true,
+		Token:               token.Token,
+		Region:              policy.Region,
+		AccessPolicyID:      policy.ID,
+		AccessPolicyTokenID: token.ID,
+	}, nil
+}
@@ -0,0 +1,271 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// sharedServiceAccount is the service account owned by a role with shared_service_account set. Every lease of the
+// role gets a token of it, and revoking the lease deletes only that token.
+type sharedServiceAccount struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Connection string   `json:"connection"`
+	Stack      string   `json:"stack,omitempty"` // For service accounts in Grafana Cloud stacks
+	Role       string   `json:"role"`            // Basic role last applied to the service account
+	RBACRoles  []string `json:"rbac_roles"`      // RBAC roles last applied to the service account
+}
+
+func (a *sharedServiceAccount) toManagedObject() *managedObject {
+	return &managedObject{
+		Kind:             walKindServiceAccount,
+		Connection:       a.Connection,
+		Name:             a.Name,
+		Stack:            a.Stack,
+		ServiceAccountID: a.ID,
+	}
+}
+
+// createSharedServiceAccountToken mints a token of the shared service account of a role, creating the service account
+// first if the role has none yet. A service account that was deleted outside of Vault is replaced.
+func (b *grafanaBackend) createSharedServiceAccountToken(ctx context.Context, s logical.Storage, configType string, roleName string, credentialName string, expiresAt time.Time) (*grafanaToken, error) {
+	account, err := b.sharedServiceAccount(ctx, s, configType, roleName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := b.getClient(ctx, s, account.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := mintSharedServiceAccountToken(ctx, c, account, credentialName, expiresAt)
+	if client.IsNotFound(err) {
+		account, err = b.sharedServiceAccount(ctx, s, configType, roleName, account.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err = mintSharedServiceAccountToken(ctx, c, account, credentialName, expiresAt)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating service account token: %w", err)
+	}
+
+	token.Name = credentialName
+
+	return token, nil
+}
+
+// sharedServiceAccount returns the shared service account of a role, creating it if the role has none yet, and
+// applies the basic role and RBAC roles of the role to it if they changed since they were last applied. deletedID is
+// the ID of a service account that was found to be deleted outside of Vault and must be replaced, or 0.
+func (b *grafanaBackend) sharedServiceAccount(ctx context.Context, s logical.Storage, configType string, roleName string, deletedID int64) (*sharedServiceAccount, error) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if role == nil {
+		return nil, errors.New("error retrieving role: role is nil")
+	}
+
+	if role.ServiceAccount != nil && role.ServiceAccount.ID == deletedID {
+		b.Logger().Warn("shared service account of role was deleted outside of vault, creating a new one", "role", roleName, "service_account_id", deletedID)
+		role.ServiceAccount = nil
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	wal := b.newCredentialWAL(s, role.Connection)
+
+	var walID string
+
+	if role.ServiceAccount == nil {
+		role.ServiceAccount, walID, err = createSharedServiceAccount(ctx, c, wal, configType, roleName, role)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	syncErr := syncSharedServiceAccount(ctx, c, wal, role)
+
+	// The role is stored even if the sync failed, so that a new service account and the roles applied to it so far
+	// are not lost.
+	if err := setRole(ctx, s, roleName, role); err != nil {
+		return nil, err
+	}
+
+	// The service account now belongs to the role, so it must not be rolled back.
+	if walID != "" {
+		if err := wal.forget(ctx, walID); err != nil {
+			return nil, err
+		}
+	}
+
+	if syncErr != nil {
+		return nil, syncErr
+	}
+
+	return role.ServiceAccount, nil
+}
+
+// createSharedServiceAccount creates the shared service account of a role with the basic role of the role. It returns
+// the ID of the WAL entry recording the service account, which must be deleted once the role was stored.
+func createSharedServiceAccount(ctx context.Context, c *client.Grafana, wal *credentialWAL, configType string, roleName string, role *grafanaRoleEntry) (*sharedServiceAccount, string, error) {
+	account := &sharedServiceAccount{
+		Name:       sharedObjectNamePrefix + roleName,
+		Connection: role.Connection,
+		Stack:      role.serviceAccountStack(configType),
+		Role:       role.basicRole(),
+	}
+
+	walID, err := wal.record(ctx, walKindServiceAccount, walEntry{Name: account.Name, Stack: account.Stack})
+	if err != nil {
+		return nil, "", err
+	}
+
+	input := client.CreateServiceAccountInput{
+		Name: account.Name,
+		Role: account.Role,
+	}
+
+	if account.Stack != "" {
+		var serviceAccount *client.ServiceAccount
+		serviceAccount, err = c.CreateGrafanaServiceAccountFromCloud(ctx, account.Stack, input)
+		if err == nil {
+			account.ID = serviceAccount.ID
+		}
+	} else {
+		var serviceAccount client.ServiceAccount
+		serviceAccount, err = c.CreateServiceAccount(ctx, input)
+		account.ID = serviceAccount.ID
+	}
+
+	// The rollback of the WAL entry would delete the existing service account with the same name, which is not owned
+	// by the role.
+	if client.IsConflict(err) {
+		_ = wal.forget(ctx, walID)
+		return nil, "", fmt.Errorf("a service account named %s already exists, delete it so that the role can create its shared service account: %w", account.Name, err)
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating shared service account: %w", err)
+	}
+
+	return account, walID, nil
+}
+
+// syncSharedServiceAccount applies the basic role and RBAC roles of a role to its shared service account if they
+// changed since they were last applied.
+func syncSharedServiceAccount(ctx context.Context, c *client.Grafana, wal *credentialWAL, role *grafanaRoleEntry) error {
+	account := role.ServiceAccount
+
+	if account.Role != role.basicRole() {
+		input := client.UpdateServiceAccountInput{Role: role.basicRole()}
+
+		var err error
+
+		if account.Stack != "" {
+			_, err = c.UpdateGrafanaServiceAccountFromCloud(ctx, account.Stack, account.ID, input)
+		} else {
+			_, err = c.UpdateServiceAccount(ctx, account.ID, input)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error updating role of shared service account %s: %w", account.Name, err)
+		}
+
+		account.Role = role.basicRole()
+	}
+
+	if !slices.Equal(account.RBACRoles, role.RBACRoles) {
+		if err := setRBACRoles(ctx, c, wal, account.Stack, account.ID, role.RBACRoles); err != nil {
+			return fmt.Errorf("error updating rbac roles of shared service account %s: %w", account.Name, err)
+		}
+
+		account.RBACRoles = slices.Clone(role.RBACRoles)
+	}
+
+	return nil
+}
+
+// updateSharedServiceAccount is called when a role is written. It deletes the shared service account if the role no
+// longer uses it or now issues credentials for another Grafana instance or stack, and otherwise applies changes of
+// the basic role and RBAC roles to it. Errors are returned as warnings, as a failed update is retried when
+// credentials are issued.
+func (b *grafanaBackend) updateSharedServiceAccount(ctx context.Context, s logical.Storage, configType string, role *grafanaRoleEntry) []string {
+	account := role.ServiceAccount
+	if account == nil {
+		return nil
+	}
+
+	if !role.SharedServiceAccount || account.Connection != role.Connection || account.Stack != role.serviceAccountStack(configType) {
+		role.ServiceAccount = nil
+		return b.deleteSharedObject(ctx, s, account.toManagedObject())
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return []string{fmt.Sprintf("error creating client, the shared service account is updated when credentials are issued: %s", err)}
+	}
+
+	err = syncSharedServiceAccount(ctx, c, b.newCredentialWAL(s, role.Connection), role)
+	if client.IsNotFound(err) {
+		// The service account was deleted outside of Vault, a new one is created when credentials are issued.
+		role.ServiceAccount = nil
+		return nil
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("%s, it is updated again when credentials are issued", err)}
+	}
+
+	return nil
+}
+
+func mintSharedServiceAccountToken(ctx context.Context, c *client.Grafana, account *sharedServiceAccount, credentialName string, expiresAt time.Time) (*grafanaToken, error) {
+	input := client.CreateServiceAccountTokenInput{
+		Name:             credentialName,
+		ServiceAccountID: account.ID,
+		SecondsToLive:    secondsToLive(expiresAt),
+	}
+
+	var (
+		token *client.ServiceAccountToken
+		err   error
+	)
+
+	if account.Stack != "" {
+		token, err = c.CreateGrafanaServiceAccountTokenFromCloud(ctx, account.Stack, input)
+	} else {
+		var result client.ServiceAccountToken
+		result, err = c.CreateServiceAccountToken(ctx, input)
+		token = &result
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &grafanaToken{
+		IsCloud:          account.Stack != "",
+		Token:            token.Key,
+		Stack:            account.Stack,
+		ServiceAccountID: account.ID,
+		TokenID:          token.ID,
+	}, nil
+}
@@ -0,0 +1,327 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rotateStaticRoles is run by the periodic function. It rotates the tokens of static roles that are due and
+// deletes previous tokens whose grace period has ended.
+func (b *grafanaBackend) rotateStaticRoles(ctx context.Context, s logical.Storage) error {
+	roles, err := s.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return fmt.Errorf("error listing static roles: %w", err)
+	}
+
+	var errs []error
+
+	for _, name := range roles {
+		if err := b.rotateStaticRoleIfDue(ctx, s, name); err != nil {
+			b.Logger().Error("error rotating static role", "role", name, "error", err)
+			errs = append(errs, fmt.Errorf("error rotating static role %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *grafanaBackend) rotateStaticRoleIfDue(ctx context.Context, s logical.Storage, name string) error {
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	role, err := b.getStaticRole(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	if role == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	rotationDue := !now.Before(role.nextRotation())
+	deletionDue := role.PreviousToken != nil && !now.Before(role.PreviousTokenDeleteAt)
+
+	if !rotationDue && !deletionDue {
+		return nil
+	}
+
+	config, err := getConfig(ctx, s, role.Connection)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		return fmt.Errorf("connection %s is not configured", role.Connection)
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return err
+	}
+
+	if rotationDue {
+		if err := b.rotateStaticRole(ctx, c, config.Type, name, role); err != nil {
+			return err
+		}
+	} else {
+		if err := deleteStaticToken(ctx, c, config.Type, role, role.PreviousToken); err != nil {
+			return fmt.Errorf("error deleting previous token: %w", err)
+		}
+
+		role.PreviousToken = nil
+		role.PreviousTokenDeleteAt = time.Time{}
+	}
+
+	return setStaticRole(ctx, s, name, role)
+}
+
+// rotateStaticRole mints a new token for the static role. The current token becomes the previous token and is
+// kept until the grace period ends. A previous token that is still pending deletion is deleted first, so that
+// at most two tokens minted by Vault exist at any time.
+func (b *grafanaBackend) rotateStaticRole(ctx context.Context, c *client.Grafana, configType string, name string, role *grafanaStaticRoleEntry) error {
+	if role.PreviousToken != nil {
+		if err := deleteStaticToken(ctx, c, configType, role, role.PreviousToken); err != nil {
+			return fmt.Errorf("error deleting previous token: %w", err)
+		}
+
+		role.PreviousToken = nil
+		role.PreviousTokenDeleteAt = time.Time{}
+	}
+
+	now := time.Now()
+
+	token, err := mintStaticToken(ctx, c, configType, role, fmt.Sprintf("vault-static-%s-%d", name, now.Unix()))
+	if err != nil {
+		return err
+	}
+
+	if role.CurrentToken != nil {
+		role.PreviousToken = role.CurrentToken
+		role.PreviousTokenDeleteAt = now.Add(role.GracePeriod)
+
+		if role.GracePeriod == 0 {
+			// If the deletion fails, the periodic function will retry it as the deletion time has passed.
+			if err := deleteStaticToken(ctx, c, configType, role, role.PreviousToken); err == nil {
+				role.PreviousToken = nil
+				role.PreviousTokenDeleteAt = time.Time{}
+			}
+		}
+	}
+
+	role.CurrentToken = token
+	role.LastRotated = now
+
+	if role.Type == roleCloudAccessPolicy {
+		b.deleteStaleStaticTokens(ctx, c, name, role)
+	}
+
+	return nil
+}
+
+// deleteStaleStaticTokens deletes tokens that were minted for the static role by an earlier rotation, but are no
+// longer tracked, for example because the backend was interrupted before the rotation state was persisted. Tokens are
+// matched by their exact name, so that the tokens of a static role whose name starts with the same name are kept.
+// Errors are only logged, as the rotation itself succeeded.
+func (b *grafanaBackend) deleteStaleStaticTokens(ctx context.Context, c *client.Grafana, name string, role *grafanaStaticRoleEntry) {
+	tokens, err := c.ListCloudAccessPolicyTokens(ctx, role.Region, role.AccessPolicyID)
+	if err != nil {
+		b.Logger().Warn("error listing tokens of static role to delete stale ones", "role", name, "access_policy_id", role.AccessPolicyID, "error", err)
+		return
+	}
+
+	staleTokenName := regexp.MustCompile(fmt.Sprintf(`^vault-static-%s-\d+$`, regexp.QuoteMeta(name)))
+
+	for _, token := range tokens {
+		if !staleTokenName.MatchString(token.Name) {
+			continue
+		}
+
+		if token.ID == role.CurrentToken.ID || (role.PreviousToken != nil && token.ID == role.PreviousToken.ID) {
+			continue
+		}
+
+		if err := c.DeleteCloudAccessPolicyToken(ctx, role.Region, token.ID); err != nil && !client.IsNotFound(err) {
+			b.Logger().Warn("error deleting stale token of static role", "role", name, "token_id", token.ID, "access_policy_id", role.AccessPolicyID, "error", err)
+		}
+	}
+}
+
+func mintStaticToken(ctx context.Context, c *client.Grafana, configType string, role *grafanaStaticRoleEntry, tokenName string) (*staticToken, error) {
+	if role.Type == roleCloudAccessPolicy {
+		// The token expires once the grace period after its scheduled rotation has ended, so that a token is not
+		// left behind forever if the backend stops rotating it.
+		expiresAt := time.Now().Add(role.RotationPeriod + role.GracePeriod).UTC()
+
+		token, err := c.CreateCloudAccessPolicyToken(ctx, role.Region, client.CreateCloudAccessPolicyTokenInput{
+			AccessPolicyID: role.AccessPolicyID,
+			Name:           tokenName,
+			DisplayName:    tokenName,
+			ExpiresAt:      &expiresAt,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating cloud access policy token: %w", err)
+		}
+
+		if token.ExpiresAt != nil {
+			expiresAt = *token.ExpiresAt
+		}
+
+		return &staticToken{
+			ID:        token.ID,
+			Token:     token.Token,
+			CreatedAt: time.Now(),
+			ExpiresAt: &expiresAt,
+		}, nil
+	}
+
+	input := client.CreateServiceAccountTokenInput{
+		Name:             tokenName,
+		ServiceAccountID: role.ServiceAccountID,
+	}
+
+	var (
+		token *client.ServiceAccountToken
+		err   error
+	)
+
+	if configType == GrafanaCloudType {
+		token, err = c.CreateGrafanaServiceAccountTokenFromCloud(ctx, role.Stack, input)
+	} else {
+		var result client.ServiceAccountToken
+		result, err = c.CreateServiceAccountToken(ctx, input)
+		token = &result
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating service account token: %w", err)
+	}
+
+	return &staticToken{
+		ID:        strconv.FormatInt(token.ID, 10),
+		Token:     token.Key,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func deleteStaticToken(ctx context.Context, c *client.Grafana, configType string, role *grafanaStaticRoleEntry, token *staticToken) error {
+	if role.Type == roleCloudAccessPolicy {
+		return c.DeleteCloudAccessPolicyToken(ctx, role.Region, token.ID)
+	}
+
+	tokenID, err := strconv.ParseInt(token.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid service account token id %q: %w", token.ID, err)
+	}
+
+	if configType == GrafanaCloudType {
+		return c.DeleteGrafanaServiceAccountTokenFromCloud(ctx, role.Stack, role.ServiceAccountID, tokenID)
+	}
+
+	return c.DeleteServiceAccountToken(ctx, role.ServiceAccountID, tokenID)
+}
+
+// deleteStaticRoleTokens deletes all tokens minted for the static role and returns warnings for tokens that
+// could not be deleted.
+func (b *grafanaBackend) deleteStaticRoleTokens(ctx context.Context, s logical.Storage, role *grafanaStaticRoleEntry) []string {
+	if role.CurrentToken == nil && role.PreviousToken == nil {
+		return nil
+	}
+
+	config, err := getConfig(ctx, s, role.Connection)
+	if err != nil {
+		return []string{fmt.Sprintf("error reading configuration, tokens minted by this role were not deleted: %s", err)}
+	}
+
+	if config == nil {
+		return []string{fmt.Sprintf("connection %s is not configured, tokens minted by this role were not deleted", role.Connection)}
+	}
+
+	c, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return []string{fmt.Sprintf("error creating client, tokens minted by this role were not deleted: %s", err)}
+	}
+
+	var warnings []string
+
+	for _, token := range []*staticToken{role.CurrentToken, role.PreviousToken} {
+		if token == nil {
+			continue
+		}
+
+		if err := deleteStaticToken(ctx, c, config.Type, role, token); err != nil {
+			warnings = append(warnings, fmt.Sprintf("error deleting token %s: %s", token.ID, err))
+		}
+	}
+
+	return warnings
+}
+
+// checkStaticRoleTarget verifies that the service account or access policy adopted by the static role exists.
+func checkStaticRoleTarget(ctx context.Context, c *client.Grafana, configType string, role *grafanaStaticRoleEntry) error {
+	var err error
+
+	if role.Type == roleCloudAccessPolicy {
+		if _, err = c.GetCloudAccessPolicy(ctx, role.Region, role.AccessPolicyID); client.IsNotFound(err) {
+			return fmt.Errorf("access policy %s does not exist in region %s", role.AccessPolicyID, role.Region)
+		} else if err != nil {
+			return fmt.Errorf("error looking up access policy %s: %w", role.AccessPolicyID, err)
+		}
+
+		return nil
+	}
+
+	if configType == GrafanaCloudType {
+		_, err = c.GetGrafanaServiceAccountFromCloud(ctx, role.Stack, role.ServiceAccountID)
+	} else {
+		_, err = c.GetServiceAccount(ctx, role.ServiceAccountID)
+	}
+
+	if client.IsNotFound(err) {
+		return fmt.Errorf("service account %d does not exist", role.ServiceAccountID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error looking up service account %d: %w", role.ServiceAccountID, err)
+	}
+
+	return nil
+}
+
+func findServiceAccountIDByName(ctx context.Context, c *client.Grafana, configType string, stack string, name string) (int64, error) {
+	var (
+		serviceAccounts []client.ServiceAccount
+		err             error
+	)
+
+	if configType == GrafanaCloudType {
+		if stack == "" {
+			return 0, errors.New("stack must be set to look up a service account by name")
+		}
+
+		serviceAccounts, err = c.SearchGrafanaServiceAccountsFromCloud(ctx, stack, name)
+	} else {
+		serviceAccounts, err = c.SearchServiceAccounts(ctx, name)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error looking up service account %q: %w", name, err)
+	}
+
+	for _, serviceAccount := range serviceAccounts {
+		if serviceAccount.Name == name {
+			return serviceAccount.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("service account does not exist: %s", name)
+}
@@ -0,0 +1,334 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/template"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// orgRoleRanks orders the basic roles of Grafana organizations, so that the role of a user is only ever raised.
+var orgRoleRanks = map[string]int{
+	"None":   0,
+	"Viewer": 1,
+	"Editor": 2,
+	"Admin":  3,
+}
+
+// userElevation records the roles of an existing Grafana user before they were raised for a lease, so that revoking
+// the lease restores exactly these roles.
+type userElevation struct {
+	UserID               int64    `json:"user_id"`
+	Login                string   `json:"login"`
+	PreviousRole         string   `json:"previous_role"`           // Basic role of the user in the organization
+	RoleChanged          bool     `json:"role_changed"`            // Whether the basic role was raised
+	PreviousRBACRoleUIDs []string `json:"previous_rbac_role_uids"` // RBAC roles assigned to the user in the organization
+	RBACRolesChanged     bool     `json:"rbac_roles_changed"`      // Whether RBAC roles were assigned
+}
+
+// validateUserElevation checks the settings of a role raising the roles of existing Grafana users.
+func (r *grafanaRoleEntry) validateUserElevation() error {
+	if r.LoginTemplate == "" {
+		return fmt.Errorf(`login_template must be set when type is "%s"`, roleGrafanaUserElevation)
+	}
+
+	if _, err := template.NewTemplate(template.Template(r.LoginTemplate)); err != nil {
+		return fmt.Errorf("invalid login_template: %w", err)
+	}
+
+	if r.Role == "" && len(r.RBACRoles) == 0 {
+		return fmt.Errorf(`role or rbac_roles must be set when type is "%s"`, roleGrafanaUserElevation)
+	}
+
+	if rank, ok := orgRoleRanks[r.Role]; r.Role != "" && (!ok || rank == 0) {
+		return fmt.Errorf(`role must be "Viewer", "Editor" or "Admin" when type is "%s"`, roleGrafanaUserElevation)
+	}
+
+	if r.NameTemplate != "" {
+		return fmt.Errorf(`name_template is not supported when type is "%s", as no Grafana objects are created`, roleGrafanaUserElevation)
+	}
+
+	if r.NoLease {
+		return fmt.Errorf(`no_lease is not supported when type is "%s", as the roles of the user are restored when the lease is revoked`, roleGrafanaUserElevation)
+	}
+
+	return nil
+}
+
+// elevationLogin returns the login or email of the Grafana user elevated for a request, generated by the login template
+// of the role from the Vault entity requesting the credentials.
+func (b *grafanaBackend) elevationLogin(req *logical.Request, roleName string, role *grafanaRoleEntry) (string, error) {
+	data, err := b.templateData(req, roleName)
+	if err != nil {
+		return "", err
+	}
+
+	login, err := generateLogin(role.LoginTemplate, data)
+	if err != nil {
+		return "", fmt.Errorf("login_template of role %s did not generate a login: %w", roleName, err)
+	}
+
+	return login, nil
+}
+
+// createUserElevation raises the basic role of an existing Grafana user to the role of the role and assigns the RBAC
+// roles of the role in addition to the ones the user already has. A user can only be elevated by one lease at a time,
+// as the roles restored by a second lease would be the raised ones.
+func (b *grafanaBackend) createUserElevation(ctx context.Context, s logical.Storage, wal *credentialWAL, login string, credentialName string, roleEntry *grafanaRoleEntry) (*grafanaToken, error) {
+	c, err := b.getClient(ctx, s, roleEntry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	elevation, rbacRoleUIDs, err := planUserElevation(ctx, c, login, roleEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	walID, err := b.recordUserElevation(ctx, s, wal, elevation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyUserElevation(ctx, c, elevation, roleEntry.Role, rbacRoleUIDs); err != nil {
+		if err := restoreUserElevation(ctx, c, elevation); err != nil {
+			return nil, fmt.Errorf("error restoring roles of user %s after error raising them: %w", elevation.Login, err)
+		}
+
+		_ = wal.forget(ctx, walID)
+
+		return nil, err
+	}
+
+	return &grafanaToken{
+		Name:      credentialName,
+		IsCloud:   false,
+		Elevation: elevation,
+	}, nil
+}
+
+// planUserElevation looks up a user and its current roles, and returns how they are changed along with the UIDs of
+// the RBAC roles the user gets.
+func planUserElevation(ctx context.Context, c *client.Grafana, login string, roleEntry *grafanaRoleEntry) (*userElevation, []string, error) {
+	user, err := c.LookupUser(ctx, login)
+	if client.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("grafana user %s does not exist", login)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error looking up user %s: %w", login, err)
+	}
+
+	orgUsers, err := c.SearchOrgUsers(ctx, user.Login)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := slices.IndexFunc(orgUsers, func(orgUser client.OrgUser) bool { return orgUser.UserID == user.ID })
+	if index < 0 {
+		return nil, nil, fmt.Errorf("grafana user %s is not a member of the organization of the connection", user.Login)
+	}
+
+	elevation := &userElevation{
+		UserID:               user.ID,
+		Login:                user.Login,
+		PreviousRole:         orgUsers[index].Role,
+		PreviousRBACRoleUIDs: []string{},
+	}
+
+	elevation.RoleChanged = roleEntry.Role != "" && orgRoleRanks[roleEntry.Role] > orgRoleRanks[elevation.PreviousRole]
+
+	if len(roleEntry.RBACRoles) == 0 {
+		return elevation, nil, nil
+	}
+
+	assigned, err := c.GetUserRoleAssignments(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Global and hidden roles are not replaced when the RBAC roles of the organization are set, so they are left
+	// alone.
+	for _, role := range assigned {
+		if !role.Global && !role.Hidden && role.UID != "" {
+			elevation.PreviousRBACRoleUIDs = append(elevation.PreviousRBACRoleUIDs, role.UID)
+		}
+	}
+
+	roleUIDs, err := customRBACRoleNamesToIDs(ctx, c, roleEntry.RBACRoles)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error converting role names to IDs: %w", err)
+	}
+
+	rbacRoleUIDs := slices.Clone(elevation.PreviousRBACRoleUIDs)
+
+	for _, uid := range roleUIDs {
+		if !slices.Contains(rbacRoleUIDs, uid) {
+			rbacRoleUIDs = append(rbacRoleUIDs, uid)
+		}
+	}
+
+	elevation.RBACRolesChanged = len(rbacRoleUIDs) != len(elevation.PreviousRBACRoleUIDs)
+
+	return elevation, rbacRoleUIDs, nil
+}
+
+// recordUserElevation writes the WAL entry of an elevation, so that the roles of the user are restored if the
+// credentials are never returned. It fails if the user is already elevated by another lease or by credentials that
+// are being issued.
+func (b *grafanaBackend) recordUserElevation(ctx context.Context, s logical.Storage, wal *credentialWAL, elevation *userElevation) (string, error) {
+	b.elevationLock.Lock()
+	defer b.elevationLock.Unlock()
+
+	issued, err := listIssuedCredentials(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	for _, i := range issued {
+		if i.Connection == wal.connection && i.Elevation != nil && i.Elevation.UserID == elevation.UserID {
+			return "", fmt.Errorf("roles of grafana user %s are already raised by lease of role %s, which must be revoked first", elevation.Login, i.Role)
+		}
+	}
+
+	pending, err := pendingUserElevation(ctx, s, wal.connection, elevation.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	if pending {
+		return "", fmt.Errorf("roles of grafana user %s are being raised or restored, try again later", elevation.Login)
+	}
+
+	return wal.record(ctx, walKindUserElevation, walEntry{Name: elevation.Login, Elevation: elevation})
+}
+
+// pendingUserElevation reports whether a WAL entry of an elevation of the user was not rolled back yet.
+func pendingUserElevation(ctx context.Context, s logical.Storage, connection string, userID int64) (bool, error) {
+	ids, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		return false, fmt.Errorf("error listing WAL entries: %w", err)
+	}
+
+	for _, id := range ids {
+		wal, err := framework.GetWAL(ctx, s, id)
+		if err != nil {
+			return false, fmt.Errorf("error reading WAL entry: %w", err)
+		}
+
+		if wal == nil || wal.Kind != walKindUserElevation {
+			continue
+		}
+
+		entry, err := decodeWALEntry(wal.Data)
+		if err != nil {
+			return false, err
+		}
+
+		if entry.Connection == "" {
+			entry.Connection = defaultConnection
+		}
+
+		if entry.Connection == connection && entry.Elevation != nil && entry.Elevation.UserID == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// applyUserElevation raises the basic role of the user and assigns the RBAC roles as planned.
+func applyUserElevation(ctx context.Context, c *client.Grafana, elevation *userElevation, role string, rbacRoleUIDs []string) error {
+	if elevation.RoleChanged {
+		if err := c.UpdateOrgUserRole(ctx, elevation.UserID, role); err != nil {
+			return fmt.Errorf("error raising role of user %s: %w", elevation.Login, err)
+		}
+	}
+
+	if elevation.RBACRolesChanged {
+		err := c.SetServiceAccountRoleAssignments(ctx, client.ServiceAccountRoleAssignmentsInput{
+			ServiceAccountID: elevation.UserID,
+			RoleUIDs:         rbacRoleUIDs,
+		})
+
+		if err != nil {
+			return fmt.Errorf("error assigning rbac roles to user %s: %w", elevation.Login, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreUserElevation restores the basic role and RBAC roles a user had before they were raised. Roles that were not
+// changed are left alone, so that changes made in Grafana in the meantime are kept.
+func restoreUserElevation(ctx context.Context, c *client.Grafana, elevation *userElevation) error {
+	if elevation.RBACRolesChanged {
+		roleUIDs := elevation.PreviousRBACRoleUIDs
+		if roleUIDs == nil {
+			roleUIDs = []string{}
+		}
+
+		err := c.SetServiceAccountRoleAssignments(ctx, client.ServiceAccountRoleAssignmentsInput{
+			ServiceAccountID: elevation.UserID,
+			RoleUIDs:         roleUIDs,
+		})
+
+		if err != nil {
+			return fmt.Errorf("error restoring rbac roles of user %s: %w", elevation.Login, err)
+		}
+	}
+
+	if elevation.RoleChanged {
+		if err := c.UpdateOrgUserRole(ctx, elevation.UserID, elevation.PreviousRole); err != nil {
+			return fmt.Errorf("error restoring role of user %s: %w", elevation.Login, err)
+		}
+	}
+
+	return nil
+}
+
+// generateLogin renders the login template of a role. Keys missing from the entity metadata or aliases render as
+// "<no value>", which is reported as an error rather than looked up in Grafana.
+func generateLogin(loginTemplate string, data credentialNameData) (string, error) {
+	tmpl, err := template.NewTemplate(template.Template(loginTemplate))
+	if err != nil {
+		return "", fmt.Errorf("invalid login_template: %w", err)
+	}
+
+	login, err := tmpl.Generate(data)
+	if err != nil {
+		return "", err
+	}
+
+	login = strings.TrimSpace(login)
+
+	if login == "" || strings.Contains(login, "<no value>") {
+		return "", errors.New("the entity of the request has no value for it")
+	}
+
+	return login, nil
+}
+
+// decodeUserElevation decodes the elevation recorded in the internal data of a lease, which is read back from storage
+// as a map.
+func decodeUserElevation(data interface{}) (*userElevation, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding elevation internal data: %w", err)
+	}
+
+	var elevation userElevation
+
+	if err := json.Unmarshal(raw, &elevation); err != nil {
+		return nil, fmt.Errorf("error decoding elevation internal data: %w", err)
+	}
+
+	return &elevation, nil
+}
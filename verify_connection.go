@@ -0,0 +1,99 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+)
+
+var (
+	// requiredCloudScopes are the scopes the access policy owning the configured token must have in Grafana Cloud.
+	requiredCloudScopes = []string{
+		"accesspolicies:read",
+		"accesspolicies:write",
+		"accesspolicies:delete",
+		"stacks:read",
+		"stack-service-accounts:write",
+	}
+
+	// requiredGrafanaPermissions are the actions the service account owning the configured token must be allowed to
+	// perform in a Grafana instance.
+	requiredGrafanaPermissions = []string{
+		"serviceaccounts:create",
+		"serviceaccounts:write",
+		"serviceaccounts:delete",
+		"roles:read",
+	}
+)
+
+// verifyConnection checks that Grafana can be reached with the configured URL and token, and that the token has
+// the permissions required by the backend. For Grafana Cloud, the owner of the token is resolved as a side effect.
+func verifyConnection(ctx context.Context, c *client.Grafana, config *grafanaConfig) error {
+	var err error
+
+	if config.Type == GrafanaCloudType {
+		err = verifyCloudConnection(ctx, c, config)
+	} else {
+		err = verifyGrafanaConnection(ctx, c)
+	}
+
+	return explainAPIError(err)
+}
+
+func verifyCloudConnection(ctx context.Context, c *client.Grafana, config *grafanaConfig) error {
+	if err := resolveCloudTokenOwner(ctx, c, config); err != nil {
+		return fmt.Errorf("error looking up the access policy of the token: %w", err)
+	}
+
+	policy, err := c.GetCloudAccessPolicy(ctx, config.Region, config.AccessPolicyID)
+	if err != nil {
+		return fmt.Errorf("error looking up the access policy of the token: %w", err)
+	}
+
+	var missing []string
+
+	for _, scope := range requiredCloudScopes {
+		if !slices.Contains(policy.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("access policy %s is missing the following scopes: %s", policy.Name, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func verifyGrafanaConnection(ctx context.Context, c *client.Grafana) error {
+	health, err := c.Health(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking the health of the Grafana instance: %w", err)
+	}
+
+	if health.Database != "" && health.Database != "ok" {
+		return fmt.Errorf("the Grafana instance is unhealthy: database is %s", health.Database)
+	}
+
+	permissions, err := c.CurrentUserPermissions(ctx)
+	if err != nil {
+		return fmt.Errorf("error looking up the permissions of the token: %w", err)
+	}
+
+	var missing []string
+
+	for _, action := range requiredGrafanaPermissions {
+		if _, ok := permissions[action]; !ok {
+			missing = append(missing, action)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the token is missing the following permissions: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package vault_plugin_secrets_grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Boostport/vault-plugin-secrets-grafana/client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	walKindServiceAccount = "service_account"
+	walKindAccessPolicy   = "access_policy"
+	walKindUser           = "user"
+
+	// walKindUserElevation records the roles of an existing Grafana user before they are raised, rather than an
+	// object that is created.
+	walKindUserElevation = "user_elevation"
+
+	// walRollbackMinAge is how long a WAL entry is kept before it is rolled back. It must be longer than it can
+	// take to issue credentials, including retries.
+	walRollbackMinAge = 10 * time.Minute
+)
+
+// walEntry records a Grafana object before it is created while issuing credentials. The object is identified by
+// its name, so that it can be found and deleted even if the backend stopped before its ID was known.
+type walEntry struct {
+	Connection string `json:"connection"`
+	Name       string `json:"name"`
+	Stack      string `json:"stack,omitempty"`   // For service accounts in Grafana Cloud stacks
+	Region     string `json:"region,omitempty"`  // For Grafana Cloud access policies
+	UserID     int64  `json:"user_id,omitempty"` // For Grafana users, once they were created
+
+	Elevation *userElevation `json:"elevation,omitempty"` // For the raised roles of existing Grafana users
+}
+
+// credentialWAL tracks the WAL entries written while issuing credentials. The entries are deleted once the
+// credentials are returned, otherwise the objects they record are deleted by the rollback.
+type credentialWAL struct {
+	s           logical.Storage
+	connection  string
+	mountMarker string // Embedded in the names of temporary objects
+	ids         []string
+}
+
+func (b *grafanaBackend) newCredentialWAL(s logical.Storage, connection string) *credentialWAL {
+	return &credentialWAL{s: s, connection: connection, mountMarker: b.mountMarker}
+}
+
+// record writes a WAL entry for an object that is about to be created and returns the ID of the entry.
+func (w *credentialWAL) record(ctx context.Context, kind string, entry walEntry) (string, error) {
+	entry.Connection = w.connection
+
+	id, err := framework.PutWAL(ctx, w.s, kind, &entry)
+	if err != nil {
+		return "", fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	w.ids = append(w.ids, id)
+
+	return id, nil
+}
+
+// forget deletes a WAL entry once the object it records was deleted.
+func (w *credentialWAL) forget(ctx context.Context, id string) error {
+	if err := framework.DeleteWAL(ctx, w.s, id); err != nil {
+		return fmt.Errorf("error deleting WAL entry: %w", err)
+	}
+
+	w.ids = slices.DeleteFunc(w.ids, func(walID string) bool { return walID == id })
+
+	return nil
+}
+
+// release stops tracking a WAL entry without deleting it, so that commit leaves it behind and the rollback deletes
+// the object it records.
+func (w *credentialWAL) release(id string) {
+	w.ids = slices.DeleteFunc(w.ids, func(walID string) bool { return walID == id })
+}
+
+// commit deletes all remaining WAL entries, as the objects they record now belong to a lease.
+func (w *credentialWAL) commit(ctx context.Context) error {
+	for len(w.ids) > 0 {
+		if err := w.forget(ctx, w.ids[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walRollback deletes a Grafana object that was created for credentials that were never returned, or restores the
+// roles of a Grafana user that were raised for them.
+func (b *grafanaBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	entry, err := decodeWALEntry(data)
+	if err != nil {
+		return err
+	}
+
+	if entry.Connection == "" {
+		entry.Connection = defaultConnection
+	}
+
+	config, err := getConfig(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	// Nothing can be deleted once the connection is gone, so the entry is dropped.
+	if config == nil {
+		b.Logger().Warn("dropping WAL entry of a connection that is no longer configured", "kind", kind, "name", entry.Name, "connection", entry.Connection)
+		return nil
+	}
+
+	c, err := b.getClient(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case walKindServiceAccount:
+		err = rollbackServiceAccount(ctx, c, entry)
+	case walKindAccessPolicy:
+		err = rollbackAccessPolicy(ctx, c, entry)
+	case walKindUser:
+		err = rollbackUser(ctx, c, entry)
+	case walKindUserElevation:
+		err = rollbackUserElevation(ctx, c, entry)
+	default:
+		return fmt.Errorf("unknown WAL entry kind %q", kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	b.Logger().Info("rolled back partially created credentials", "kind", kind, "name", entry.Name, "connection", entry.Connection)
+
+	return nil
+}
+
+func rollbackServiceAccount(ctx context.Context, c *client.Grafana, entry walEntry) error {
+	var (
+		serviceAccounts []client.ServiceAccount
+		err             error
+	)
+
+	if entry.Stack != "" {
+		serviceAccounts, err = c.SearchGrafanaServiceAccountsFromCloud(ctx, entry.Stack, entry.Name)
+	} else {
+		serviceAccounts, err = c.SearchServiceAccounts(ctx, entry.Name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error looking up service account %s: %w", entry.Name, err)
+	}
+
+	for _, serviceAccount := range serviceAccounts {
+		if serviceAccount.Name != entry.Name {
+			continue
+		}
+
+		if entry.Stack != "" {
+			err = c.DeleteGrafanaServiceAccountFromCloud(ctx, entry.Stack, serviceAccount.ID)
+		} else {
+			err = c.DeleteServiceAccount(ctx, serviceAccount.ID)
+		}
+
+		if err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("error deleting service account %s: %w", entry.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func rollbackAccessPolicy(ctx context.Context, c *client.Grafana, entry walEntry) error {
+	policies, err := c.ListCloudAccessPolicies(ctx, entry.Region)
+	if err != nil {
+		return fmt.Errorf("error looking up access policy %s: %w", entry.Name, err)
+	}
+
+	for _, policy := range policies {
+		if policy.Name != entry.Name {
+			continue
+		}
+
+		if err := c.DeleteCloudAccessPolicy(ctx, entry.Region, policy.ID); err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("error deleting access policy %s: %w", entry.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func rollbackUser(ctx context.Context, c *client.Grafana, entry walEntry) error {
+	if entry.UserID != 0 {
+		if err := c.DeleteUser(ctx, entry.UserID); err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("error deleting user %s: %w", entry.Name, err)
+		}
+
+		return nil
+	}
+
+	user, err := c.LookupUser(ctx, entry.Name)
+	if client.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error looking up user %s: %w", entry.Name, err)
+	}
+
+	if err := c.DeleteUser(ctx, user.ID); err != nil && !client.IsNotFound(err) {
+		return fmt.Errorf("error deleting user %s: %w", entry.Name, err)
+	}
+
+	return nil
+}
+
+func rollbackUserElevation(ctx context.Context, c *client.Grafana, entry walEntry) error {
+	if entry.Elevation == nil {
+		return nil
+	}
+
+	if err := restoreUserElevation(ctx, c, entry.Elevation); err != nil && !client.IsNotFound(err) {
+		return fmt.Errorf("error restoring roles of user %s: %w", entry.Name, err)
+	}
+
+	return nil
+}
+
+// pendingWALNames returns the names of the objects recorded by WAL entries that were not rolled back yet.
+func pendingWALNames(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	ids, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("error listing WAL entries: %w", err)
+	}
+
+	names := map[string]bool{}
+
+	for _, id := range ids {
+		wal, err := framework.GetWAL(ctx, s, id)
+		if err != nil {
+			return nil, fmt.Errorf("error reading WAL entry: %w", err)
+		}
+
+		if wal == nil {
+			continue
+		}
+
+		entry, err := decodeWALEntry(wal.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		names[entry.Name] = true
+	}
+
+	return names, nil
+}
+
+// decodeWALEntry decodes the data of a WAL entry, which is read back from storage as a map.
+func decodeWALEntry(data interface{}) (walEntry, error) {
+	var entry walEntry
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return entry, fmt.Errorf("error encoding WAL entry: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return entry, fmt.Errorf("error decoding WAL entry: %w", err)
+	}
+
+	return entry, nil
+}